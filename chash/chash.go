@@ -0,0 +1,117 @@
+// package chash contains consistent hash rings, for selecting caches so the same content is always requested from the same caches.
+//
+// The hashes and server selection are those of the Java Traffic Router, so a client gets the same caches from either,
+// and caches keep their content during a migration.
+package chash
+
+import (
+	"crypto/md5"
+	"sort"
+	"strconv"
+)
+
+// DefaultHashCount is the number of points per server on a ring, when the CRConfig server has no hashCount.
+// This is the Traffic Ops default weight 0.999 times the default weight multiplier 1000.
+const DefaultHashCount = 999
+
+// Hash returns the hash of s, as the Java Traffic Router MD5HashFunction computes it:
+// the first 4 bytes of the MD5 digest, as a little-endian unsigned integer.
+func Hash(s string) uint32 {
+	return hashBytes([]byte(s))
+}
+
+func hashBytes(b []byte) uint32 {
+	digest := md5.Sum(b)
+	return uint32(digest[3])<<24 | uint32(digest[2])<<16 | uint32(digest[1])<<8 | uint32(digest[0])
+}
+
+// Points returns the hashCount points of the server with the given hash ID on the ring.
+//
+// As in the Java Traffic Router DefaultHashable, point i is the hash of the hash ID, "--", and i, each hashed independently,
+// so a server's points only depend on its own hash ID and count, and adding or removing a server doesn't move any others.
+//
+func Points(hashID string, hashCount int) []uint32 {
+	if hashCount <= 0 {
+		return nil
+	}
+	points := make([]uint32, 0, hashCount)
+	buf := make([]byte, 0, len(hashID)+len("--")+len(strconv.Itoa(hashCount)))
+	buf = append(buf, hashID...)
+	buf = append(buf, "--"...)
+	prefixLen := len(buf)
+	for i := 0; i < hashCount; i++ {
+		buf = strconv.AppendInt(buf[:prefixLen], int64(i), 10)
+		points = append(points, hashBytes(buf))
+	}
+	return points
+}
+
+// Server is a server to add to a Ring.
+type Server struct {
+	HashID    string
+	HashCount int
+}
+
+// Ring is a consistent hash ring of servers. Servers are identified by their index in the list the Ring was created with.
+//
+// A Ring is immutable after creation, and may safely be used by multiple goroutines.
+type Ring struct {
+	points     []point
+	numServers int
+}
+
+type point struct {
+	hash   uint32
+	server int
+}
+
+// NewRing creates a new Ring of the given servers.
+func NewRing(servers []Server) *Ring {
+	ring := &Ring{numServers: len(servers)}
+	for serverI, server := range servers {
+		for _, hash := range Points(server.HashID, server.HashCount) {
+			ring.points = append(ring.points, point{hash: hash, server: serverI})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		if ring.points[i].hash != ring.points[j].hash {
+			return ring.points[i].hash < ring.points[j].hash
+		}
+		return ring.points[i].server < ring.points[j].server
+	})
+	return ring
+}
+
+// Walk calls fn with the index of each server on the ring, in order of the distance from hash to the server's nearest point,
+// until fn returns false or every server has been visited. Each server is visited once.
+//
+// This is the order the Java Traffic Router's ConsistentHasher sorts servers in.
+// Callers skip unavailable servers by returning true without using them, which continues to the next nearest server.
+//
+func (ri *Ring) Walk(hash uint32, fn func(server int) bool) {
+	if ri == nil || ri.numServers == 0 {
+		return
+	}
+	visited := make([]bool, ri.numServers)
+	numVisited := 0
+	hi := sort.Search(len(ri.points), func(i int) bool { return ri.points[i].hash >= hash })
+	lo := hi - 1
+	for numVisited < ri.numServers && (lo >= 0 || hi < len(ri.points)) {
+		pt := point{}
+		if lo < 0 || (hi < len(ri.points) && ri.points[hi].hash-hash <= hash-ri.points[lo].hash) {
+			pt = ri.points[hi]
+			hi++
+		} else {
+			pt = ri.points[lo]
+			lo--
+		}
+		if visited[pt.server] {
+			continue
+		}
+		visited[pt.server] = true
+		numVisited++
+		if !fn(pt.server) {
+			return
+		}
+	}
+}
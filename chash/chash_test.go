@@ -0,0 +1,112 @@
+package chash
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The expected values below were computed independently of this package, with Python's hashlib, per the Java Traffic Router
+// MD5HashFunction, DefaultHashable, and ConsistentHasher algorithms. They're known answers: if they change, clients get different caches
+// than from the Java Traffic Router.
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected uint32
+	}{
+		{"", 3649838548}, // MD5 d41d8cd9..., little-endian
+		{"edge1", 1286713507},
+		{"edge1--0", 2601901323},
+		{"/path/to/content.mp4", 1807738810},
+	}
+	for _, test := range tests {
+		if got := Hash(test.s); got != test.expected {
+			t.Errorf("Hash('%s') = %d, expected %d", test.s, got, test.expected)
+		}
+	}
+}
+
+func TestPoints(t *testing.T) {
+	tests := []struct {
+		hashID    string
+		hashCount int
+		expected  []uint32
+	}{
+		{"edge1", 4, []uint32{2601901323, 1137932935, 3073969387, 2187829375}},
+		{"edge2", 4, []uint32{3879076051, 547765586, 3577069135, 1656790228}},
+		{"edge1", 1, []uint32{2601901323}},
+		{"edge1", 0, nil},
+	}
+	for _, test := range tests {
+		if got := Points(test.hashID, test.hashCount); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Points('%s', %d) = %v, expected %v", test.hashID, test.hashCount, got, test.expected)
+		}
+	}
+}
+
+func TestPointsIndependent(t *testing.T) {
+	// each point only depends on its own index, so a server's first points don't change with its count
+	many := Points("edge1", 1000)
+	few := Points("edge1", 10)
+	if !reflect.DeepEqual(many[:10], few) {
+		t.Errorf("first 10 of 1000 points %v, expected the 10 points %v", many[:10], few)
+	}
+	if got, expected := many[999], Hash("edge1--999"); got != expected {
+		t.Errorf("point 999 = %d, expected Hash('edge1--999') %d", got, expected)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ring := NewRing([]Server{
+		{HashID: "edge1", HashCount: 100},
+		{HashID: "edge2", HashCount: 100},
+		{HashID: "edge3", HashCount: 50},
+		{HashID: "edge4", HashCount: 10},
+	})
+	tests := []struct {
+		key      string
+		expected []int
+	}{
+		{"/path/to/content.mp4", []int{1, 0, 3, 2}},
+		{"/other.m3u8", []int{0, 1, 2, 3}},
+		{"192.0.2.1", []int{3, 0, 1, 2}},
+	}
+	for _, test := range tests {
+		got := []int{}
+		ring.Walk(Hash(test.key), func(server int) bool {
+			got = append(got, server)
+			return true
+		})
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Walk('%s') = %v, expected %v", test.key, got, test.expected)
+		}
+	}
+}
+
+func TestWalkStops(t *testing.T) {
+	ring := NewRing([]Server{{HashID: "edge1", HashCount: 100}, {HashID: "edge2", HashCount: 100}, {HashID: "edge3", HashCount: 50}})
+	visited := 0
+	ring.Walk(Hash("/path/to/content.mp4"), func(server int) bool {
+		visited++
+		return visited < 2
+	})
+	if visited != 2 {
+		t.Errorf("Walk visited %d servers after fn returned false on the second, expected 2", visited)
+	}
+}
+
+func TestWalkEmpty(t *testing.T) {
+	for _, ring := range []*Ring{nil, NewRing(nil), NewRing([]Server{{HashID: "edge1", HashCount: 0}})} {
+		ring.Walk(Hash("x"), func(server int) bool {
+			t.Errorf("Walk on an empty ring visited server %d", server)
+			return true
+		})
+	}
+}
+
+func BenchmarkPoints(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Points("edge1.cdn.example.net", DefaultHashCount)
+	}
+}
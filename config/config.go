@@ -15,13 +15,39 @@ type Config struct {
 	CZFPath      string `json:"czf_path"`
 	CRConfigPath string `json:"crconfig_path"`
 	CRStatesPath string `json:"crstates_path"`
+	// DeepCZFPath is the Deep Coverage Zone File, for deep caching Delivery Services. If empty, deep caching is disabled.
+	DeepCZFPath string `json:"deep_czf_path"`
 	// CertDir is the directory of HTTPS Certificates.
 	// Certificates must be key and cert file pairs, named fqdn.crt and fqdn.key.
 	// Wildcard certificates should use a # for the wildcard (since * is not a valid filename on some operating systems). For example, '#.example.net.crt'.
-	CertDir                string   `json:"cert_dir"`
+	CertDir string `json:"cert_dir"`
+	// Monitors are the Traffic Monitors to poll on startup, as FQDNs, FQDN:port, or http or https URLs.
+	// Once a CRConfig is loaded, its ONLINE monitors are polled instead, with the scheme of the first of these.
 	Monitors               []string `json:"monitor_fqdns"`
 	CRStatesPollIntervalMS int      `json:"crstates_poll_interval_ms"`
 	CRConfigPollIntervalMS int      `json:"crconfig_poll_interval_ms"`
+	// StateDir is the directory the last-known-good polled CRConfig and CRStates are written to, and loaded from on startup if they're newer than the CRConfigPath and CRStatesPath files.
+	// If empty, polled snapshots aren't persisted.
+	StateDir string `json:"state_dir"`
+	// CRConfigGuardMaxDropPercent is the maximum percent the number of Delivery Services, servers, or cachegroups may drop in a new CRConfig,
+	// before it's refused and the old CRConfig continues to be served. If 0, guard.DefaultMaxDropPercent is used. If negative, drops are never refused.
+	// A new CRConfig with a different config/domain_name is always refused.
+	CRConfigGuardMaxDropPercent int `json:"crconfig_guard_max_drop_percent"`
+	// CRConfigDiffHistorySize is the number of CRConfig diffs kept for the admin server. If 0, crconfigdiff.DefaultHistorySize is used.
+	CRConfigDiffHistorySize int `json:"crconfig_diff_history_size"`
+	// AdminAddr is the address to serve the admin HTTP server on, e.g. "127.0.0.1:8080". If empty, the admin server is disabled.
+	// It should never be reachable by clients. Changing it requires a restart.
+	AdminAddr string `json:"admin_addr"`
+	// MonitorConnectTimeoutMS is the timeout to connect to a Traffic Monitor. If 0, monitorclient.DefaultConnectTimeout is used.
+	MonitorConnectTimeoutMS int `json:"monitor_connect_timeout_ms"`
+	// MonitorTimeoutMS is the timeout of a whole Traffic Monitor request, including reading the body. If 0, monitorclient.DefaultTimeout is used.
+	MonitorTimeoutMS int `json:"monitor_timeout_ms"`
+	// DNSSECKeyDir is the directory of DNSSEC keys for the CDN domain. If empty, DNSSEC is disabled.
+	// Keys must be in the BIND format, pairs of Kzone.+alg+tag.key and Kzone.+alg+tag.private files. Keys with the SEP flag are Key Signing Keys.
+	DNSSECKeyDir string `json:"dnssec_key_dir"`
+	// GeolocationMaxMindPath is the MaxMind GeoIP2 or GeoLite2 City database file, used to locate clients not in the CZF.
+	// If empty, clients not in the CZF can't be routed.
+	GeolocationMaxMindPath string `json:"geolocation_maxmind_path"`
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -0,0 +1,56 @@
+package crconfig
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// StateCRConfigFileName is the name of the last-known-good CRConfig file in the state directory.
+const StateCRConfigFileName = "crconfig.json"
+
+// StateCRStatesFileName is the name of the last-known-good CRStates file in the state directory.
+const StateCRStatesFileName = "crstates.json"
+
+// WriteStateFile atomically writes the raw JSON of a successfully applied CRConfig or CRStates to the file name in the state directory dir.
+//
+// The data is written to a temporary file in dir, synced, and renamed over the old file,
+// so a crash while writing leaves the previous file intact, never a partial one.
+//
+func WriteStateFile(dir string, name string, data []byte) error {
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return errors.New("creating temp file: " + err.Error())
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Chmod(0644); err != nil { // TempFile creates 0600, but these aren't secret, and operators may want to read them
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.New("setting temp file mode: " + err.Error())
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.New("writing temp file: " + err.Error())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return errors.New("syncing temp file: " + err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return errors.New("closing temp file: " + err.Error())
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return errors.New("renaming temp file: " + err.Error())
+	}
+	// sync the directory, so the rename itself survives a crash
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
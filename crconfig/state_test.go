@@ -0,0 +1,47 @@
+package crconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteStateFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, data := range []string{`{"first": true}`, `{"second": true}`} {
+		if err := WriteStateFile(dir, StateCRConfigFileName, []byte(data)); err != nil {
+			t.Fatalf("WriteStateFile: %v", err)
+		}
+		path := filepath.Join(dir, StateCRConfigFileName)
+		bts, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading state file: %v", err)
+		}
+		if string(bts) != data {
+			t.Errorf("state file '%s', expected '%s'", string(bts), data)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != 0644 {
+			t.Errorf("state file mode %o, expected 644", mode)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		names := []string{}
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("state directory has %v, expected only the state file, with no temp files left", names)
+	}
+
+	if err := WriteStateFile(filepath.Join(dir, "missing"), StateCRConfigFileName, []byte("{}")); err == nil {
+		t.Error("WriteStateFile to a missing directory succeeded, expected an error")
+	}
+}
@@ -0,0 +1,320 @@
+// package crconfigdiff contains a diff of CRConfigs, for logging and inspecting what changed in each new CRConfig.
+package crconfigdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// DefaultHistorySize is the number of diffs kept by a History, if it's created with no size.
+const DefaultHistorySize = 10
+
+// Diff is the difference between two CRConfigs.
+type Diff struct {
+	// Time is when the new CRConfig was applied.
+	Time time.Time `json:"time"`
+	// Source is where the new CRConfig came from, e.g. "poll" or "reload".
+	Source           string  `json:"source"`
+	DeliveryServices Changes `json:"deliveryServices"`
+	Servers          Changes `json:"servers"`
+	Routers          Changes `json:"routers"`
+	Config           Changes `json:"config"`
+}
+
+// Changes is the added, removed, and modified objects of one section of the CRConfig, by name.
+type Changes struct {
+	Added    []string       `json:"added,omitempty"`
+	Removed  []string       `json:"removed,omitempty"`
+	Modified []Modification `json:"modified,omitempty"`
+}
+
+// Modification is an object in both CRConfigs which changed, with a description of each changed field.
+type Modification struct {
+	Name    string   `json:"name"`
+	Changes []string `json:"changes"`
+}
+
+// New returns the diff from oldCRC to newCRC. If oldCRC is nil, everything in newCRC is added.
+func New(oldCRC *tc.CRConfig, newCRC *tc.CRConfig, source string) Diff {
+	if oldCRC == nil {
+		oldCRC = &tc.CRConfig{}
+	}
+	if newCRC == nil {
+		newCRC = &tc.CRConfig{}
+	}
+	diff := Diff{Time: time.Now(), Source: source}
+
+	diff.DeliveryServices = diffMaps(len(oldCRC.DeliveryServices)+len(newCRC.DeliveryServices), func(add func(name string, oldObj interface{}, newObj interface{})) {
+		for name, ds := range oldCRC.DeliveryServices {
+			add(name, ds, nil)
+		}
+		for name, ds := range newCRC.DeliveryServices {
+			add(name, nil, ds)
+		}
+	}, fieldChanges)
+
+	diff.Servers = diffMaps(len(oldCRC.ContentServers)+len(newCRC.ContentServers), func(add func(name string, oldObj interface{}, newObj interface{})) {
+		for name, sv := range oldCRC.ContentServers {
+			add(name, sv, nil)
+		}
+		for name, sv := range newCRC.ContentServers {
+			add(name, nil, sv)
+		}
+	}, serverChanges)
+
+	diff.Routers = diffMaps(len(oldCRC.ContentRouters)+len(newCRC.ContentRouters), func(add func(name string, oldObj interface{}, newObj interface{})) {
+		for name, rt := range oldCRC.ContentRouters {
+			add(name, rt, nil)
+		}
+		for name, rt := range newCRC.ContentRouters {
+			add(name, nil, rt)
+		}
+	}, fieldChanges)
+
+	diff.Config = diffMaps(len(oldCRC.Config)+len(newCRC.Config), func(add func(name string, oldObj interface{}, newObj interface{})) {
+		for key, val := range oldCRC.Config {
+			add(key, val, nil)
+		}
+		for key, val := range newCRC.Config {
+			add(key, nil, val)
+		}
+	}, valueChanges)
+
+	return diff
+}
+
+// diffMaps diffs two maps of objects. The each func calls add with every name and object of the old map, then of the new map.
+// The changes func returns the description of each changed field of an object in both maps, or nil if it didn't change.
+func diffMaps(sizeHint int, each func(add func(name string, oldObj interface{}, newObj interface{})), changes func(oldObj interface{}, newObj interface{}) []string) Changes {
+	type objPair struct {
+		old interface{}
+		new interface{}
+	}
+	pairs := make(map[string]*objPair, sizeHint)
+	each(func(name string, oldObj interface{}, newObj interface{}) {
+		pair, ok := pairs[name]
+		if !ok {
+			pair = &objPair{}
+			pairs[name] = pair
+		}
+		if oldObj != nil {
+			pair.old = oldObj
+		}
+		if newObj != nil {
+			pair.new = newObj
+		}
+	})
+
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ch := Changes{}
+	for _, name := range names {
+		pair := pairs[name]
+		switch {
+		case pair.old == nil:
+			ch.Added = append(ch.Added, name)
+		case pair.new == nil:
+			ch.Removed = append(ch.Removed, name)
+		default:
+			if fieldChs := changes(pair.old, pair.new); len(fieldChs) > 0 {
+				ch.Modified = append(ch.Modified, Modification{Name: name, Changes: fieldChs})
+			}
+		}
+	}
+	return ch
+}
+
+// fieldChanges returns the changed JSON fields of two objects. Scalar fields include the old and new values.
+func fieldChanges(oldObj interface{}, newObj interface{}) []string {
+	oldFields, newFields := jsonFields(oldObj), jsonFields(newObj)
+	keys := map[string]struct{}{}
+	for key := range oldFields {
+		keys[key] = struct{}{}
+	}
+	for key := range newFields {
+		keys[key] = struct{}{}
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	changes := []string{}
+	for _, key := range sortedKeys {
+		oldVal, newVal := oldFields[key], newFields[key]
+		if bytes.Equal(oldVal, newVal) {
+			continue
+		}
+		if isScalar(oldVal) && isScalar(newVal) {
+			changes = append(changes, key+" "+scalarStr(oldVal)+" -> "+scalarStr(newVal))
+			continue
+		}
+		changes = append(changes, key+" changed")
+	}
+	return changes
+}
+
+// serverChanges returns the changed fields of two servers, like fieldChanges, but with the added and removed Delivery Service assignments.
+func serverChanges(oldObj interface{}, newObj interface{}) []string {
+	changes := []string{}
+	for _, change := range fieldChanges(oldObj, newObj) {
+		if change != "deliveryServices changed" {
+			changes = append(changes, change)
+		}
+	}
+	oldSv, oldOK := oldObj.(tc.CRConfigTrafficOpsServer)
+	newSv, newOK := newObj.(tc.CRConfigTrafficOpsServer)
+	if !oldOK || !newOK {
+		return changes
+	}
+	added, removed := []string{}, []string{}
+	for ds := range newSv.DeliveryServices {
+		if _, ok := oldSv.DeliveryServices[ds]; !ok {
+			added = append(added, ds)
+		}
+	}
+	for ds := range oldSv.DeliveryServices {
+		if _, ok := newSv.DeliveryServices[ds]; !ok {
+			removed = append(removed, ds)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) > 0 {
+		changes = append(changes, "deliveryServices added "+strings.Join(added, ","))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "deliveryServices removed "+strings.Join(removed, ","))
+	}
+	if len(added) == 0 && len(removed) == 0 && !reflect.DeepEqual(oldSv.DeliveryServices, newSv.DeliveryServices) {
+		changes = append(changes, "deliveryServices fqdns changed")
+	}
+	return changes
+}
+
+// valueChanges returns the change of a config value, or nil if it didn't change.
+func valueChanges(oldVal interface{}, newVal interface{}) []string {
+	if reflect.DeepEqual(oldVal, newVal) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%v -> %v", oldVal, newVal)}
+}
+
+// jsonFields returns the JSON encoding of each field of obj. Returns an empty map if obj isn't a JSON object.
+func jsonFields(obj interface{}) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	bts, err := json.Marshal(obj)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(bts, &fields)
+	return fields
+}
+
+// isScalar returns whether the JSON value is a scalar, not an object or array. A missing value is a scalar.
+func isScalar(val json.RawMessage) bool {
+	return len(val) == 0 || (val[0] != '{' && val[0] != '[')
+}
+
+// scalarStr returns the JSON scalar as a string for logging, or "null" if it's missing.
+func scalarStr(val json.RawMessage) string {
+	if len(val) == 0 {
+		return "null"
+	}
+	return string(val)
+}
+
+// Empty returns whether nothing changed.
+func (di Diff) Empty() bool {
+	return di.DeliveryServices.Empty() && di.Servers.Empty() && di.Routers.Empty() && di.Config.Empty()
+}
+
+// Empty returns whether nothing was added, removed, or modified.
+func (ch Changes) Empty() bool {
+	return len(ch.Added) == 0 && len(ch.Removed) == 0 && len(ch.Modified) == 0
+}
+
+// String returns the human-readable diff, one change per line.
+func (di Diff) String() string {
+	if di.Empty() {
+		return "no changes"
+	}
+	sb := strings.Builder{}
+	writeChanges(&sb, "ds", di.DeliveryServices)
+	writeChanges(&sb, "server", di.Servers)
+	writeChanges(&sb, "router", di.Routers)
+	writeChanges(&sb, "config", di.Config)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeChanges(sb *strings.Builder, kind string, ch Changes) {
+	for _, name := range ch.Added {
+		sb.WriteString("+ " + kind + " '" + name + "'\n")
+	}
+	for _, name := range ch.Removed {
+		sb.WriteString("- " + kind + " '" + name + "'\n")
+	}
+	for _, mod := range ch.Modified {
+		sb.WriteString("~ " + kind + " '" + mod.Name + "': " + strings.Join(mod.Changes, ", ") + "\n")
+	}
+}
+
+// History is the most recent Diffs, for the admin server.
+// A History may safely be used by multiple goroutines.
+type History struct {
+	size  int
+	diffs []Diff
+	mutex sync.Mutex
+}
+
+// NewHistory creates a new History keeping the given number of diffs. If size is 0 or less, DefaultHistorySize is used.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{size: size}
+}
+
+// Add adds the diff, dropping the oldest if the History is full.
+func (hi *History) Add(diff Diff) {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+	hi.diffs = append(hi.diffs, diff)
+	if len(hi.diffs) > hi.size {
+		hi.diffs = append([]Diff(nil), hi.diffs[len(hi.diffs)-hi.size:]...)
+	}
+}
+
+// Get returns the diffs, newest first.
+func (hi *History) Get() []Diff {
+	hi.mutex.Lock()
+	defer hi.mutex.Unlock()
+	diffs := make([]Diff, 0, len(hi.diffs))
+	for i := len(hi.diffs) - 1; i >= 0; i-- {
+		diffs = append(diffs, hi.diffs[i])
+	}
+	return diffs
+}
+
+// Log logs the diff at INFO, and adds it to the history if anything changed.
+func (hi *History) Log(diff Diff) {
+	if diff.Empty() {
+		fmt.Println("INFO CRConfig from " + diff.Source + " applied: no Delivery Service, server, router, or config changes")
+		return
+	}
+	fmt.Println("INFO CRConfig from " + diff.Source + " applied, changes:\n" + diff.String())
+	hi.Add(diff)
+}
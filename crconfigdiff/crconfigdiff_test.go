@@ -0,0 +1,173 @@
+package crconfigdiff
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func strPtr(s string) *string { return &s }
+
+// testCRConfig returns a CRConfig with DSes ds1 and ds2, servers edge1 and edge2, router tr1, and a domain_name.
+func testCRConfig() *tc.CRConfig {
+	ttl := 30
+	return &tc.CRConfig{
+		Config: map[string]interface{}{"domain_name": "cdn.example.net", "ttl": "60"},
+		DeliveryServices: map[string]tc.CRConfigDeliveryService{
+			"ds1": {RoutingName: strPtr("edge"), TTL: &ttl},
+			"ds2": {RoutingName: strPtr("ccr")},
+		},
+		ContentServers: map[string]tc.CRConfigTrafficOpsServer{
+			"edge1": {Ip: strPtr("10.0.0.1"), CacheGroup: strPtr("cg1"), DeliveryServices: map[string][]string{"ds1": {"edge.ds1.cdn.example.net"}}},
+			"edge2": {Ip: strPtr("10.0.0.2"), CacheGroup: strPtr("cg1"), DeliveryServices: map[string][]string{"ds1": {"edge.ds1.cdn.example.net"}, "ds2": {"edge2.ds2.cdn.example.net"}}},
+		},
+		ContentRouters: map[string]tc.CRConfigRouter{
+			"tr1": {IP: strPtr("10.0.1.1"), Location: strPtr("cg1")},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(crc *tc.CRConfig)
+		expected Diff
+	}{
+		{
+			name:     "no changes",
+			modify:   func(crc *tc.CRConfig) {},
+			expected: Diff{},
+		},
+		{
+			name: "ds added and removed",
+			modify: func(crc *tc.CRConfig) {
+				delete(crc.DeliveryServices, "ds2")
+				crc.DeliveryServices["ds3"] = tc.CRConfigDeliveryService{}
+			},
+			expected: Diff{DeliveryServices: Changes{Added: []string{"ds3"}, Removed: []string{"ds2"}}},
+		},
+		{
+			name: "ds scalar and object fields",
+			modify: func(crc *tc.CRConfig) {
+				ttl := 60
+				ds := crc.DeliveryServices["ds1"]
+				ds.TTL = &ttl
+				ds.RoutingName = nil
+				ds.Domains = []string{"ds1.cdn.example.net"}
+				crc.DeliveryServices["ds1"] = ds
+			},
+			expected: Diff{DeliveryServices: Changes{Modified: []Modification{{Name: "ds1", Changes: []string{"domains changed", `routingName "edge" -> null`, "ttl 30 -> 60"}}}}},
+		},
+		{
+			name: "server fields and ds assignments",
+			modify: func(crc *tc.CRConfig) {
+				sv := crc.ContentServers["edge1"]
+				sv.Ip = strPtr("10.0.0.9")
+				sv.DeliveryServices = map[string][]string{"ds2": {"edge1.ds2.cdn.example.net"}}
+				crc.ContentServers["edge1"] = sv
+			},
+			expected: Diff{Servers: Changes{Modified: []Modification{{Name: "edge1", Changes: []string{`ip "10.0.0.1" -> "10.0.0.9"`, "deliveryServices added ds2", "deliveryServices removed ds1"}}}}},
+		},
+		{
+			name: "server ds fqdns",
+			modify: func(crc *tc.CRConfig) {
+				crc.ContentServers["edge1"] = tc.CRConfigTrafficOpsServer{Ip: strPtr("10.0.0.1"), CacheGroup: strPtr("cg1"), DeliveryServices: map[string][]string{"ds1": {"other.ds1.cdn.example.net"}}}
+			},
+			expected: Diff{Servers: Changes{Modified: []Modification{{Name: "edge1", Changes: []string{"deliveryServices fqdns changed"}}}}},
+		},
+		{
+			name: "router removed and added",
+			modify: func(crc *tc.CRConfig) {
+				delete(crc.ContentRouters, "tr1")
+				crc.ContentRouters["tr2"] = tc.CRConfigRouter{IP: strPtr("10.0.1.2")}
+			},
+			expected: Diff{Routers: Changes{Added: []string{"tr2"}, Removed: []string{"tr1"}}},
+		},
+		{
+			name: "config values",
+			modify: func(crc *tc.CRConfig) {
+				crc.Config["ttl"] = "120"
+				crc.Config["soa"] = map[string]interface{}{"admin": "traffic_ops"}
+				delete(crc.Config, "domain_name")
+			},
+			expected: Diff{Config: Changes{Added: []string{"soa"}, Removed: []string{"domain_name"}, Modified: []Modification{{Name: "ttl", Changes: []string{"60 -> 120"}}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newCRC := testCRConfig()
+			test.modify(newCRC)
+			diff := New(testCRConfig(), newCRC, "poll")
+			if diff.Source != "poll" {
+				t.Errorf("source '%s', expected 'poll'", diff.Source)
+			}
+			diff.Time, diff.Source = test.expected.Time, test.expected.Source
+			if !reflect.DeepEqual(diff, test.expected) {
+				t.Errorf("diff\n%+v\nexpected\n%+v", diff, test.expected)
+			}
+			if empty := reflect.DeepEqual(test.expected, Diff{}); diff.Empty() != empty {
+				t.Errorf("Empty() = %t, expected %t", diff.Empty(), empty)
+			}
+		})
+	}
+}
+
+func TestNewNil(t *testing.T) {
+	diff := New(nil, testCRConfig(), "startup")
+	if expected := []string{"ds1", "ds2"}; !reflect.DeepEqual(diff.DeliveryServices.Added, expected) {
+		t.Errorf("nil old CRConfig added DSes %v, expected %v", diff.DeliveryServices.Added, expected)
+	}
+	if expected := []string{"edge1", "edge2"}; !reflect.DeepEqual(diff.Servers.Added, expected) {
+		t.Errorf("nil old CRConfig added servers %v, expected %v", diff.Servers.Added, expected)
+	}
+	diff = New(testCRConfig(), nil, "poll")
+	if expected := []string{"tr1"}; !reflect.DeepEqual(diff.Routers.Removed, expected) {
+		t.Errorf("nil new CRConfig removed routers %v, expected %v", diff.Routers.Removed, expected)
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := (Diff{}).String(); got != "no changes" {
+		t.Errorf("empty diff String() = '%s', expected 'no changes'", got)
+	}
+	diff := Diff{
+		DeliveryServices: Changes{Added: []string{"ds3"}, Removed: []string{"ds2"}},
+		Servers:          Changes{Modified: []Modification{{Name: "edge1", Changes: []string{`ip "10.0.0.1" -> "10.0.0.9"`, "deliveryServices added ds2"}}}},
+		Config:           Changes{Modified: []Modification{{Name: "ttl", Changes: []string{"60 -> 120"}}}},
+	}
+	expected := "+ ds 'ds3'\n" +
+		"- ds 'ds2'\n" +
+		`~ server 'edge1': ip "10.0.0.1" -> "10.0.0.9", deliveryServices added ds2` + "\n" +
+		"~ config 'ttl': 60 -> 120"
+	if got := diff.String(); got != expected {
+		t.Errorf("String()\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	hi := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		hi.Add(Diff{Source: strconv.Itoa(i)})
+	}
+	got := []string{}
+	for _, diff := range hi.Get() {
+		got = append(got, diff.Source)
+	}
+	if expected := []string{"4", "3", "2"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("history sources %v, expected the newest 3 newest first %v", got, expected)
+	}
+
+	hi = NewHistory(0)
+	hi.Log(New(testCRConfig(), testCRConfig(), "poll"))
+	if got := len(hi.Get()); got != 0 {
+		t.Errorf("history has %d diffs after logging an empty diff, expected 0", got)
+	}
+	for i := 0; i < DefaultHistorySize+1; i++ {
+		hi.Log(Diff{Source: "poll", Config: Changes{Added: []string{strconv.Itoa(i)}}})
+	}
+	if got := len(hi.Get()); got != DefaultHistorySize {
+		t.Errorf("default history has %d diffs, expected %d", got, DefaultHistorySize)
+	}
+}
@@ -13,10 +13,15 @@ type CZF struct {
 	CoverageZones map[string]CZFCoverageZone `json:"coverageZones"`
 }
 
+// ParsedCZF is a parsed CZF, with its networks compiled into tries for looking up client zones.
+// It must be created with NewParsedCZF or Parse, and must not be modified after it's created.
 type ParsedCZF struct {
 	Revision      string
 	CustomerName  string
 	CoverageZones map[string]ParsedCZFCoverageZone
+
+	v4 *trie
+	v6 *trie
 }
 
 type CZFCoverageZone struct {
@@ -49,6 +54,36 @@ func LoadCZF(path string) (*CZF, error) {
 	return &cz, nil
 }
 
+// Parse parses the networks of the CZF, and builds the ParsedCZF.
+func Parse(cz *CZF) (*ParsedCZF, error) {
+	zones, err := ParseCZNets(cz.CoverageZones)
+	if err != nil {
+		return nil, err
+	}
+	return NewParsedCZF(cz.Revision, cz.CustomerName, zones), nil
+}
+
+// NewParsedCZF creates a ParsedCZF of the given parsed zones, building the tries to look up client zones.
+func NewParsedCZF(revision string, customerName string, zones map[string]ParsedCZFCoverageZone) *ParsedCZF {
+	v4Networks := []zoneNetwork{}
+	v6Networks := []zoneNetwork{}
+	for zoneName, zone := range zones {
+		for _, network := range zone.Network {
+			v4Networks = append(v4Networks, zoneNetwork{zone: zoneName, network: network})
+		}
+		for _, network := range zone.Network6 {
+			v6Networks = append(v6Networks, zoneNetwork{zone: zoneName, network: network})
+		}
+	}
+	return &ParsedCZF{
+		Revision:      revision,
+		CustomerName:  customerName,
+		CoverageZones: zones,
+		v4:            buildTrie(v4Networks, true),
+		v6:            buildTrie(v6Networks, false),
+	}
+}
+
 func ParseCZNets(czs map[string]CZFCoverageZone) (map[string]ParsedCZFCoverageZone, error) {
 	parsed := map[string]ParsedCZFCoverageZone{}
 	for zoneName, zone := range czs {
@@ -88,26 +123,26 @@ func ParseCZNet(cz CZFCoverageZone) (ParsedCZFCoverageZone, error) {
 
 // GetZone returns the CoverageZone name for ip. If no zone matches, returns "".
 func (cz *ParsedCZF) GetZone(ip net.IP) string {
-	// TODO use something faster, like https://github.com/yl2chen/cidranger
+	zone, _ := cz.GetZoneAndScope(ip)
+	return zone
+}
 
-	if isV4 := ip.To4() != nil; isV4 {
-		// fmt.Println("DEBUG client is v4")
-		for zoneName, zone := range cz.CoverageZones {
-			for _, network := range zone.Network {
-				if network.Contains(ip) {
-					return zoneName
-				}
-			}
-		}
-	} else {
-		// fmt.Println("DEBUG client is '" + ip.String() + "' not v4")
-		for zoneName, zone := range cz.CoverageZones {
-			for _, network := range zone.Network6 {
-				if network.Contains(ip) {
-					return zoneName
-				}
-			}
-		}
+// GetZoneAndScope returns the CoverageZone name for ip, or "" if no zone matches, and the scope of the answer.
+//
+// The zone is that of the longest network containing ip, so more specific networks may be carved out of larger ones in other zones.
+// The scope is the prefix length of the subnet of ip in which every address gets the same zone, for the EDNS Client Subnet scope.
+// It isn't the prefix length of the matched network: if a network in another zone is carved out of it, the scope is narrowed to exclude the carve-out.
+// Likewise, if no zone matches, the scope is the subnet of ip which contains no network.
+// Invalid IPs have no zone, and a scope of 0.
+//
+// Doesn't allocate, so it's cheap enough to call for every request.
+//
+func (cz *ParsedCZF) GetZoneAndScope(ip net.IP) (string, int) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return cz.v4.lookup(v4Key(ip4))
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return cz.v6.lookup(v6Key(ip16))
 	}
-	return ""
+	return "", 0
 }
@@ -0,0 +1,210 @@
+package czf
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// ipKey is an IP address as a 128-bit integer, for the trie. IPv4 addresses are in the high 32 bits.
+type ipKey struct {
+	hi uint64
+	lo uint64
+}
+
+func v4Key(ip4 net.IP) ipKey {
+	return ipKey{hi: uint64(binary.BigEndian.Uint32(ip4)) << 32}
+}
+
+func v6Key(ip16 net.IP) ipKey {
+	return ipKey{hi: binary.BigEndian.Uint64(ip16[:8]), lo: binary.BigEndian.Uint64(ip16[8:])}
+}
+
+// bit returns bit i of the key, where bit 0 is the most significant.
+func (k ipKey) bit(i int) int {
+	if i < 64 {
+		return int(k.hi>>uint(63-i)) & 1
+	}
+	return int(k.lo>>uint(127-i)) & 1
+}
+
+// mask returns the key with all but the first prefixLen bits zeroed.
+func (k ipKey) mask(prefixLen int) ipKey {
+	if prefixLen <= 64 {
+		return ipKey{hi: k.hi & (^uint64(0) << uint(64-prefixLen))}
+	}
+	return ipKey{hi: k.hi, lo: k.lo & (^uint64(0) << uint(128-prefixLen))}
+}
+
+// commonPrefixLen returns the number of leading bits a and b have in common, up to max.
+func commonPrefixLen(a ipKey, b ipKey, max int) int {
+	common := bits.LeadingZeros64(a.hi ^ b.hi)
+	if common == 64 {
+		common += bits.LeadingZeros64(a.lo ^ b.lo)
+	}
+	if common > max {
+		return max
+	}
+	return common
+}
+
+// noZone is the zone index of trie nodes which aren't a network, only a branch.
+const noZone = -1
+
+// noChild is the child index of trie nodes without a child on that side.
+const noChild = -1
+
+// trie is a compiled path-compressed binary (Patricia) trie of networks, for longest-prefix-match lookups of client IPs.
+//
+// The nodes are in a flat slice, and children are indices into it, so lookups follow no pointers and allocate nothing.
+// A trie is immutable after it's built, and may safely be used by multiple goroutines.
+//
+type trie struct {
+	nodes []trieNode
+	zones []string
+}
+
+type trieNode struct {
+	key       ipKey // key is the network, masked to prefixLen.
+	prefixLen int
+	zone      int      // zone is the index in zones of the network's zone, or noZone if the node is only a branch.
+	children  [2]int32 // children are the indices in nodes of the child whose next bit is 0 and 1, or noChild.
+}
+
+// zoneNetwork is a network and the zone it belongs to, to build a trie.
+type zoneNetwork struct {
+	zone    string
+	network *net.IPNet
+}
+
+// buildTrie builds a trie of the given networks, which must all be IPv4 if v4, otherwise all IPv6.
+//
+// If the same network is in multiple zones, the zone first by name wins, so the lookup is the same every time the CZF is loaded.
+// The networks of a zone are never ambiguous: the longest prefix always matches, regardless of which zone it's in.
+//
+func buildTrie(networks []zoneNetwork, v4 bool) *trie {
+	sort.SliceStable(networks, func(i, j int) bool { return networks[i].zone < networks[j].zone })
+
+	t := &trie{}
+	zoneIndices := map[string]int{}
+	root := (*buildNode)(nil)
+	for _, zn := range networks {
+		zoneI, ok := zoneIndices[zn.zone]
+		if !ok {
+			zoneI = len(t.zones)
+			zoneIndices[zn.zone] = zoneI
+			t.zones = append(t.zones, zn.zone)
+		}
+		key := ipKey{}
+		if v4 {
+			key = v4Key(zn.network.IP.To4())
+		} else {
+			key = v6Key(zn.network.IP.To16())
+		}
+		prefixLen, _ := zn.network.Mask.Size()
+		insertNode(&root, key.mask(prefixLen), prefixLen, zoneI)
+	}
+	if root != nil {
+		t.compile(root)
+	}
+	return t
+}
+
+// buildNode is a trie node while the trie is being built, before it's compiled into the flat node slice.
+type buildNode struct {
+	key       ipKey
+	prefixLen int
+	zone      int
+	children  [2]*buildNode
+}
+
+// insertNode inserts the network key/prefixLen into the trie at node. If the network is already in the trie with a zone, it's unchanged.
+func insertNode(node **buildNode, key ipKey, prefixLen int, zone int) {
+	for {
+		nd := *node
+		if nd == nil {
+			*node = &buildNode{key: key, prefixLen: prefixLen, zone: zone}
+			return
+		}
+		minLen := nd.prefixLen
+		if prefixLen < minLen {
+			minLen = prefixLen
+		}
+		common := commonPrefixLen(key, nd.key, minLen)
+		if common == nd.prefixLen && common == prefixLen {
+			if nd.zone == noZone {
+				nd.zone = zone
+			}
+			return
+		}
+		if common == nd.prefixLen {
+			// the node's network contains the new one, descend
+			node = &nd.children[key.bit(nd.prefixLen)]
+			continue
+		}
+		// the networks diverge before the end of the node's network, so split it with a branch at the common prefix
+		branch := &buildNode{key: key.mask(common), prefixLen: common, zone: noZone}
+		branch.children[nd.key.bit(common)] = nd
+		if common == prefixLen {
+			branch.zone = zone // the new network contains the node's
+		} else {
+			branch.children[key.bit(common)] = &buildNode{key: key, prefixLen: prefixLen, zone: zone}
+		}
+		*node = branch
+		return
+	}
+}
+
+// compile appends nd and its descendants to t.nodes, and returns the index of nd.
+func (t *trie) compile(nd *buildNode) int32 {
+	i := int32(len(t.nodes))
+	t.nodes = append(t.nodes, trieNode{key: nd.key, prefixLen: nd.prefixLen, zone: nd.zone, children: [2]int32{noChild, noChild}})
+	for bit, child := range nd.children {
+		if child != nil {
+			childI := t.compile(child)
+			t.nodes[i].children[bit] = childI
+		}
+	}
+	return i
+}
+
+// lookup returns the zone of the longest network containing key, or "" if no network contains it, and the scope of the answer.
+//
+// The scope is the prefix length of the subnet of key in which every address gets the same answer. It's the deepest prefix the walk examined:
+// the longest prefix of key shared with a network on its path, plus the bit it diverges on, or the whole of the last network if it's a leaf.
+// This is longer than the matched network if a network carved out of it, in any zone, is near key.
+// A nil or empty trie contains nothing, and the scope is 0. Safe to call on a nil trie.
+//
+func (t *trie) lookup(key ipKey) (string, int) {
+	if t == nil || len(t.nodes) == 0 {
+		return "", 0
+	}
+	zone, scope := noZone, 0
+	i := int32(0)
+	for {
+		nd := &t.nodes[i]
+		if key.mask(nd.prefixLen) != nd.key {
+			// every address sharing key's bits up to and including the first one which differs from the node diverges here too
+			scope = commonPrefixLen(key, nd.key, nd.prefixLen) + 1
+			break
+		}
+		if nd.zone != noZone {
+			zone = nd.zone
+		}
+		if nd.children[0] == noChild && nd.children[1] == noChild {
+			scope = nd.prefixLen // nothing is carved out of a leaf, so its whole network gets the same answer
+			break
+		}
+		next := nd.children[key.bit(nd.prefixLen)]
+		if next == noChild {
+			scope = nd.prefixLen + 1
+			break
+		}
+		i = next
+	}
+	if zone == noZone {
+		return "", scope
+	}
+	return t.zones[zone], scope
+}
@@ -0,0 +1,274 @@
+package czf
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// newTestCZF builds a ParsedCZF of the given zones, each a list of IPv4 and IPv6 CIDRs.
+func newTestCZF(t testing.TB, zones map[string][]string) *ParsedCZF {
+	t.Helper()
+	czs := map[string]CZFCoverageZone{}
+	for zoneName, networks := range zones {
+		cz := CZFCoverageZone{}
+		for _, network := range networks {
+			if ip, _, err := net.ParseCIDR(network); err == nil && ip.To4() == nil {
+				cz.Network6 = append(cz.Network6, network)
+			} else {
+				cz.Network = append(cz.Network, network)
+			}
+		}
+		czs[zoneName] = cz
+	}
+	parsed, err := Parse(&CZF{CoverageZones: czs})
+	if err != nil {
+		t.Fatalf("parsing test CZF: %v", err)
+	}
+	return parsed
+}
+
+func TestGetZoneAndScope(t *testing.T) {
+	nested := map[string][]string{
+		"a": {"10.0.0.0/8", "10.1.2.128/25", "2001:db8::/32"},
+		"b": {"10.1.0.0/16", "2001:db8:1::/48"},
+		"c": {"10.1.2.0/24", "2001:db8:1::1/128"},
+		"d": {"10.1.2.3/32"},
+	}
+	tests := []struct {
+		name          string
+		zones         map[string][]string
+		ip            string
+		expectedZone  string
+		expectedScope int
+	}{
+		{"outside all", nested, "11.0.0.1", "", 8},
+		{"outer network", nested, "10.200.0.1", "a", 9},
+		{"outer network near carve-out", nested, "10.0.0.1", "a", 16},
+		{"nested network", nested, "10.1.5.5", "b", 22},
+		{"nested twice", nested, "10.1.2.5", "c", 30},
+		{"nested back in outer zone", nested, "10.1.2.200", "a", 25},
+		{"v4 /32", nested, "10.1.2.3", "d", 32},
+		{"v4-mapped v6", nested, "::ffff:10.1.2.3", "d", 32},
+		{"v4-mapped v6 outer", nested, "::ffff:10.200.0.1", "a", 9},
+		{"v6 /128", nested, "2001:db8:1::1", "c", 128},
+		{"v6 next to /128", nested, "2001:db8:1::2", "b", 127},
+		{"v6 outer network", nested, "2001:db8:2::1", "a", 47},
+		{"v6 outside all", nested, "2001:db9::1", "", 32},
+
+		{"carve-out", map[string][]string{"a": {"10.0.0.0/8"}, "b": {"10.1.0.0/16"}}, "10.1.5.5", "b", 16},
+		{"outside carve-out", map[string][]string{"a": {"10.0.0.0/8"}, "b": {"10.1.0.0/16"}}, "10.0.5.5", "a", 16},
+		{"far from carve-out", map[string][]string{"a": {"10.0.0.0/8"}, "b": {"10.1.0.0/16"}}, "10.129.0.1", "a", 9},
+		{"carve-out in same zone", map[string][]string{"a": {"10.0.0.0/8", "10.1.0.0/16"}}, "10.0.5.5", "a", 16},
+		{"no carve-out", map[string][]string{"a": {"10.0.0.0/8"}}, "10.0.5.5", "a", 8},
+
+		{"v4 /0", map[string][]string{"a": {"0.0.0.0/0"}}, "192.0.2.1", "a", 0},
+		{"v4 /0 with carve-out", map[string][]string{"a": {"0.0.0.0/0"}, "b": {"192.0.2.0/24"}}, "8.8.8.8", "a", 1},
+		{"v4 /0 carve-out", map[string][]string{"a": {"0.0.0.0/0"}, "b": {"192.0.2.0/24"}}, "192.0.2.1", "b", 24},
+		{"v4 /0 near carve-out", map[string][]string{"a": {"0.0.0.0/0"}, "b": {"192.0.2.0/24"}}, "192.0.3.1", "a", 24},
+		{"v6 /0", map[string][]string{"a": {"::/0"}}, "2001:db8::1", "a", 0},
+		{"v6 /0 with /128", map[string][]string{"a": {"::/0"}, "b": {"2001:db8::1/128"}}, "2001:db8::1", "b", 128},
+		{"v6 /0 not in v4", map[string][]string{"a": {"::/0"}}, "192.0.2.1", "", 0},
+
+		{"duplicate network", map[string][]string{"b": {"10.0.0.0/8"}, "a": {"10.0.0.0/8"}}, "10.0.0.1", "a", 8},
+		{"empty", map[string][]string{}, "10.0.0.1", "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cz := newTestCZF(t, test.zones)
+			ip := net.ParseIP(test.ip)
+			zone, scope := cz.GetZoneAndScope(ip)
+			if zone != test.expectedZone || scope != test.expectedScope {
+				t.Errorf("GetZoneAndScope(%s) = '%s' %d, expected '%s' %d", test.ip, zone, scope, test.expectedZone, test.expectedScope)
+			}
+			if got := cz.GetZone(ip); got != test.expectedZone {
+				t.Errorf("GetZone(%s) = '%s', expected '%s'", test.ip, got, test.expectedZone)
+			}
+		})
+	}
+}
+
+func TestGetZoneAndScopeInvalidIP(t *testing.T) {
+	cz := newTestCZF(t, map[string][]string{"a": {"0.0.0.0/0", "::/0"}})
+	if zone, scope := cz.GetZoneAndScope(nil); zone != "" || scope != 0 {
+		t.Errorf("GetZoneAndScope(nil) = '%s' %d, expected '' 0", zone, scope)
+	}
+}
+
+// zoneNet is a network in a zone, for the linear reference lookups.
+type zoneNet struct {
+	zone    string
+	network *net.IPNet
+}
+
+// linearLPM returns the zone of the longest network containing ip, preferring the zone first by name, by checking every network.
+// It's the reference the trie must agree with.
+func linearLPM(nets []zoneNet, ip net.IP) string {
+	bestZone, bestLen := "", -1
+	for _, zn := range nets {
+		if (zn.network.IP.To4() != nil) != (ip.To4() != nil) || !zn.network.Contains(ip) {
+			continue
+		}
+		prefixLen, _ := zn.network.Mask.Size()
+		if prefixLen > bestLen || (prefixLen == bestLen && zn.zone < bestZone) {
+			bestZone, bestLen = zn.zone, prefixLen
+		}
+	}
+	return bestZone
+}
+
+// checkScope returns the first network which is partly in the scope subnet of ip, and so may give some of its addresses a different zone.
+func checkScope(nets []zoneNet, ip net.IP, scope int) (zoneNet, bool) {
+	bitLen := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bitLen = ip4, 32
+	}
+	subnet := &net.IPNet{IP: ip.Mask(net.CIDRMask(scope, bitLen)), Mask: net.CIDRMask(scope, bitLen)}
+	for _, zn := range nets {
+		if (zn.network.IP.To4() != nil) != (bitLen == 32) {
+			continue
+		}
+		if prefixLen, _ := zn.network.Mask.Size(); prefixLen > scope && subnet.Contains(zn.network.IP) {
+			return zn, false
+		}
+	}
+	return zoneNet{}, true
+}
+
+// randNetworks returns numNets random networks in numZones zones, of prefix lengths between minLen and maxLen for IPv6, and a quarter of that for IPv4.
+// The networks are clustered in a few /8s and /24s, so they nest and overlap.
+func randNetworks(rnd *rand.Rand, numZones int, numNets int, minLen int, maxLen int) (map[string][]string, []zoneNet) {
+	zones := map[string][]string{}
+	nets := []zoneNet{}
+	for i := 0; i < numNets; i++ {
+		zone := "zone" + strconv.Itoa(rnd.Intn(numZones))
+		network := (*net.IPNet)(nil)
+		if rnd.Intn(2) == 0 {
+			ip := randIP(rnd, true)
+			mask := net.CIDRMask(minLen/4+rnd.Intn(maxLen/4-minLen/4+1), 32)
+			network = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		} else {
+			ip := randIP(rnd, false)
+			mask := net.CIDRMask(minLen+rnd.Intn(maxLen-minLen+1), 128)
+			network = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		}
+		zones[zone] = append(zones[zone], network.String())
+		nets = append(nets, zoneNet{zone: zone, network: network})
+	}
+	return zones, nets
+}
+
+// randIP returns a random IP in a few clusters, so random IPs are often in random networks.
+func randIP(rnd *rand.Rand, v4 bool) net.IP {
+	if v4 {
+		ip := make(net.IP, 4)
+		rnd.Read(ip)
+		ip[0] = byte(rnd.Intn(4))
+		return ip
+	}
+	ip := make(net.IP, 16)
+	rnd.Read(ip)
+	ip[0], ip[1], ip[2] = 0x20, 0x01, byte(rnd.Intn(4))
+	return ip
+}
+
+func TestGetZoneAndScopeRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 50; i++ {
+		zones, nets := randNetworks(rnd, 1+rnd.Intn(8), 1+rnd.Intn(300), 4, 128)
+		cz := newTestCZF(t, zones)
+		for j := 0; j < 200; j++ {
+			ip := randIP(rnd, rnd.Intn(2) == 0)
+			zone, scope := cz.GetZoneAndScope(ip)
+			if expected := linearLPM(nets, ip); zone != expected {
+				t.Fatalf("GetZoneAndScope(%s) zone '%s', expected '%s'", ip, zone, expected)
+			}
+			if zn, ok := checkScope(nets, ip, scope); !ok {
+				t.Fatalf("GetZoneAndScope(%s) zone '%s' scope %d, but network %s in zone '%s' is inside the scope subnet", ip, zone, scope, zn.network, zn.zone)
+			}
+		}
+	}
+}
+
+// benchmarkNetworks are realistic CZF networks for benchmarking: 100,000 IPv4 /24s and IPv6 /48s in 1,000 zones.
+func benchmarkNetworks(b *testing.B) (*ParsedCZF, []net.IP) {
+	rnd := rand.New(rand.NewSource(42))
+	zones := map[string][]string{}
+	for i := 0; i < 100000; i++ {
+		zone := "zone" + strconv.Itoa(rnd.Intn(1000))
+		ip := make(net.IP, 16)
+		rnd.Read(ip)
+		network := ""
+		if i%2 == 0 {
+			network = (&net.IPNet{IP: ip[:4].Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		} else {
+			ip[0], ip[1] = 0x20, 0x01
+			network = (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+		}
+		zones[zone] = append(zones[zone], network)
+	}
+	cz := newTestCZF(b, zones)
+
+	// half the client IPs are in a network, half are random
+	ips := make([]net.IP, 1024)
+	i := 0
+	for _, zone := range cz.CoverageZones {
+		for _, network := range zone.Network {
+			if i >= len(ips)/2 {
+				break
+			}
+			ip := append(net.IP(nil), network.IP.To16()...)
+			ip[15] = byte(i)
+			ips[i] = ip
+			i++
+		}
+	}
+	for ; i < len(ips); i++ {
+		ips[i] = randIP(rnd, i%2 == 0)
+	}
+	return cz, ips
+}
+
+func BenchmarkGetZoneAndScope(b *testing.B) {
+	cz, ips := benchmarkNetworks(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cz.GetZoneAndScope(ips[i&1023])
+	}
+}
+
+// linearScan is the lookup the trie replaced, which returned the first network containing ip, in map order.
+// It's only a reference for the benchmark.
+func linearScan(cz *ParsedCZF, ip net.IP) (string, int) {
+	if isV4 := ip.To4() != nil; isV4 {
+		for zoneName, zone := range cz.CoverageZones {
+			for _, network := range zone.Network {
+				if network.Contains(ip) {
+					prefixLen, _ := network.Mask.Size()
+					return zoneName, prefixLen
+				}
+			}
+		}
+	} else {
+		for zoneName, zone := range cz.CoverageZones {
+			for _, network := range zone.Network6 {
+				if network.Contains(ip) {
+					prefixLen, _ := network.Mask.Size()
+					return zoneName, prefixLen
+				}
+			}
+		}
+	}
+	return "", -1
+}
+
+func BenchmarkLinearScan(b *testing.B) {
+	cz, ips := benchmarkNetworks(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearScan(cz, ips[i&1023])
+	}
+}
@@ -0,0 +1,204 @@
+package czf
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// Problem severities. Errors are problems which will misroute clients, warnings are problems which may be intentional.
+const SeverityError = "error"
+const SeverityWarning = "warning"
+
+// Problem types.
+const (
+	// ProblemMalformedFile is a CZF file which can't be read or decoded. No other problems are reported.
+	ProblemMalformedFile = "malformed_file"
+	// ProblemMalformedNetwork is a network which isn't a CIDR, or is in the wrong list for its address family.
+	ProblemMalformedNetwork = "malformed_network"
+	// ProblemDuplicateNetwork is a network which is in another zone, or twice in the same zone.
+	// Clients in a network in multiple zones are routed to the zone first by name.
+	ProblemDuplicateNetwork = "duplicate_network"
+	// ProblemOverlappingNetwork is a network contained in a larger network. Clients in it are routed to its zone, by longest-prefix match.
+	ProblemOverlappingNetwork = "overlapping_network"
+	// ProblemMissingCacheGroup is a zone which isn't a cachegroup in the CRConfig, so its clients have no servers.
+	ProblemMissingCacheGroup = "missing_cachegroup"
+	// ProblemInvalidCoordinates is a zone whose latitude or longitude is out of range.
+	ProblemInvalidCoordinates = "invalid_coordinates"
+)
+
+// Problem is a problem found in a CZF by Validate.
+type Problem struct {
+	Severity string `json:"severity"`
+	Type     string `json:"type"`
+	Zone     string `json:"zone,omitempty"`
+	Network  string `json:"network,omitempty"`
+	// OtherZone and OtherNetwork are the network a duplicate or overlapping network conflicts with.
+	OtherZone    string `json:"otherZone,omitempty"`
+	OtherNetwork string `json:"otherNetwork,omitempty"`
+	Message      string `json:"message"`
+}
+
+// Report is the result of validating a CZF.
+type Report struct {
+	Zones    int       `json:"zones"`
+	Networks int       `json:"networks"`
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+	Problems []Problem `json:"problems"`
+}
+
+func (rp *Report) add(pr Problem) {
+	if pr.Severity == SeverityError {
+		rp.Errors++
+	} else {
+		rp.Warnings++
+	}
+	rp.Problems = append(rp.Problems, pr)
+}
+
+// ValidateFile loads the CZF at path and validates it. If the file can't be loaded, the Report has a single ProblemMalformedFile.
+// The crc may be nil, in which case zones aren't checked against the CRConfig cachegroups.
+func ValidateFile(path string, crc *tc.CRConfig) Report {
+	cz, err := LoadCZF(path)
+	if err != nil {
+		rp := Report{}
+		rp.add(Problem{Severity: SeverityError, Type: ProblemMalformedFile, Message: err.Error()})
+		return rp
+	}
+	return Validate(cz, crc)
+}
+
+// Validate returns all the problems in the CZF, unlike ParseCZNets, which stops at the first malformed network.
+//
+// If crc isn't nil, zones which aren't cachegroups in its edgeLocations are reported.
+// Problems are ordered by zone, and the order is the same every time the same CZF is validated.
+//
+func Validate(cz *CZF, crc *tc.CRConfig) Report {
+	rp := Report{Problems: []Problem{}, Zones: len(cz.CoverageZones)}
+
+	zoneNames := make([]string, 0, len(cz.CoverageZones))
+	for zoneName := range cz.CoverageZones {
+		zoneNames = append(zoneNames, zoneName)
+	}
+	sort.Strings(zoneNames)
+
+	v4Nets := []validateNet{}
+	v6Nets := []validateNet{}
+	for _, zoneName := range zoneNames {
+		zone := cz.CoverageZones[zoneName]
+		if crc != nil {
+			if _, ok := crc.EdgeLocations[zoneName]; !ok {
+				rp.add(Problem{Severity: SeverityError, Type: ProblemMissingCacheGroup, Zone: zoneName, Message: "zone is not a cachegroup in the CRConfig edgeLocations"})
+			}
+		}
+		if lat := zone.Coordinates.Latitude; lat < -90 || lat > 90 {
+			rp.add(Problem{Severity: SeverityError, Type: ProblemInvalidCoordinates, Zone: zoneName, Message: "latitude " + strconv.FormatFloat(lat, 'f', -1, 64) + " is not between -90 and 90"})
+		}
+		if lon := zone.Coordinates.Longitude; lon < -180 || lon > 180 {
+			rp.add(Problem{Severity: SeverityError, Type: ProblemInvalidCoordinates, Zone: zoneName, Message: "longitude " + strconv.FormatFloat(lon, 'f', -1, 64) + " is not between -180 and 180"})
+		}
+		for _, network := range zone.Network {
+			rp.Networks++
+			if vn, ok := parseValidateNet(&rp, zoneName, network, true); ok {
+				v4Nets = append(v4Nets, vn)
+			}
+		}
+		for _, network := range zone.Network6 {
+			rp.Networks++
+			if vn, ok := parseValidateNet(&rp, zoneName, network, false); ok {
+				v6Nets = append(v6Nets, vn)
+			}
+		}
+	}
+
+	validateOverlaps(&rp, v4Nets)
+	validateOverlaps(&rp, v6Nets)
+
+	sort.SliceStable(rp.Problems, func(i, j int) bool { return rp.Problems[i].Zone < rp.Problems[j].Zone })
+	return rp
+}
+
+// validateNet is a network being validated.
+type validateNet struct {
+	zone      string
+	network   string // network is the network as written in the CZF.
+	key       ipKey
+	prefixLen int
+}
+
+// contains returns whether vn contains other, or is the same network.
+func (vn validateNet) contains(other validateNet) bool {
+	return vn.prefixLen <= other.prefixLen && other.key.mask(vn.prefixLen) == vn.key
+}
+
+// parseValidateNet parses the network, adding a ProblemMalformedNetwork to rp and returning false if it's malformed.
+func parseValidateNet(rp *Report, zone string, network string, v4 bool) (validateNet, bool) {
+	listName := "network"
+	if !v4 {
+		listName = "network6"
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		rp.add(Problem{Severity: SeverityError, Type: ProblemMalformedNetwork, Zone: zone, Network: network, Message: "not a CIDR network"})
+		return validateNet{}, false
+	}
+	if isV4 := ipNet.IP.To4() != nil; isV4 != v4 {
+		rp.add(Problem{Severity: SeverityError, Type: ProblemMalformedNetwork, Zone: zone, Network: network, Message: "wrong address family for the " + listName + " list"})
+		return validateNet{}, false
+	}
+	key := ipKey{}
+	if v4 {
+		key = v4Key(ipNet.IP.To4())
+	} else {
+		key = v6Key(ipNet.IP.To16())
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+	return validateNet{zone: zone, network: network, key: key, prefixLen: prefixLen}, true
+}
+
+// validateOverlaps adds the duplicate and overlapping networks in nets to rp. The nets must all be the same address family.
+//
+// Networks are sorted by address and then prefix length, so every network comes after the networks containing it.
+// Because CIDR networks are either nested or disjoint, a stack of the networks containing the current one finds every overlap in one pass.
+// Each network is reported against the smallest network containing it.
+//
+func validateOverlaps(rp *Report, nets []validateNet) {
+	sort.SliceStable(nets, func(i, j int) bool {
+		if nets[i].key.hi != nets[j].key.hi {
+			return nets[i].key.hi < nets[j].key.hi
+		}
+		if nets[i].key.lo != nets[j].key.lo {
+			return nets[i].key.lo < nets[j].key.lo
+		}
+		return nets[i].prefixLen < nets[j].prefixLen
+	})
+	containing := []validateNet{}
+	for _, vn := range nets {
+		for len(containing) > 0 && !containing[len(containing)-1].contains(vn) {
+			containing = containing[:len(containing)-1]
+		}
+		if len(containing) == 0 {
+			containing = append(containing, vn)
+			continue
+		}
+		outer := containing[len(containing)-1]
+		pr := Problem{Zone: vn.zone, Network: vn.network, OtherZone: outer.zone, OtherNetwork: outer.network}
+		switch {
+		case outer.prefixLen == vn.prefixLen && outer.zone == vn.zone:
+			pr.Severity, pr.Type, pr.Message = SeverityWarning, ProblemDuplicateNetwork, "network is in the zone more than once"
+		case outer.prefixLen == vn.prefixLen:
+			pr.Severity, pr.Type, pr.Message = SeverityError, ProblemDuplicateNetwork, "network is in multiple zones, clients will be routed to zone '"+outer.zone+"'"
+		case outer.zone == vn.zone:
+			pr.Severity, pr.Type, pr.Message = SeverityWarning, ProblemOverlappingNetwork, "network is redundant, it's inside another network of the same zone"
+		default:
+			pr.Severity, pr.Type, pr.Message = SeverityWarning, ProblemOverlappingNetwork, "network is inside a network of another zone, clients in it will be routed to zone '"+vn.zone+"'"
+		}
+		rp.add(pr)
+		if outer.prefixLen != vn.prefixLen {
+			containing = append(containing, vn) // duplicates aren't pushed, so later duplicates are reported against the first
+		}
+	}
+}
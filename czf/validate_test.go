@@ -0,0 +1,196 @@
+package czf
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// problemKey is a Problem without its Message, for comparing Validate results.
+type problemKey struct {
+	Severity     string
+	Type         string
+	Zone         string
+	Network      string
+	OtherZone    string
+	OtherNetwork string
+}
+
+func problemKeys(problems []Problem) []problemKey {
+	keys := []problemKey{}
+	for _, pr := range problems {
+		keys = append(keys, problemKey{pr.Severity, pr.Type, pr.Zone, pr.Network, pr.OtherZone, pr.OtherNetwork})
+	}
+	return keys
+}
+
+func TestValidate(t *testing.T) {
+	crc := &tc.CRConfig{EdgeLocations: map[string]tc.CRConfigLatitudeLongitude{"a": {}}}
+	tests := []struct {
+		name     string
+		zones    map[string]CZFCoverageZone
+		crc      *tc.CRConfig
+		networks int
+		expected []problemKey
+	}{
+		{
+			name: "valid",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.0.0/24"}},
+				"b": {Network: []string{"10.0.1.0/24"}, Network6: []string{"2001:db8::/32"}},
+			},
+			networks: 3,
+			expected: []problemKey{},
+		},
+		{
+			name: "malformed networks",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.0.0", "2001:db8::/32"}, Network6: []string{"10.0.0.0/8"}},
+			},
+			networks: 3,
+			expected: []problemKey{
+				{SeverityError, ProblemMalformedNetwork, "a", "10.0.0.0", "", ""},
+				{SeverityError, ProblemMalformedNetwork, "a", "2001:db8::/32", "", ""},
+				{SeverityError, ProblemMalformedNetwork, "a", "10.0.0.0/8", "", ""},
+			},
+		},
+		{
+			name: "duplicate in the same zone",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.0.0/24", "10.0.0.0/24"}},
+			},
+			networks: 2,
+			expected: []problemKey{{SeverityWarning, ProblemDuplicateNetwork, "a", "10.0.0.0/24", "a", "10.0.0.0/24"}},
+		},
+		{
+			name: "duplicate in another zone",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network6: []string{"2001:db8::/32"}},
+				"b": {Network6: []string{"2001:db8::/32"}},
+			},
+			networks: 2,
+			expected: []problemKey{{SeverityError, ProblemDuplicateNetwork, "b", "2001:db8::/32", "a", "2001:db8::/32"}},
+		},
+		{
+			name: "overlap in the same zone",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.1.0/24", "10.0.0.0/16"}},
+			},
+			networks: 2,
+			expected: []problemKey{{SeverityWarning, ProblemOverlappingNetwork, "a", "10.0.1.0/24", "a", "10.0.0.0/16"}},
+		},
+		{
+			name: "overlap in another zone",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.1.0/24"}},
+				"b": {Network: []string{"10.0.0.0/16"}},
+			},
+			networks: 2,
+			expected: []problemKey{{SeverityWarning, ProblemOverlappingNetwork, "a", "10.0.1.0/24", "b", "10.0.0.0/16"}},
+		},
+		{
+			name: "nested overlaps are against the smallest containing network",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.0.0/8", "10.2.0.0/16"}},
+				"b": {Network: []string{"10.1.0.0/16"}},
+				"c": {Network: []string{"10.1.2.0/24"}},
+			},
+			networks: 4,
+			expected: []problemKey{
+				{SeverityWarning, ProblemOverlappingNetwork, "a", "10.2.0.0/16", "a", "10.0.0.0/8"},
+				{SeverityWarning, ProblemOverlappingNetwork, "b", "10.1.0.0/16", "a", "10.0.0.0/8"},
+				{SeverityWarning, ProblemOverlappingNetwork, "c", "10.1.2.0/24", "b", "10.1.0.0/16"},
+			},
+		},
+		{
+			name: "missing cachegroup",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network: []string{"10.0.0.0/24"}},
+				"b": {Network: []string{"10.0.1.0/24"}},
+			},
+			crc:      crc,
+			networks: 2,
+			expected: []problemKey{{SeverityError, ProblemMissingCacheGroup, "b", "", "", ""}},
+		},
+		{
+			name: "invalid coordinates",
+			zones: map[string]CZFCoverageZone{
+				"a": {Coordinates: CZFLatLon{Latitude: 91, Longitude: -181}},
+				"b": {Coordinates: CZFLatLon{Latitude: -90, Longitude: 180}},
+			},
+			expected: []problemKey{
+				{SeverityError, ProblemInvalidCoordinates, "a", "", "", ""},
+				{SeverityError, ProblemInvalidCoordinates, "a", "", "", ""},
+			},
+		},
+		{
+			name: "ordered by zone",
+			zones: map[string]CZFCoverageZone{
+				"a": {Network6: []string{"2001:db8::/32"}},
+				"b": {Network6: []string{"2001:db8:1::/48"}, Coordinates: CZFLatLon{Latitude: 100}},
+				"c": {Network: []string{"bad"}},
+			},
+			networks: 3,
+			expected: []problemKey{
+				{SeverityError, ProblemInvalidCoordinates, "b", "", "", ""},
+				{SeverityWarning, ProblemOverlappingNetwork, "b", "2001:db8:1::/48", "a", "2001:db8::/32"},
+				{SeverityError, ProblemMalformedNetwork, "c", "bad", "", ""},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rp := Validate(&CZF{CoverageZones: test.zones}, test.crc)
+			if got := problemKeys(rp.Problems); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("problems\n%+v\nexpected\n%+v", got, test.expected)
+			}
+			errs, warnings := 0, 0
+			for _, pr := range test.expected {
+				if pr.Severity == SeverityError {
+					errs++
+				} else {
+					warnings++
+				}
+			}
+			if rp.Zones != len(test.zones) || rp.Networks != test.networks || rp.Errors != errs || rp.Warnings != warnings {
+				t.Errorf("counted %d zones %d networks %d errors %d warnings, expected %d %d %d %d", rp.Zones, rp.Networks, rp.Errors, rp.Warnings, len(test.zones), test.networks, errs, warnings)
+			}
+		})
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	validPath := filepath.Join(dir, "czf.json")
+	if err := ioutil.WriteFile(validPath, []byte(`{"coverageZones": {"a": {"network": ["10.0.0.0/24"]}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	badPath := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(badPath, []byte(`{"coverageZones": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected []problemKey
+	}{
+		{"valid", validPath, []problemKey{}},
+		{"missing file", filepath.Join(dir, "missing.json"), []problemKey{{SeverityError, ProblemMalformedFile, "", "", "", ""}}},
+		{"malformed json", badPath, []problemKey{{SeverityError, ProblemMalformedFile, "", "", "", ""}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rp := ValidateFile(test.path, nil)
+			if got := problemKeys(rp.Problems); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("problems %+v, expected %+v", got, test.expected)
+			}
+			if rp.Errors != len(test.expected) {
+				t.Errorf("counted %d errors, expected %d", rp.Errors, len(test.expected))
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// package deepczf contains the Deep Coverage Zone File (Deep CZF), which maps client networks to specific caches, for deep caching Delivery Services.
+//
+// Deep caches are typically deployed inside a customer's network. Rather than a cachegroup, each Deep CZF zone lists the cache hostnames which serve its clients.
+//
+package deepczf
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+)
+
+type DeepCZF struct {
+	Revision          string                      `json:"revision"`
+	CustomerName      string                      `json:"customerName"`
+	DeepCoverageZones map[string]DeepCoverageZone `json:"deepCoverageZones"`
+}
+
+// DeepCoverageZone is a Deep CZF zone. The networks and coordinates are those of a regular CZF zone.
+type DeepCoverageZone struct {
+	czf.CZFCoverageZone
+	Caches []string `json:"caches"`
+}
+
+// ParsedDeepCZF is a parsed Deep CZF.
+//
+// The zones' networks are a ParsedCZF, so deep zones are looked up exactly like regular coverage zones.
+//
+type ParsedDeepCZF struct {
+	*czf.ParsedCZF
+	// Caches is the cache hostnames of each zone.
+	Caches map[string][]tc.CacheName
+}
+
+func LoadDeepCZF(path string) (*DeepCZF, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("loading file: " + err.Error())
+	}
+	defer fi.Close()
+	dcz := DeepCZF{}
+	if err := json.NewDecoder(fi).Decode(&dcz); err != nil {
+		return nil, errors.New("decoding: " + err.Error())
+	}
+	return &dcz, nil
+}
+
+// Parse parses the networks of the Deep CZF. Returns an error if any network is malformed, or any zone has no caches.
+func Parse(dcz *DeepCZF) (*ParsedDeepCZF, error) {
+	zones := map[string]czf.ParsedCZFCoverageZone{}
+	caches := map[string][]tc.CacheName{}
+	for zoneName, zone := range dcz.DeepCoverageZones {
+		if len(zone.Caches) == 0 {
+			return nil, errors.New("zone '" + zoneName + "' has no caches")
+		}
+		parsedZone, err := czf.ParseCZNet(zone.CZFCoverageZone)
+		if err != nil {
+			return nil, errors.New("parsing zone '" + zoneName + "': " + err.Error())
+		}
+		zones[zoneName] = parsedZone
+		for _, cache := range zone.Caches {
+			caches[zoneName] = append(caches[zoneName], tc.CacheName(cache))
+		}
+	}
+	return &ParsedDeepCZF{ParsedCZF: czf.NewParsedCZF(dcz.Revision, dcz.CustomerName, zones), Caches: caches}, nil
+}
@@ -0,0 +1,61 @@
+package deepczf
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+)
+
+func TestLoadAndParse(t *testing.T) {
+	dcz, err := LoadDeepCZF("testdata/deepczf.json")
+	if err != nil {
+		t.Fatalf("LoadDeepCZF: %v", err)
+	}
+	parsed, err := Parse(dcz)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	expectedCaches := map[string][]tc.CacheName{"deep1": {"edge2", "edge4"}, "deep2": {"edge3"}}
+	if !reflect.DeepEqual(parsed.Caches, expectedCaches) {
+		t.Errorf("caches %v, expected %v", parsed.Caches, expectedCaches)
+	}
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"10.5.1.1", "deep1"},
+		{"10.5.7.1", "deep2"}, // the longest prefix
+		{"2001:db8:5::1", "deep1"},
+		{"10.6.0.1", ""},
+	}
+	for _, test := range tests {
+		if zone := parsed.GetZone(net.ParseIP(test.ip)); zone != test.expected {
+			t.Errorf("GetZone(%s) = '%s', expected '%s'", test.ip, zone, test.expected)
+		}
+	}
+
+	if _, err := LoadDeepCZF(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadDeepCZF(missing file) succeeded, expected an error")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		zone DeepCoverageZone
+	}{
+		{"no caches", DeepCoverageZone{CZFCoverageZone: czf.CZFCoverageZone{Network: []string{"10.5.0.0/16"}}}},
+		{"malformed network", DeepCoverageZone{CZFCoverageZone: czf.CZFCoverageZone{Network: []string{"10.5.0.0/33"}}, Caches: []string{"edge2"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(&DeepCZF{DeepCoverageZones: map[string]DeepCoverageZone{"deep1": test.zone}}); err == nil {
+				t.Error("Parse succeeded, expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// package dnssec contains DNSSEC key loading and online signing of DNS RRsets.
+//
+// Traffic Router answers are computed per-request (they depend on the client's location and cache health),
+// so zones can't be pre-signed. Instead, RRsets are signed when they're served, and the signatures are cached per RRset.
+package dnssec
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SignatureValidity is how long signatures are valid after they're created.
+const SignatureValidity = time.Hour * 24 * 7
+
+// SignatureInceptionOffset is how long before the current time signatures become valid, to allow for clock skew with validators.
+const SignatureInceptionOffset = time.Hour
+
+// SignatureRefresh is how long signatures are cached before new ones are created.
+// This must be less than SignatureValidity minus the longest TTL served, so cached signatures never expire in resolver caches.
+const SignatureRefresh = SignatureValidity / 2
+
+// MaxCachedRRSets is the maximum number of signed RRsets to cache.
+// Answers vary per client, so the cache must be bounded. When it's full, it's cleared.
+const MaxCachedRRSets = 100000
+
+// Key is a DNSSEC key, with its public DNSKEY record and private signer.
+type Key struct {
+	DNSKEY  *dns.DNSKEY
+	Private crypto.Signer
+}
+
+// Keys contains the Key Signing Keys and Zone Signing Keys for a zone.
+type Keys struct {
+	Zone string // Zone is the FQDN of the zone the keys are for, with a trailing period.
+	KSKs []Key
+	ZSKs []Key
+}
+
+// LoadKeys loads the DNSSEC keys in keyDir.
+// Keys must be in the BIND format generated by dnssec-keygen and ldns-keygen:
+// pairs of Kzone.+alg+tag.key files with the DNSKEY record, and Kzone.+alg+tag.private files with the private key.
+//
+// Keys with the SEP flag set are Key Signing Keys, all others are Zone Signing Keys.
+// All keys must be for the same zone, and there must be at least one of each.
+//
+func LoadKeys(keyDir string) (*Keys, error) {
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return nil, errors.New("reading directory: " + err.Error())
+	}
+	keys := &Keys{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".key") {
+			continue
+		}
+		keyPath := filepath.Join(keyDir, file.Name())
+		privatePath := strings.TrimSuffix(keyPath, ".key") + ".private"
+		key, err := loadKey(keyPath, privatePath)
+		if err != nil {
+			return nil, errors.New("loading key '" + keyPath + "': " + err.Error())
+		}
+		zone := dns.CanonicalName(key.DNSKEY.Hdr.Name)
+		if keys.Zone == "" {
+			keys.Zone = zone
+		} else if keys.Zone != zone {
+			return nil, errors.New("key '" + keyPath + "' is for zone '" + zone + "', but other keys are for zone '" + keys.Zone + "'")
+		}
+		if key.DNSKEY.Flags&dns.SEP != 0 {
+			keys.KSKs = append(keys.KSKs, key)
+		} else {
+			keys.ZSKs = append(keys.ZSKs, key)
+		}
+	}
+	if len(keys.KSKs) == 0 {
+		return nil, errors.New("no Key Signing Keys found")
+	}
+	if len(keys.ZSKs) == 0 {
+		return nil, errors.New("no Zone Signing Keys found")
+	}
+	return keys, nil
+}
+
+func loadKey(keyPath string, privatePath string) (Key, error) {
+	keyFile, err := os.Open(keyPath)
+	if err != nil {
+		return Key{}, errors.New("opening: " + err.Error())
+	}
+	defer keyFile.Close()
+	rr, err := dns.ReadRR(keyFile, keyPath)
+	if err != nil {
+		return Key{}, errors.New("parsing: " + err.Error())
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return Key{}, errors.New("not a DNSKEY record")
+	}
+	if dnskey.Flags&dns.ZONE == 0 {
+		return Key{}, errors.New("not a zone key")
+	}
+
+	privateFile, err := os.Open(privatePath)
+	if err != nil {
+		return Key{}, errors.New("opening private key: " + err.Error())
+	}
+	defer privateFile.Close()
+	privateKey, err := dnskey.ReadPrivateKey(privateFile, privatePath)
+	if err != nil {
+		return Key{}, errors.New("parsing private key: " + err.Error())
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return Key{}, errors.New("private key is not a signer")
+	}
+	return Key{DNSKEY: dnskey, Private: signer}, nil
+}
+
+// Signer signs RRsets for a zone, and caches the signatures.
+//
+// All functions may safely be called by multiple goroutines.
+type Signer struct {
+	keys *Keys
+
+	m     sync.RWMutex
+	cache map[string]signedRRSet
+}
+
+type signedRRSet struct {
+	rrsigs    []dns.RR
+	refreshAt time.Time
+}
+
+// NewSigner creates a new Signer for the given keys.
+func NewSigner(keys *Keys) *Signer {
+	return &Signer{keys: keys, cache: map[string]signedRRSet{}}
+}
+
+// Zone returns the FQDN of the zone signed by this Signer, with a trailing period.
+func (sg *Signer) Zone() string { return sg.keys.Zone }
+
+// DNSKEYs returns the DNSKEY RRset of the zone, with the given TTL.
+func (sg *Signer) DNSKEYs(ttl uint32) []dns.RR {
+	rrs := []dns.RR{}
+	for _, keys := range [][]Key{sg.keys.KSKs, sg.keys.ZSKs} {
+		for _, key := range keys {
+			dnskey := *key.DNSKEY
+			dnskey.Hdr.Ttl = ttl
+			rrs = append(rrs, &dnskey)
+		}
+	}
+	return rrs
+}
+
+// Sign returns the RRSIG records for all RRsets in rrs.
+// The DNSKEY RRset is signed with the Key Signing Keys, and all others with the Zone Signing Keys.
+// OPT and RRSIG records are not signed, and records outside the zone are ignored.
+//
+// Signatures are cached per RRset, so identical RRsets aren't signed again until their signatures need refreshed.
+//
+func (sg *Signer) Sign(rrs []dns.RR) ([]dns.RR, error) {
+	rrsigs := []dns.RR{}
+	for _, rrset := range splitRRSets(rrs, sg.keys.Zone) {
+		sigs, err := sg.signRRSet(rrset)
+		if err != nil {
+			return nil, err
+		}
+		rrsigs = append(rrsigs, sigs...)
+	}
+	return rrsigs, nil
+}
+
+func (sg *Signer) signRRSet(rrset []dns.RR) ([]dns.RR, error) {
+	cacheKey := rrsetCacheKey(rrset)
+	now := time.Now()
+
+	sg.m.RLock()
+	cached, ok := sg.cache[cacheKey]
+	sg.m.RUnlock()
+	if ok && now.Before(cached.refreshAt) {
+		return cached.rrsigs, nil
+	}
+
+	keys := sg.keys.ZSKs
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+		keys = sg.keys.KSKs
+	}
+
+	rrsigs := []dns.RR{}
+	for _, key := range keys {
+		rrsig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+			KeyTag:     key.DNSKEY.KeyTag(),
+			SignerName: sg.keys.Zone,
+			Algorithm:  key.DNSKEY.Algorithm,
+			Inception:  uint32(now.Add(-SignatureInceptionOffset).Unix()),
+			Expiration: uint32(now.Add(SignatureValidity).Unix()),
+		}
+		if err := rrsig.Sign(key.Private, rrset); err != nil {
+			return nil, fmt.Errorf("signing %v %v with key %v: %v", rrset[0].Header().Name, dns.TypeToString[rrset[0].Header().Rrtype], rrsig.KeyTag, err)
+		}
+		rrsigs = append(rrsigs, rrsig)
+	}
+
+	sg.m.Lock()
+	if len(sg.cache) >= MaxCachedRRSets {
+		sg.cache = map[string]signedRRSet{}
+	}
+	sg.cache[cacheKey] = signedRRSet{rrsigs: rrsigs, refreshAt: now.Add(SignatureRefresh)}
+	sg.m.Unlock()
+
+	return rrsigs, nil
+}
+
+// splitRRSets groups rrs into RRsets, by name and type, in the order they first appear.
+// Records which can't be signed by the zone, OPT and RRSIG and records outside the zone, are omitted.
+func splitRRSets(rrs []dns.RR, zone string) [][]dns.RR {
+	rrsets := [][]dns.RR{}
+	rrsetIndexes := map[string]int{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		name := dns.CanonicalName(hdr.Name)
+		if !dns.IsSubDomain(zone, name) {
+			continue
+		}
+		key := name + " " + dns.TypeToString[hdr.Rrtype]
+		if i, ok := rrsetIndexes[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		rrsetIndexes[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	return rrsets
+}
+
+// rrsetCacheKey returns a key uniquely identifying the RRset's signed data, including the TTL, independent of record order.
+// Owner names are canonicalized, so requests with randomized case (draft-vixie-dnsext-dns0x20) share signatures.
+func rrsetCacheKey(rrset []dns.RR) string {
+	strs := make([]string, 0, len(rrset))
+	for _, rr := range rrset {
+		rr = dns.Copy(rr)
+		rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+		strs = append(strs, rr.String())
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, "\n")
+}
@@ -0,0 +1,224 @@
+package dnssec
+
+import (
+	"crypto"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = "cdn.example.net."
+
+// newTestKey generates a new ECDSA P-256 key for the zone. It's a Key Signing Key if flags has the SEP bit.
+func newTestKey(t *testing.T, zone string, flags uint16) Key {
+	t.Helper()
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := dnskey.Generate(256)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return Key{DNSKEY: dnskey, Private: priv.(crypto.Signer)}
+}
+
+func newTestKeys(t *testing.T) *Keys {
+	t.Helper()
+	return &Keys{Zone: testZone, KSKs: []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP)}, ZSKs: []Key{newTestKey(t, testZone, dns.ZONE)}}
+}
+
+// writeTestKey writes the key to dir as dnssec-keygen does, a Kzone.+alg+tag.key file, and a .private file if writePrivate.
+func writeTestKey(t *testing.T, dir string, key Key, writePrivate bool) {
+	t.Helper()
+	base := filepath.Join(dir, "K"+key.DNSKEY.Hdr.Name+"+"+strconv.Itoa(int(key.DNSKEY.Algorithm))+"+"+strconv.Itoa(int(key.DNSKEY.KeyTag())))
+	if err := ioutil.WriteFile(base+".key", []byte(key.DNSKEY.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !writePrivate {
+		return
+	}
+	if err := ioutil.WriteFile(base+".private", []byte(key.DNSKEY.PrivateKeyString(key.Private)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []Key
+		noPrivate bool
+		expectErr bool
+	}{
+		{"ksk and zsk", []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP), newTestKey(t, testZone, dns.ZONE)}, false, false},
+		{"no ksk", []Key{newTestKey(t, testZone, dns.ZONE)}, false, true},
+		{"no zsk", []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP)}, false, true},
+		{"different zones", []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP), newTestKey(t, "other.example.net.", dns.ZONE)}, false, true},
+		{"not a zone key", []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP), newTestKey(t, testZone, 0)}, false, true},
+		{"no private key", []Key{newTestKey(t, testZone, dns.ZONE|dns.SEP), newTestKey(t, testZone, dns.ZONE)}, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, key := range test.keys {
+				writeTestKey(t, dir, key, !test.noPrivate)
+			}
+			keys, err := LoadKeys(dir)
+			if test.expectErr {
+				if err == nil {
+					t.Fatal("LoadKeys succeeded, expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKeys: %v", err)
+			}
+			if keys.Zone != testZone || len(keys.KSKs) != 1 || len(keys.ZSKs) != 1 {
+				t.Fatalf("loaded zone '%s' %d KSKs %d ZSKs, expected '%s' 1 1", keys.Zone, len(keys.KSKs), len(keys.ZSKs), testZone)
+			}
+			if keys.KSKs[0].DNSKEY.KeyTag() != test.keys[0].DNSKEY.KeyTag() || keys.ZSKs[0].DNSKEY.KeyTag() != test.keys[1].DNSKEY.KeyTag() {
+				t.Error("loaded keys aren't the written keys")
+			}
+		})
+	}
+	if _, err := LoadKeys(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadKeys(missing dir) succeeded, expected an error")
+	}
+}
+
+func newA(name string, ip string) dns.RR {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}, A: net.ParseIP(ip).To4()}
+}
+
+func newAAAA(name string, ip string) dns.RR {
+	return &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 30}, AAAA: net.ParseIP(ip)}
+}
+
+// findDNSKEY returns the DNSKEY of keys with the key tag of the RRSIG, or nil if there is none.
+func findDNSKEY(keys []Key, rrsig *dns.RRSIG) *dns.DNSKEY {
+	for _, key := range keys {
+		if key.DNSKEY.KeyTag() == rrsig.KeyTag {
+			return key.DNSKEY
+		}
+	}
+	return nil
+}
+
+func TestSign(t *testing.T) {
+	keys := newTestKeys(t)
+	signer := NewSigner(keys)
+
+	aSet := []dns.RR{newA("edge.ds.cdn.example.net.", "192.0.2.1"), newA("edge.ds.cdn.example.net.", "192.0.2.2")}
+	aaaaSet := []dns.RR{newAAAA("edge.ds.cdn.example.net.", "2001:db8::1")}
+	dnskeySet := signer.DNSKEYs(3600)
+	rrsets := map[uint16][]dns.RR{dns.TypeA: aSet, dns.TypeAAAA: aaaaSet, dns.TypeDNSKEY: dnskeySet}
+
+	rrs := append(append(append([]dns.RR{}, aSet...), aaaaSet...), dnskeySet...)
+	rrsigs, err := signer.Sign(rrs)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(rrsigs) != len(rrsets) {
+		t.Fatalf("Sign returned %d RRSIGs, expected one per RRset, %d", len(rrsigs), len(rrsets))
+	}
+	for _, rr := range rrsigs {
+		rrsig := rr.(*dns.RRSIG)
+		rrset := rrsets[rrsig.TypeCovered]
+		signingKeys := keys.ZSKs
+		if rrsig.TypeCovered == dns.TypeDNSKEY {
+			signingKeys = keys.KSKs
+		}
+		dnskey := findDNSKEY(signingKeys, rrsig)
+		if dnskey == nil {
+			t.Errorf("%s RRSIG key tag %d isn't a key which should sign it", dns.TypeToString[rrsig.TypeCovered], rrsig.KeyTag)
+			continue
+		}
+		if rrsig.SignerName != testZone {
+			t.Errorf("%s RRSIG signer '%s', expected '%s'", dns.TypeToString[rrsig.TypeCovered], rrsig.SignerName, testZone)
+		}
+		if err := rrsig.Verify(dnskey, rrset); err != nil {
+			t.Errorf("verifying %s RRSIG: %v", dns.TypeToString[rrsig.TypeCovered], err)
+		}
+		if !rrsig.ValidityPeriod(time.Now()) {
+			t.Errorf("%s RRSIG isn't valid now", dns.TypeToString[rrsig.TypeCovered])
+		}
+	}
+}
+
+func TestSignCache(t *testing.T) {
+	signer := NewSigner(newTestKeys(t))
+	rrset := []dns.RR{newA("edge.ds.cdn.example.net.", "192.0.2.1"), newA("edge.ds.cdn.example.net.", "192.0.2.2")}
+	first, err := signer.Sign(rrset)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	// ECDSA signatures are random, so the same signature means it came from the cache.
+	// The same RRset in another order and case is the same signed data, so it's a hit too.
+	same := []dns.RR{newA("EDGE.ds.cdn.example.net.", "192.0.2.2"), newA("edge.DS.cdn.example.net.", "192.0.2.1")}
+	second, err := signer.Sign(same)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(second) != 1 || second[0].(*dns.RRSIG).Signature != first[0].(*dns.RRSIG).Signature {
+		t.Error("signing the same RRset again made a new signature, expected the cached one")
+	}
+
+	other := []dns.RR{newA("edge.ds.cdn.example.net.", "192.0.2.3")}
+	third, err := signer.Sign(other)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if third[0].(*dns.RRSIG).Signature == first[0].(*dns.RRSIG).Signature {
+		t.Error("signing a different RRset returned the cached signature of another")
+	}
+}
+
+func TestSignCacheClearedWhenFull(t *testing.T) {
+	signer := NewSigner(newTestKeys(t))
+	for i := 0; i < MaxCachedRRSets; i++ {
+		signer.cache[strconv.Itoa(i)] = signedRRSet{refreshAt: time.Now().Add(time.Hour)}
+	}
+	if _, err := signer.Sign([]dns.RR{newA("edge.ds.cdn.example.net.", "192.0.2.1")}); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if len(signer.cache) != 1 {
+		t.Errorf("cache has %d RRsets after signing with a full cache, expected it cleared to only the new one", len(signer.cache))
+	}
+}
+
+func TestSplitRRSets(t *testing.T) {
+	a1 := newA("edge.ds.cdn.example.net.", "192.0.2.1")
+	a2 := newA("EDGE.ds.cdn.example.net.", "192.0.2.2")
+	aaaa := newAAAA("edge.ds.cdn.example.net.", "2001:db8::1")
+	otherA := newA("other.ds.cdn.example.net.", "192.0.2.3")
+	outside := newA("edge.example.org.", "192.0.2.4")
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	rrsig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "edge.ds.cdn.example.net.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, TypeCovered: dns.TypeA}
+
+	tests := []struct {
+		name     string
+		rrs      []dns.RR
+		expected [][]dns.RR
+	}{
+		{"empty", nil, [][]dns.RR{}},
+		{"one rrset", []dns.RR{a1, a2}, [][]dns.RR{{a1, a2}}},
+		{"by name and type, in order", []dns.RR{aaaa, a1, otherA, a2}, [][]dns.RR{{aaaa}, {a1, a2}, {otherA}}},
+		{"skips opt and rrsig", []dns.RR{opt, a1, rrsig, a2}, [][]dns.RR{{a1, a2}}},
+		{"skips outside the zone", []dns.RR{outside, a1}, [][]dns.RR{{a1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitRRSets(test.rrs, testZone); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("splitRRSets = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
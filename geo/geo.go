@@ -0,0 +1,39 @@
+// package geo contains geolocation providers, which locate clients by IP, for routing clients not in the coverage zone file to the nearest cachegroup.
+package geo
+
+import (
+	"net"
+)
+
+// Geolocation provider names, as in the CRConfig Delivery Service geolocationProvider.
+const ProviderMaxMind = "maxmindGeolocationService"
+const ProviderNeustar = "neustarGeolocationService"
+
+// Location is a latitude and longitude, in degrees.
+type Location struct {
+	Lat float64
+	Lon float64
+}
+
+// Provider locates IPs.
+//
+// A Provider must be safe for use by multiple goroutines, and must not be modified after it's created, because it's used by handlers.
+//
+type Provider interface {
+	// Locate returns the location of the IP, or false if it's unknown.
+	Locate(ip net.IP) (Location, bool)
+}
+
+// Providers is the geolocation providers, by CRConfig provider name.
+// Providers must not be modified after they're given to a Shared.
+type Providers map[string]Provider
+
+// Get returns the provider with the given name, for a Delivery Service's geolocationProvider.
+// If there is no provider with the name, e.g. the DS uses Neustar but no Neustar database is configured, returns the MaxMind provider.
+// Returns nil if there is neither, in which case clients can't be geolocated.
+func (ps Providers) Get(name string) Provider {
+	if provider, ok := ps[name]; ok {
+		return provider
+	}
+	return ps[ProviderMaxMind]
+}
@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MaxMind is a Provider which locates IPs with a local MaxMind GeoIP2 or GeoLite2 City database, an .mmdb file.
+type MaxMind struct {
+	reader *maxminddb.Reader
+}
+
+// maxMindRecord is the part of a MaxMind City record needed to locate an IP.
+// The fields are pointers, because records without a location are distinct from the location 0,0.
+type maxMindRecord struct {
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// LoadMaxMind loads the MaxMind database at path.
+//
+// The file is read into memory, rather than memory-mapped, so a database replaced on disk and reloaded never affects the one being served,
+// and the old one is freed by the garbage collector when the Shared using it is no longer served.
+//
+func LoadMaxMind(path string) (*MaxMind, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("reading file: " + err.Error())
+	}
+	reader, err := maxminddb.FromBytes(bts)
+	if err != nil {
+		return nil, errors.New("parsing: " + err.Error())
+	}
+	return &MaxMind{reader: reader}, nil
+}
+
+// Locate returns the location of ip in the MaxMind database, or false if it has none.
+func (mm *MaxMind) Locate(ip net.IP) (Location, bool) {
+	if ip.To4() == nil && mm.reader.Metadata.IPVersion == 4 {
+		return Location{}, false // IPv4-only databases can't locate IPv6 clients, which isn't an error
+	}
+	record := maxMindRecord{}
+	if err := mm.reader.Lookup(ip, &record); err != nil {
+		fmt.Println("ERROR: geo: looking up '" + ip.String() + "' in MaxMind database: " + err.Error())
+		return Location{}, false
+	}
+	if record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return Location{}, false
+	}
+	return Location{Lat: *record.Location.Latitude, Lon: *record.Location.Longitude}, true
+}
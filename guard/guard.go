@@ -0,0 +1,152 @@
+// package guard contains the CRConfig sanity guard, which refuses new CRConfigs that would catastrophically change routing,
+// such as a broken Traffic Ops snapshot missing most Delivery Services or servers.
+package guard
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// DefaultMaxDropPercent is the default maximum percent the number of Delivery Services, servers, or cachegroups may drop in a new CRConfig.
+const DefaultMaxDropPercent = 50
+
+// Refusals is the number of CRConfigs refused by the guard, exposed as the crconfig_guard_refusals metric.
+var Refusals = expvar.NewInt("crconfig_guard_refusals")
+
+// Overrides is the number of CRConfigs applied despite the guard, because of an admin override, exposed as the crconfig_guard_overrides metric.
+var Overrides = expvar.NewInt("crconfig_guard_overrides")
+
+// Guard checks new CRConfigs against the one being served, and refuses them if the number of Delivery Services, servers,
+// or cachegroups drops by more than MaxDropPercent, or if the CDN domain changes.
+//
+// A Guard may safely be used by multiple goroutines.
+//
+type Guard struct {
+	maxDropPercent int
+	override       bool
+	mutex          sync.Mutex
+}
+
+// New creates a new Guard. If maxDropPercent is 0, DefaultMaxDropPercent is used. If it's negative, drops are never refused.
+func New(maxDropPercent int) *Guard {
+	gu := &Guard{}
+	gu.SetMaxDropPercent(maxDropPercent)
+	return gu
+}
+
+// SetMaxDropPercent sets the maximum drop percent, as in New, e.g. on config reload.
+func (gu *Guard) SetMaxDropPercent(maxDropPercent int) {
+	if maxDropPercent == 0 {
+		maxDropPercent = DefaultMaxDropPercent
+	}
+	gu.mutex.Lock()
+	defer gu.mutex.Unlock()
+	gu.maxDropPercent = maxDropPercent
+}
+
+// Override makes the guard accept the next CRConfig it would refuse, however much it changes. This is the admin override,
+// for when a large change is intended. CRConfigs the guard accepts anyway don't use it up, so an unrelated poll
+// landing before the intended change doesn't waste it. Nor do CRConfigs it accepts which then fail to be applied, see UseOverride.
+func (gu *Guard) Override() {
+	gu.mutex.Lock()
+	defer gu.mutex.Unlock()
+	gu.override = true
+}
+
+// Check returns an error if newCRC should be refused, in favor of continuing to serve oldCRC.
+// Refusals are logged with a summary of the change. If oldCRC is nil, i.e. nothing is being served yet, nothing is refused.
+//
+// If newCRC would be refused but the guard has been overridden, it's accepted, and Check returns true.
+// The override isn't used up by Check: the caller must call UseOverride once newCRC has been built and swapped in,
+// so an overridden CRConfig which then can't be served doesn't waste the override.
+//
+func (gu *Guard) Check(oldCRC *tc.CRConfig, newCRC *tc.CRConfig) (bool, error) {
+	if oldCRC == nil || newCRC == nil {
+		return false, nil
+	}
+	gu.mutex.Lock()
+	maxDropPercent := gu.maxDropPercent
+	override := gu.override
+	gu.mutex.Unlock()
+
+	problems := []string{}
+	oldDomain, newDomain := configStr(oldCRC, "domain_name"), configStr(newCRC, "domain_name")
+	if !strings.EqualFold(strings.TrimSuffix(oldDomain, "."), strings.TrimSuffix(newDomain, ".")) {
+		problems = append(problems, "config/domain_name changed from '"+oldDomain+"' to '"+newDomain+"'")
+	}
+	if maxDropPercent > 0 {
+		counts := []struct {
+			name     string
+			old, new int
+		}{
+			{"deliveryServices", len(oldCRC.DeliveryServices), len(newCRC.DeliveryServices)},
+			{"contentServers", len(oldCRC.ContentServers), len(newCRC.ContentServers)},
+			{"cachegroups", numCacheGroups(oldCRC), numCacheGroups(newCRC)},
+		}
+		for _, count := range counts {
+			if dropPercent := dropPercent(count.old, count.new); dropPercent > maxDropPercent {
+				problems = append(problems, count.name+" dropped "+strconv.Itoa(dropPercent)+"% from "+strconv.Itoa(count.old)+" to "+strconv.Itoa(count.new)+", more than the max "+strconv.Itoa(maxDropPercent)+"%")
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return false, nil
+	}
+
+	summary := strings.Join(problems, ", ")
+	if override {
+		fmt.Println("EVENT: crconfig guard: accepting CRConfig despite " + summary + ", because of admin override")
+		return true, nil
+	}
+	Refusals.Add(1)
+	fmt.Println("ERROR: crconfig guard: refusing CRConfig: " + summary + ". Continuing to serve the old CRConfig. If the change is intended, override the guard.")
+	return false, errors.New("refused by crconfig guard: " + summary)
+}
+
+// UseOverride uses up the override, after a CRConfig which Check accepted only because of it has been swapped in.
+// If the override has already been used, e.g. by a concurrent CRConfig, nothing happens.
+func (gu *Guard) UseOverride() {
+	gu.mutex.Lock()
+	defer gu.mutex.Unlock()
+	if !gu.override {
+		return
+	}
+	gu.override = false
+	Overrides.Add(1)
+	fmt.Println("EVENT: crconfig guard: applied CRConfig because of admin override, the override is used up")
+}
+
+// dropPercent returns the percent newCount is less than oldCount, or 0 if it isn't less.
+func dropPercent(oldCount int, newCount int) int {
+	if oldCount <= 0 || newCount >= oldCount {
+		return 0
+	}
+	return (oldCount - newCount) * 100 / oldCount
+}
+
+// numCacheGroups returns the number of distinct cachegroups of the CRConfig's content servers.
+func numCacheGroups(crc *tc.CRConfig) int {
+	cgs := map[string]struct{}{}
+	for _, server := range crc.ContentServers {
+		if server.CacheGroup != nil {
+			cgs[*server.CacheGroup] = struct{}{}
+		}
+	}
+	return len(cgs)
+}
+
+// configStr returns the CRConfig config value of key, or the empty string if it doesn't exist or isn't a string.
+func configStr(crc *tc.CRConfig, key string) string {
+	iVal, ok := crc.Config[key]
+	if !ok {
+		return ""
+	}
+	val, _ := iVal.(string)
+	return val
+}
@@ -0,0 +1,103 @@
+package guard
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// testCRConfig returns a CRConfig in domain with numDSes Delivery Services, and numServers servers spread over numCGs cachegroups.
+func testCRConfig(domain string, numDSes int, numServers int, numCGs int) *tc.CRConfig {
+	crc := &tc.CRConfig{
+		Config:           map[string]interface{}{"domain_name": domain},
+		DeliveryServices: map[string]tc.CRConfigDeliveryService{},
+		ContentServers:   map[string]tc.CRConfigTrafficOpsServer{},
+	}
+	for i := 0; i < numDSes; i++ {
+		crc.DeliveryServices["ds"+strconv.Itoa(i)] = tc.CRConfigDeliveryService{}
+	}
+	for i := 0; i < numServers; i++ {
+		cg := "cg" + strconv.Itoa(i%numCGs)
+		crc.ContentServers["edge"+strconv.Itoa(i)] = tc.CRConfigTrafficOpsServer{CacheGroup: &cg}
+	}
+	return crc
+}
+
+func TestCheck(t *testing.T) {
+	old := testCRConfig("cdn.example.net", 10, 100, 10)
+	tests := []struct {
+		name           string
+		maxDropPercent int
+		old            *tc.CRConfig
+		new            *tc.CRConfig
+		expectRefused  bool
+	}{
+		{"same", 0, old, testCRConfig("cdn.example.net", 10, 100, 10), false},
+		{"growth", 0, old, testCRConfig("cdn.example.net", 20, 200, 20), false},
+		{"small drop", 0, old, testCRConfig("cdn.example.net", 6, 60, 6), false},
+		{"drop at max", 0, old, testCRConfig("cdn.example.net", 5, 100, 10), false},
+		{"ds drop", 0, old, testCRConfig("cdn.example.net", 4, 100, 10), true},
+		{"server drop", 0, old, testCRConfig("cdn.example.net", 10, 40, 10), true},
+		{"cachegroup drop", 0, old, testCRConfig("cdn.example.net", 10, 100, 4), true},
+		{"all dropped", 0, old, testCRConfig("cdn.example.net", 0, 0, 1), true},
+		{"low max", 10, old, testCRConfig("cdn.example.net", 8, 100, 10), true},
+		{"drops disabled", -1, old, testCRConfig("cdn.example.net", 0, 0, 1), false},
+		{"domain changed", -1, old, testCRConfig("other.example.net", 10, 100, 10), true},
+		{"domain case and trailing period", 0, old, testCRConfig("CDN.example.net.", 10, 100, 10), false},
+		{"nothing served", 0, nil, testCRConfig("cdn.example.net", 0, 0, 1), false},
+		{"nil new", 0, old, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			overridden, err := New(test.maxDropPercent).Check(test.old, test.new)
+			if refused := err != nil; refused != test.expectRefused {
+				t.Errorf("Check refused %t (%v), expected %t", refused, err, test.expectRefused)
+			}
+			if overridden {
+				t.Error("Check returned overridden without an override")
+			}
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	old := testCRConfig("cdn.example.net", 10, 100, 10)
+	fine := testCRConfig("cdn.example.net", 10, 100, 10)
+	broken := testCRConfig("cdn.example.net", 1, 100, 10)
+
+	gu := New(0)
+	if _, err := gu.Check(old, broken); err == nil {
+		t.Fatal("Check(broken) accepted before override, expected refused")
+	}
+
+	gu.Override()
+	overrides := Overrides.Value()
+	// CRConfigs which would be accepted anyway don't use up the override
+	for i := 0; i < 3; i++ {
+		if overridden, err := gu.Check(old, fine); err != nil || overridden {
+			t.Fatalf("Check(fine) after override = %t, %v, expected accepted without the override", overridden, err)
+		}
+	}
+	// nor does accepting a CRConfig which the caller then fails to apply, and so never calls UseOverride
+	for i := 0; i < 2; i++ {
+		if overridden, err := gu.Check(old, broken); err != nil || !overridden {
+			t.Fatalf("Check(broken) after override = %t, %v, expected accepted because of the override", overridden, err)
+		}
+	}
+	if got := Overrides.Value() - overrides; got != 0 {
+		t.Errorf("overrides metric increased by %d before the override was used, expected 0", got)
+	}
+
+	gu.UseOverride()
+	if got := Overrides.Value() - overrides; got != 1 {
+		t.Errorf("overrides metric increased by %d, expected 1", got)
+	}
+	if _, err := gu.Check(old, broken); err == nil {
+		t.Error("Check(broken) after the override was used = accepted, expected refused")
+	}
+	gu.UseOverride()
+	if got := Overrides.Value() - overrides; got != 1 {
+		t.Errorf("overrides metric increased by %d after using the override twice, expected 1", got)
+	}
+}
@@ -1,13 +1,18 @@
 // package loadconfig contains functions for loading a config file and parsing it fully into a *shared.Shared.
 // This package exists for multiple use, in the main function as well as the srvsighupreload SIGHUP reloader.
+//
+// If the config has a state directory, the last-known-good CRConfig and CRStates persisted by the pollers are loaded instead of the config files, if they're newer.
 package loadconfig
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/rob05c/traffic_router/config"
-	"github.com/rob05c/traffic_router/crconfig"
 	"github.com/rob05c/traffic_router/czf"
+	"github.com/rob05c/traffic_router/deepczf"
+	"github.com/rob05c/traffic_router/dnssec"
+	"github.com/rob05c/traffic_router/geo"
 	"github.com/rob05c/traffic_router/shared"
 )
 
@@ -22,31 +27,59 @@ func LoadConfig(path string) (*shared.Shared, *config.Config, error) {
 		return nil, nil, errors.New("loading czf file '" + cfg.CZFPath + "': " + err.Error())
 	}
 
-	crc, err := crconfig.LoadCRConfig(cfg.CRConfigPath)
+	crc, crcPath, err := loadNewestCRConfig(cfg.CRConfigPath, cfg.StateDir)
 	if err != nil {
-		return nil, nil, errors.New("loading CRConfig file '" + cfg.CRConfigPath + "': " + err.Error())
+		return nil, nil, errors.New("loading CRConfig: " + err.Error())
 	}
+	fmt.Println("INFO loaded CRConfig '" + crcPath + "'")
 
-	crs, err := crconfig.LoadCRStates(cfg.CRStatesPath)
+	crs, crsPath, err := loadNewestCRStates(cfg.CRStatesPath, cfg.StateDir)
 	if err != nil {
-		return nil, nil, errors.New("loading CRStates file '" + cfg.CRStatesPath + "': " + err.Error())
+		return nil, nil, errors.New("loading CRStates: " + err.Error())
 	}
+	fmt.Println("INFO loaded CRStates '" + crsPath + "'")
 
 	// fmt.Printf("DEBUG crc.config '%v': %+v\n", cfg.CRConfigPath, crc.Config)
 
-	czfParsedNets, err := czf.ParseCZNets(czfRaw.CoverageZones)
+	parsedCZF, err := czf.Parse(czfRaw)
 	if err != nil {
 		return nil, nil, errors.New("parsing czf networks '" + cfg.CZFPath + "': " + err.Error())
 	}
 
-	parsedCZF := &czf.ParsedCZF{Revision: czfRaw.Revision, CustomerName: czfRaw.CustomerName, CoverageZones: czfParsedNets}
+	parsedDeepCZF := (*deepczf.ParsedDeepCZF)(nil)
+	if cfg.DeepCZFPath != "" {
+		deepCZFRaw, err := deepczf.LoadDeepCZF(cfg.DeepCZFPath)
+		if err != nil {
+			return nil, nil, errors.New("loading deep czf file '" + cfg.DeepCZFPath + "': " + err.Error())
+		}
+		if parsedDeepCZF, err = deepczf.Parse(deepCZFRaw); err != nil {
+			return nil, nil, errors.New("parsing deep czf '" + cfg.DeepCZFPath + "': " + err.Error())
+		}
+	}
 
 	certs, err := config.LoadCerts(cfg.CertDir)
 	if err != nil {
 		return nil, nil, errors.New("loading certificates from dir '" + cfg.CertDir + "': " + err.Error())
 	}
 
-	sharedPtr := shared.NewShared(parsedCZF, crc, crs, certs)
+	dnssecKeys := (*dnssec.Keys)(nil)
+	if cfg.DNSSECKeyDir != "" {
+		if dnssecKeys, err = dnssec.LoadKeys(cfg.DNSSECKeyDir); err != nil {
+			return nil, nil, errors.New("loading DNSSEC keys from dir '" + cfg.DNSSECKeyDir + "': " + err.Error())
+		}
+	}
+
+	geoProviders := geo.Providers{}
+	if cfg.GeolocationMaxMindPath != "" {
+		maxMind, err := geo.LoadMaxMind(cfg.GeolocationMaxMindPath)
+		if err != nil {
+			return nil, nil, errors.New("loading MaxMind database '" + cfg.GeolocationMaxMindPath + "': " + err.Error())
+		}
+		geoProviders[geo.ProviderMaxMind] = maxMind
+		fmt.Println("INFO loaded MaxMind database '" + cfg.GeolocationMaxMindPath + "'")
+	}
+
+	sharedPtr := shared.NewShared(parsedCZF, parsedDeepCZF, geoProviders, crc, crs, certs, dnssecKeys)
 	if sharedPtr == nil {
 		return nil, nil, errors.New("fatal error creating Shared object, see log for details.")
 	}
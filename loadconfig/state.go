@@ -0,0 +1,102 @@
+package loadconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfig"
+	"github.com/rob05c/traffic_router/shared"
+)
+
+// loadNewestCRConfig loads the newest valid CRConfig of the config file crconfig_path, and the last-known-good polled CRConfig in the state directory.
+// Newest is by the CRConfig stats date, or the file modification time if it has none.
+// So a restart during a Traffic Monitor outage serves the last polled CRConfig, unless the file has been replaced with a newer one.
+func loadNewestCRConfig(cfgPath string, stateDir string) (*tc.CRConfig, string, error) {
+	crcs := map[string]*tc.CRConfig{}
+	path, err := loadNewest(cfgPath, stateDir, crconfig.StateCRConfigFileName, func(path string, modTime time.Time) (time.Time, error) {
+		crc, err := crconfig.LoadCRConfig(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if _, err := shared.ValidateCRConfig(crc); err != nil {
+			return time.Time{}, errors.New("invalid: " + err.Error())
+		}
+		crcs[path] = crc
+		if crc.Stats.DateUnixSeconds != nil {
+			return time.Unix(*crc.Stats.DateUnixSeconds, 0), nil
+		}
+		return modTime, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return crcs[path], path, nil
+}
+
+// loadNewestCRStates loads the newest valid CRStates of the config file crstates_path, and the last-known-good polled CRStates in the state directory.
+// Newest is by the file modification time, because CRStates have no date.
+func loadNewestCRStates(cfgPath string, stateDir string) (*tc.CRStates, string, error) {
+	crss := map[string]*tc.CRStates{}
+	path, err := loadNewest(cfgPath, stateDir, crconfig.StateCRStatesFileName, func(path string, modTime time.Time) (time.Time, error) {
+		crs, err := crconfig.LoadCRStates(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		crss[path] = crs
+		return modTime, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return crss[path], path, nil
+}
+
+// loadNewest calls load with each of the state file stateName in stateDir, if it exists, and cfgPath,
+// and returns the path of the one with the newest time. On a tie, the state file is used.
+//
+// The load func returns the time of the file, or an error if it's invalid.
+// Invalid files are logged and skipped. Returns an error if no file is valid.
+//
+func loadNewest(cfgPath string, stateDir string, stateName string, load func(path string, modTime time.Time) (time.Time, error)) (string, error) {
+	paths := []string{}
+	if stateDir != "" {
+		statePath := filepath.Join(stateDir, stateName)
+		if _, err := os.Stat(statePath); err == nil {
+			paths = append(paths, statePath)
+		} else if !os.IsNotExist(err) {
+			fmt.Println("ERROR: loading state file '" + statePath + "', skipping: " + err.Error())
+		}
+	}
+	paths = append(paths, cfgPath)
+
+	newestPath := ""
+	newestTime := time.Time{}
+	errStrs := []string{}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			errStrs = append(errStrs, "'"+path+"': "+err.Error())
+			continue
+		}
+		fileTime, err := load(path, fi.ModTime())
+		if err != nil {
+			errStrs = append(errStrs, "'"+path+"': "+err.Error())
+			continue
+		}
+		if newestPath == "" || fileTime.After(newestTime) {
+			newestPath, newestTime = path, fileTime
+		}
+	}
+	if newestPath == "" {
+		return "", errors.New(strings.Join(errStrs, ", "))
+	}
+	for _, errStr := range errStrs {
+		fmt.Println("ERROR: loading " + errStr + ", skipping and using '" + newestPath + "'")
+	}
+	return newestPath, nil
+}
@@ -0,0 +1,131 @@
+package loadconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rob05c/traffic_router/crconfig"
+)
+
+// writeTestCRConfig writes the shared package test CRConfig to path, with the stats date, or none if date is 0, and the file modification time modTime.
+func writeTestCRConfig(t *testing.T, path string, date int64, modTime time.Time) {
+	t.Helper()
+	bts, err := ioutil.ReadFile("../shared/testdata/crconfig.json")
+	if err != nil {
+		t.Fatalf("reading test CRConfig: %v", err)
+	}
+	crc := map[string]interface{}{}
+	if err := json.Unmarshal(bts, &crc); err != nil {
+		t.Fatalf("decoding test CRConfig: %v", err)
+	}
+	stats := crc["stats"].(map[string]interface{})
+	delete(stats, "date")
+	if date != 0 {
+		stats["date"] = date
+	}
+	if bts, err = json.Marshal(crc); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, string(bts), modTime)
+}
+
+func writeTestFile(t *testing.T, path string, data string, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadNewestCRConfig(t *testing.T) {
+	older, newer := time.Unix(1600000000, 0), time.Unix(1700000000, 0)
+	tests := []struct {
+		name          string
+		writeCfg      func(t *testing.T, path string)
+		writeState    func(t *testing.T, path string)
+		expectedState bool
+		expectErr     bool
+	}{
+		{"newer state date", func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), newer) },
+			func(t *testing.T, path string) { writeTestCRConfig(t, path, newer.Unix(), older) }, true, false},
+		{"newer config file date", func(t *testing.T, path string) { writeTestCRConfig(t, path, newer.Unix(), older) },
+			func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), newer) }, false, false},
+		{"same date uses the state", func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), newer) },
+			func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), older) }, true, false},
+		{"no dates uses the modification time", func(t *testing.T, path string) { writeTestCRConfig(t, path, 0, newer) },
+			func(t *testing.T, path string) { writeTestCRConfig(t, path, 0, older) }, false, false},
+		{"no state", func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), older) }, nil, false, false},
+		{"invalid state", func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), older) },
+			func(t *testing.T, path string) { writeTestFile(t, path, `{"stats": {"date": 1700000000}}`, newer) }, false, false},
+		{"malformed state", func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), older) },
+			func(t *testing.T, path string) { writeTestFile(t, path, `{"stats":`, newer) }, false, false},
+		{"invalid config file", func(t *testing.T, path string) { writeTestFile(t, path, `{}`, newer) },
+			func(t *testing.T, path string) { writeTestCRConfig(t, path, older.Unix(), older) }, true, false},
+		{"neither valid", func(t *testing.T, path string) { writeTestFile(t, path, `{}`, newer) },
+			func(t *testing.T, path string) { writeTestFile(t, path, `{}`, newer) }, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stateDir := filepath.Join(dir, "state")
+			if err := os.Mkdir(stateDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			cfgPath := filepath.Join(dir, "crconfig.json")
+			statePath := filepath.Join(stateDir, crconfig.StateCRConfigFileName)
+			test.writeCfg(t, cfgPath)
+			if test.writeState != nil {
+				test.writeState(t, statePath)
+			}
+			crc, path, err := loadNewestCRConfig(cfgPath, stateDir)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("loadNewestCRConfig loaded '%s', expected an error", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadNewestCRConfig: %v", err)
+			}
+			expectedPath := cfgPath
+			if test.expectedState {
+				expectedPath = statePath
+			}
+			if path != expectedPath || crc == nil {
+				t.Errorf("loadNewestCRConfig loaded '%s', expected '%s'", path, expectedPath)
+			}
+		})
+	}
+}
+
+func TestLoadNewestCRStates(t *testing.T) {
+	older, newer := time.Unix(1600000000, 0), time.Unix(1700000000, 0)
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "crstates.json")
+	statePath := filepath.Join(dir, crconfig.StateCRStatesFileName)
+	crs := `{"caches": {"edge1": {"isAvailable": true}}, "deliveryServices": {}}`
+
+	writeTestFile(t, cfgPath, crs, older)
+	if _, path, err := loadNewestCRStates(cfgPath, dir); err != nil || path != cfgPath {
+		t.Errorf("loadNewestCRStates without a state file loaded '%s' err %v, expected '%s'", path, err, cfgPath)
+	}
+	if _, path, err := loadNewestCRStates(cfgPath, ""); err != nil || path != cfgPath {
+		t.Errorf("loadNewestCRStates without a state directory loaded '%s' err %v, expected '%s'", path, err, cfgPath)
+	}
+
+	writeTestFile(t, statePath, crs, newer)
+	if _, path, err := loadNewestCRStates(cfgPath, dir); err != nil || path != statePath {
+		t.Errorf("loadNewestCRStates with a newer state file loaded '%s' err %v, expected '%s'", path, err, statePath)
+	}
+
+	writeTestFile(t, cfgPath, crs, newer.Add(time.Hour))
+	if _, path, err := loadNewestCRStates(cfgPath, dir); err != nil || path != cfgPath {
+		t.Errorf("loadNewestCRStates with a newer config file loaded '%s' err %v, expected '%s'", path, err, cfgPath)
+	}
+}
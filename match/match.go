@@ -2,7 +2,6 @@ package match
 
 import (
 	"errors"
-	"fmt"
 	"github.com/rob05c/traffic_router/rfc"
 	"regexp"
 	"strings"
@@ -10,16 +9,18 @@ import (
 
 // TODO Traffic Monitor has to do this same matching to determine stat DSes. Put match logic in a generic location, and use with both TR and TM.
 
+// NewDNSDSMatch returns a new DNSDSMatch for the given DNS DS match.
+// The match is case-insensitive, and the FQDNs it's matched against must be lowercase.
 func NewDNSDSMatch(matchStr string) (DNSDSMatch, error) {
 	if strings.HasPrefix(matchStr, `.*\.`) && strings.HasSuffix(matchStr, `\..*`) {
-		return dnsDSMatchContains{str: strings.TrimSuffix(strings.TrimPrefix(matchStr, `.*\.`), `\..*`)}, nil
+		return dnsDSMatchContains{str: strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(matchStr, `.*\.`), `\..*`))}, nil
 	} else if rfc.ValidFQDN(matchStr) {
 		// If the match string is a valid FQDN, we assume it's not a regex.
 		// Be aware it could still be a regex, and e.g. 'foo.bar.com' could be actually wanting to match those dots as anything, e.g. match 'fooabar.com'.
 		// But that would be very strange.
-		return dnsDSMatchLiteral{str: matchStr}, nil
+		return dnsDSMatchLiteral{str: strings.ToLower(matchStr)}, nil
 	} else {
-		re, err := regexp.Compile(matchStr)
+		re, err := regexp.Compile(caseInsensitive + matchStr)
 		if err != nil {
 			return nil, errors.New("compiling regex: " + err.Error())
 		}
@@ -31,22 +32,22 @@ func NewDNSDSMatch(matchStr string) (DNSDSMatch, error) {
 //
 // If the HTTP DS match is of the form `.*\.foo\..*`, it will NOT be a 'contains' match.
 // Rather, HTTP DSes with regexes of this form are turned into literal matches of the form 'prefix.foo.cdndomain'.
+// As with NewDNSDSMatch, the match is case-insensitive, and the FQDNs it's matched against must be lowercase.
 //
 func NewHTTPDSMatch(matchStr string, routingName string, cdnDomain string) (DNSDSMatch, error) {
 	if strings.HasPrefix(matchStr, `.*\.`) && strings.HasSuffix(matchStr, `\..*`) {
 		matchStr = matchStr[4 : len(matchStr)-4] // strip prefix and suffix
-		matchStr = routingName + "." + matchStr + "." + cdnDomain
-		fmt.Println("DEBUG HTTP DS match literal '" + matchStr + "'")
+		matchStr = strings.ToLower(routingName + "." + matchStr + "." + cdnDomain)
 		return dnsDSMatchLiteral{str: matchStr}, nil
 	} else if rfc.ValidFQDN(matchStr) {
 		// If the match string is a valid FQDN, we assume it's not a regex.
 		// Be aware it could still be a regex, and e.g. 'foo.bar.com' could be actually wanting to match those dots as anything, e.g. match 'fooabar.com'.
 		// But that would be very strange.
-		return dnsDSMatchLiteral{str: matchStr}, nil
+		return dnsDSMatchLiteral{str: strings.ToLower(matchStr)}, nil
 	} else {
 		// TODO error? warn? Do we really want to allow arbitrary regexes?
 		//      We still validate elsewhere that the domain is in the CDN domain, but still.
-		re, err := regexp.Compile(matchStr)
+		re, err := regexp.Compile(caseInsensitive + matchStr)
 		if err != nil {
 			return nil, errors.New("compiling regex: " + err.Error())
 		}
@@ -54,6 +55,9 @@ func NewHTTPDSMatch(matchStr string, routingName string, cdnDomain string) (DNSD
 	}
 }
 
+// caseInsensitive is the regex flag prefix making a regex match case-insensitive, so regexes written with uppercase letters still match lowercase FQDNs.
+const caseInsensitive = "(?i)"
+
 type DNSDSMatch interface {
 	Match(fqdn string) bool
 }
@@ -75,3 +79,13 @@ type dnsDSMatchRegex struct {
 }
 
 func (dm dnsDSMatchRegex) Match(fqdn string) bool { return dm.re.MatchString(fqdn) }
+
+// Literal returns the literal FQDN matched by m, and whether m is a literal match.
+// Regex and contains matches match many FQDNs, and return false.
+func Literal(m DNSDSMatch) (string, bool) {
+	lit, ok := m.(dnsDSMatchLiteral)
+	if !ok {
+		return "", false
+	}
+	return lit.str, true
+}
@@ -0,0 +1,61 @@
+package match
+
+import (
+	"testing"
+)
+
+func TestNewDNSDSMatch(t *testing.T) {
+	tests := []struct {
+		matchStr      string
+		expectLiteral bool
+		matches       []string
+		nonMatches    []string
+	}{
+		{`.*\.ds\..*`, false, []string{"edge.ds.cdn.example.net", "a.ds.b"}, []string{"edge.other.cdn.example.net"}},
+		// a valid FQDN is matched literally, so its dots aren't regex wildcards
+		{"www.ds.cdn.example.net", true, []string{"www.ds.cdn.example.net"}, []string{"wwwxds.cdn.example.net", "a.www.ds.cdn.example.net"}},
+		{`www\.ds\.cdn\.example\.net`, false, []string{"www.ds.cdn.example.net"}, []string{"wwwxds.cdn.example.net"}},
+		{`[a-z]+\.ds\.cdn\.example\.net`, false, []string{"www.ds.cdn.example.net"}, []string{"www1.ds.cdn.example.net"}},
+	}
+	for _, test := range tests {
+		ma, err := NewDNSDSMatch(test.matchStr)
+		if err != nil {
+			t.Errorf("NewDNSDSMatch('%s'): %v", test.matchStr, err)
+			continue
+		}
+		if _, isLiteral := Literal(ma); isLiteral != test.expectLiteral {
+			t.Errorf("NewDNSDSMatch('%s') literal %t, expected %t", test.matchStr, isLiteral, test.expectLiteral)
+		}
+		for _, fqdn := range test.matches {
+			if !ma.Match(fqdn) {
+				t.Errorf("NewDNSDSMatch('%s').Match('%s') = false, expected true", test.matchStr, fqdn)
+			}
+		}
+		for _, fqdn := range test.nonMatches {
+			if ma.Match(fqdn) {
+				t.Errorf("NewDNSDSMatch('%s').Match('%s') = true, expected false", test.matchStr, fqdn)
+			}
+		}
+	}
+}
+
+func TestNewHTTPDSMatch(t *testing.T) {
+	tests := []struct {
+		matchStr string
+		literal  string // literal is the expected literal FQDN, or empty if it should be a regex
+	}{
+		{`.*\.ds\..*`, "ccr.ds.cdn.example.net"},
+		{"video.example.net", "video.example.net"},
+		{`video\..*`, ""},
+	}
+	for _, test := range tests {
+		ma, err := NewHTTPDSMatch(test.matchStr, "ccr", "cdn.example.net")
+		if err != nil {
+			t.Errorf("NewHTTPDSMatch('%s'): %v", test.matchStr, err)
+			continue
+		}
+		if lit, _ := Literal(ma); lit != test.literal {
+			t.Errorf("NewHTTPDSMatch('%s') literal '%s', expected '%s'", test.matchStr, lit, test.literal)
+		}
+	}
+}
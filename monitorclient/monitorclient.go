@@ -0,0 +1,160 @@
+// package monitorclient contains the HTTP client for polling Traffic Monitor, with timeouts, compression, and conditional requests.
+package monitorclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultConnectTimeout is the timeout to connect to a Traffic Monitor, if the Client is created with none.
+const DefaultConnectTimeout = 5 * time.Second
+
+// DefaultTimeout is the timeout of a whole request, including reading the body, if the Client is created with none.
+// The CRConfig may be many megabytes, so this is longer than the connect timeout.
+const DefaultTimeout = 30 * time.Second
+
+// ErrNotModified is returned by Get when the Traffic Monitor responds 304 Not Modified, and the body was not handled.
+var ErrNotModified = errors.New("not modified")
+
+// Client gets data from Traffic Monitors, sending the ETag and Last-Modified of the last successfully handled response of each URL,
+// so unchanged data isn't downloaded, decoded, or processed again.
+//
+// Responses are compressed with gzip if the Traffic Monitor supports it, and transparently decompressed.
+//
+// A Client may safely be used by multiple goroutines.
+//
+type Client struct {
+	// HTTP is the underlying client. It may be replaced before the Client is used, e.g. with an httptest.Server's client.
+	HTTP *http.Client
+
+	validators map[string]validators
+	mutex      sync.Mutex
+}
+
+// validators are the cache validators of a response, for a conditional request.
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// New creates a new Client. If connectTimeout or timeout are 0, DefaultConnectTimeout and DefaultTimeout are used.
+func New(connectTimeout time.Duration, timeout time.Duration) *Client {
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   2,
+		// DisableCompression is false, so the transport requests gzip, and decompresses it.
+	}
+	return &Client{
+		HTTP:       &http.Client{Transport: transport, Timeout: timeout},
+		validators: map[string]validators{},
+	}
+}
+
+// Get requests the path from the Traffic Monitor at the base URL monitorURL, as returned by MonitorURL, and calls handle with the response body.
+//
+// If the Traffic Monitor responds 304 Not Modified, returns ErrNotModified without calling handle.
+// The validators of a response are only kept if handle succeeds, so a response which is rejected by handle is requested and handled again next time.
+//
+func (cl *Client) Get(ctx context.Context, monitorURL string, path string, handle func(body io.Reader) error) error {
+	urlStr := monitorURL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return errors.New("creating request: " + err.Error())
+	}
+
+	cl.mutex.Lock()
+	vals := cl.validators[urlStr]
+	cl.mutex.Unlock()
+	if vals.etag != "" {
+		req.Header.Set("If-None-Match", vals.etag)
+	}
+	if vals.lastModified != "" {
+		req.Header.Set("If-Modified-Since", vals.lastModified)
+	}
+
+	resp, err := cl.HTTP.Do(req)
+	if err != nil {
+		return errors.New("requesting: " + err.Error())
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body) // read the rest, so the connection can be reused
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("bad status: " + resp.Status)
+	}
+	if err := handle(resp.Body); err != nil {
+		return err
+	}
+
+	newVals := validators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	cl.mutex.Lock()
+	cl.validators[urlStr] = newVals
+	cl.mutex.Unlock()
+	return nil
+}
+
+// GetAny calls Get with each of the monitors in turn until one succeeds, starting with the monitor at index *next,
+// and sets *next to the monitor after the last one tried, so successive calls round-robin the monitors.
+//
+// If all the monitors fail, the seed monitors which weren't tried are tried too,
+// so the router can still poll if every monitor in the CRConfig is unreachable.
+// Failures of individual monitors are logged. Returns ErrNotModified if the successful monitor responded 304 Not Modified.
+//
+func (cl *Client) GetAny(ctx context.Context, monitors *Monitors, next *int, path string, handle func(body io.Reader) error) error {
+	urls := monitors.Get()
+	tried := make(map[string]struct{}, len(urls))
+	try := func(monitorURL string) error {
+		tried[monitorURL] = struct{}{}
+		err := cl.Get(ctx, monitorURL, path, handle)
+		if err != nil && err != ErrNotModified && ctx.Err() == nil {
+			fmt.Println("ERROR: monitorclient: getting '" + monitorURL + path + "', trying next monitor: " + err.Error())
+		}
+		return err
+	}
+	done := func(err error) bool {
+		return err == nil || err == ErrNotModified || ctx.Err() != nil
+	}
+
+	for i := 0; i < len(urls); i++ {
+		*next %= len(urls) // the monitors may have changed since the last call
+		monitorURL := urls[*next]
+		*next = (*next + 1) % len(urls)
+		if err := try(monitorURL); done(err) {
+			return err
+		}
+	}
+	for _, monitorURL := range monitors.Seed() {
+		if _, ok := tried[monitorURL]; ok {
+			continue
+		}
+		if err := try(monitorURL); done(err) {
+			return err
+		}
+	}
+	if len(tried) == 0 {
+		return errors.New("no monitors")
+	}
+	return errors.New("all monitors failed")
+}
@@ -0,0 +1,218 @@
+package monitorclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// testMonitor is a Traffic Monitor serving a single body, which records the conditional headers of each request.
+type testMonitor struct {
+	etag         string
+	lastModified string
+	body         string
+
+	mutex    sync.Mutex
+	requests []http.Header
+}
+
+func (tm *testMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tm.mutex.Lock()
+	tm.requests = append(tm.requests, r.Header.Clone())
+	tm.mutex.Unlock()
+	if tm.etag != "" && r.Header.Get("If-None-Match") == tm.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if tm.etag == "" && tm.lastModified != "" && r.Header.Get("If-Modified-Since") == tm.lastModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if tm.etag != "" {
+		w.Header().Set("ETag", tm.etag)
+	}
+	if tm.lastModified != "" {
+		w.Header().Set("Last-Modified", tm.lastModified)
+	}
+	io.WriteString(w, tm.body)
+}
+
+// lastRequest returns the headers of the last request.
+func (tm *testMonitor) lastRequest() http.Header {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return tm.requests[len(tm.requests)-1]
+}
+
+func newTestClient(svr *httptest.Server) *Client {
+	cl := New(0, 0)
+	cl.HTTP = svr.Client()
+	return cl
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name         string
+		etag         string
+		lastModified string
+		// handleErr is returned by the first handle call.
+		handleErr error
+		// expectedHeader and expectedValue are the conditional header expected on the second request, or "" for none.
+		expectedHeader string
+		expectedValue  string
+		expectedSecond error
+	}{
+		{
+			name:           "etag",
+			etag:           `"abc"`,
+			expectedHeader: "If-None-Match",
+			expectedValue:  `"abc"`,
+			expectedSecond: ErrNotModified,
+		},
+		{
+			name:           "last-modified",
+			lastModified:   "Mon, 02 Jan 2006 15:04:05 GMT",
+			expectedHeader: "If-Modified-Since",
+			expectedValue:  "Mon, 02 Jan 2006 15:04:05 GMT",
+			expectedSecond: ErrNotModified,
+		},
+		{
+			name:           "no validators",
+			expectedSecond: nil,
+		},
+		{
+			name:           "handler failure not stored",
+			etag:           `"abc"`,
+			lastModified:   "Mon, 02 Jan 2006 15:04:05 GMT",
+			handleErr:      errors.New("bad body"),
+			expectedSecond: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tm := &testMonitor{etag: test.etag, lastModified: test.lastModified, body: "states"}
+			svr := httptest.NewServer(tm)
+			defer svr.Close()
+			cl := newTestClient(svr)
+
+			handled := []string{}
+			handleErr := test.handleErr
+			handle := func(body io.Reader) error {
+				bts, err := ioutil.ReadAll(body)
+				if err != nil {
+					return err
+				}
+				handled = append(handled, string(bts))
+				err, handleErr = handleErr, nil
+				return err
+			}
+
+			if err := cl.Get(context.Background(), svr.URL, "/publish/CrStates", handle); err != test.handleErr {
+				t.Fatalf("first Get error = %v, expected %v", err, test.handleErr)
+			}
+			if hdr := tm.lastRequest(); hdr.Get("If-None-Match") != "" || hdr.Get("If-Modified-Since") != "" {
+				t.Errorf("first request had conditional headers %+v, expected none", hdr)
+			}
+
+			if err := cl.Get(context.Background(), svr.URL, "/publish/CrStates", handle); err != test.expectedSecond {
+				t.Fatalf("second Get error = %v, expected %v", err, test.expectedSecond)
+			}
+			hdr := tm.lastRequest()
+			for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+				expected := ""
+				if name == test.expectedHeader {
+					expected = test.expectedValue
+				}
+				if got := hdr.Get(name); got != expected {
+					t.Errorf("second request %s = '%s', expected '%s'", name, got, expected)
+				}
+			}
+
+			expectedHandled := 2
+			if test.expectedSecond == ErrNotModified {
+				expectedHandled = 1
+			}
+			if len(handled) != expectedHandled {
+				t.Errorf("handle called %d times, expected %d", len(handled), expectedHandled)
+			}
+			for _, body := range handled {
+				if body != "states" {
+					t.Errorf("handled body '%s', expected 'states'", body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetValidatorsPerURL(t *testing.T) {
+	tm := &testMonitor{etag: `"abc"`, body: "body"}
+	svr := httptest.NewServer(tm)
+	defer svr.Close()
+	cl := newTestClient(svr)
+	handle := func(body io.Reader) error { return nil }
+
+	if err := cl.Get(context.Background(), svr.URL, "/publish/CrStates", handle); err != nil {
+		t.Fatalf("Get CrStates: %v", err)
+	}
+	if err := cl.Get(context.Background(), svr.URL, "/publish/CrConfig", handle); err != nil {
+		t.Errorf("Get CrConfig after CrStates = %v, expected no error, because validators are per URL", err)
+	}
+	if err := cl.Get(context.Background(), svr.URL, "/publish/CrStates", handle); err != ErrNotModified {
+		t.Errorf("Get CrStates again = %v, expected ErrNotModified", err)
+	}
+}
+
+func TestGetBadStatus(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer svr.Close()
+	cl := newTestClient(svr)
+
+	called := false
+	err := cl.Get(context.Background(), svr.URL, "/publish/CrStates", func(body io.Reader) error { called = true; return nil })
+	if err == nil || err == ErrNotModified {
+		t.Errorf("Get error = %v, expected a bad status error", err)
+	}
+	if called {
+		t.Error("handle called for a 503, expected not called")
+	}
+	if len(cl.validators) != 0 {
+		t.Errorf("validators stored for a 503: %+v", cl.validators)
+	}
+}
+
+func TestGetAnyFallsBack(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	tm := &testMonitor{body: "states"}
+	up := httptest.NewServer(tm)
+	defer up.Close()
+
+	cl := newTestClient(up)
+	monitors := NewMonitors([]string{down.URL, up.URL})
+	next := 0
+	got := ""
+	err := cl.GetAny(context.Background(), monitors, &next, "/publish/CrStates", func(body io.Reader) error {
+		bts, err := ioutil.ReadAll(body)
+		got = string(bts)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GetAny: %v", err)
+	}
+	if got != "states" {
+		t.Errorf("handled '%s', expected 'states'", got)
+	}
+	if next != 0 {
+		t.Errorf("next = %d, expected 0, after the second of 2 monitors", next)
+	}
+}
@@ -0,0 +1,163 @@
+package monitorclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// MonitorStatusOnline is the CRConfig status of Traffic Monitors which should be polled.
+const MonitorStatusOnline = "ONLINE"
+
+// Monitors is the set of Traffic Monitor URLs to poll.
+//
+// It's seeded from the config file monitors, and then kept in sync with the ONLINE monitors of each applied CRConfig.
+// If a CRConfig has no ONLINE monitors, the seed monitors are used, so a bad CRConfig can't leave the router with nothing to poll.
+//
+// Monitors may safely be used by multiple goroutines, so the pollers can share one, and it can be updated while they poll.
+//
+type Monitors struct {
+	seed       []string
+	discovered []string
+	// scheme is the scheme of discovered monitors, the scheme of the first seed monitor.
+	scheme string
+	mutex  sync.Mutex
+}
+
+// NewMonitors creates a new Monitors, seeded with the given monitors, which may be FQDNs, FQDN:port, or http or https URLs.
+// Invalid monitors are logged and skipped.
+func NewMonitors(seed []string) *Monitors {
+	mo := &Monitors{}
+	mo.SetSeed(seed)
+	return mo
+}
+
+// SetSeed sets the seed monitors, e.g. on config reload. Invalid monitors are logged and skipped.
+func (mo *Monitors) SetSeed(seed []string) {
+	urls := make([]string, 0, len(seed))
+	for _, monitor := range seed {
+		monitorURL, err := MonitorURL(monitor)
+		if err != nil {
+			fmt.Println("ERROR: monitor '" + monitor + "' invalid, skipping: " + err.Error())
+			continue
+		}
+		urls = append(urls, monitorURL)
+	}
+	scheme := "http"
+	if len(urls) > 0 && strings.HasPrefix(urls[0], "https://") {
+		scheme = "https"
+	}
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+	mo.seed = urls
+	mo.scheme = scheme
+}
+
+// SetFromCRConfig sets the discovered monitors to the ONLINE monitors of the CRConfig.
+//
+// Discovered monitors use the scheme of the seed monitors, with the CRConfig monitor port, or httpsPort for https.
+// Logs if the monitors changed.
+//
+func (mo *Monitors) SetFromCRConfig(crc *tc.CRConfig) {
+	if crc == nil {
+		return
+	}
+	mo.mutex.Lock()
+	scheme := mo.scheme
+	mo.mutex.Unlock()
+
+	urls := []string{}
+	for name, monitor := range crc.Monitors {
+		if monitor.ServerStatus == nil || !strings.EqualFold(string(*monitor.ServerStatus), MonitorStatusOnline) {
+			continue
+		}
+		host := ""
+		if monitor.FQDN != nil && *monitor.FQDN != "" {
+			host = *monitor.FQDN
+		} else if monitor.IP != nil && *monitor.IP != "" {
+			host = *monitor.IP
+		} else if monitor.IP6 != nil && *monitor.IP6 != "" {
+			host = *monitor.IP6
+		} else {
+			fmt.Println("ERROR: CRConfig monitor '" + name + "' has no fqdn or ip, skipping")
+			continue
+		}
+		port := monitor.Port
+		if scheme == "https" {
+			port = monitor.HTTPSPort
+		}
+		urls = append(urls, scheme+"://"+urlHost(host, port))
+	}
+	sort.Strings(urls)
+
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+	if strings.Join(urls, ",") == strings.Join(mo.discovered, ",") {
+		return
+	}
+	mo.discovered = urls
+	if len(urls) == 0 {
+		fmt.Println("ERROR: CRConfig has no ONLINE monitors, polling the config file monitors " + strings.Join(mo.seed, ","))
+		return
+	}
+	fmt.Println("INFO monitors discovered from CRConfig: " + strings.Join(urls, ","))
+}
+
+// Get returns the monitor URLs to poll: the ONLINE monitors of the last CRConfig, or the seed monitors if it had none.
+// The returned slice MUST NOT be modified.
+func (mo *Monitors) Get() []string {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+	if len(mo.discovered) > 0 {
+		return mo.discovered
+	}
+	return mo.seed
+}
+
+// Seed returns the seed monitor URLs. The returned slice MUST NOT be modified.
+func (mo *Monitors) Seed() []string {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+	return mo.seed
+}
+
+// urlHost returns the host of a URL for host and port: host:port if port is positive, otherwise host, with IPv6 addresses in brackets.
+func urlHost(host string, port *int) string {
+	if port != nil && *port > 0 {
+		return net.JoinHostPort(host, strconv.Itoa(*port))
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// MonitorURL returns the base URL of the given monitor, which may be an FQDN or IP, host:port, or an http or https URL.
+// IPv6 addresses with a port must be in brackets, as in URLs, e.g. [2001:db8::1]:80.
+// Monitors without a scheme use http. The URL has no trailing slash, so paths may be appended.
+func MonitorURL(monitor string) (string, error) {
+	if !strings.Contains(monitor, "://") {
+		if ip := net.ParseIP(monitor); ip != nil {
+			monitor = urlHost(monitor, nil)
+		}
+		monitor = "http://" + monitor
+	}
+	monitorURL, err := url.Parse(monitor)
+	if err != nil {
+		return "", errors.New("parsing URL: " + err.Error())
+	}
+	if monitorURL.Scheme != "http" && monitorURL.Scheme != "https" {
+		return "", errors.New("scheme '" + monitorURL.Scheme + "' not http or https")
+	}
+	if monitorURL.Host == "" {
+		return "", errors.New("no host")
+	}
+	return strings.TrimSuffix(monitorURL.String(), "/"), nil
+}
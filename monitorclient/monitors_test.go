@@ -0,0 +1,117 @@
+package monitorclient
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestMonitorURL(t *testing.T) {
+	tests := []struct {
+		monitor   string
+		expected  string
+		expectErr bool
+	}{
+		{"tm.example.net", "http://tm.example.net", false},
+		{"tm.example.net:8080", "http://tm.example.net:8080", false},
+		{"https://tm.example.net/", "https://tm.example.net", false},
+		{"192.0.2.1", "http://192.0.2.1", false},
+		{"2001:db8::1", "http://[2001:db8::1]", false},
+		{"[2001:db8::1]:8080", "http://[2001:db8::1]:8080", false},
+		{"https://[2001:db8::1]:8443", "https://[2001:db8::1]:8443", false},
+		{"ftp://tm.example.net", "", true},
+		{"http://", "", true},
+	}
+	for _, test := range tests {
+		got, err := MonitorURL(test.monitor)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("MonitorURL('%s') = '%s', expected an error", test.monitor, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MonitorURL('%s'): %v", test.monitor, err)
+		} else if got != test.expected {
+			t.Errorf("MonitorURL('%s') = '%s', expected '%s'", test.monitor, got, test.expected)
+		}
+	}
+}
+
+func strPtr(s string) *string { return &s }
+func intPtr(i int) *int       { return &i }
+
+func onlineStatus() *tc.CRConfigServerStatus {
+	status := tc.CRConfigServerStatus(MonitorStatusOnline)
+	return &status
+}
+
+func TestSetFromCRConfigIPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     string
+		monitor  tc.CRConfigMonitor
+		expected string
+	}{
+		{"ip6 without port", "tm.example.net", tc.CRConfigMonitor{IP6: strPtr("2001:db8::1")}, "http://[2001:db8::1]"},
+		{"ip6 with port", "tm.example.net", tc.CRConfigMonitor{IP6: strPtr("2001:db8::1"), Port: intPtr(8080)}, "http://[2001:db8::1]:8080"},
+		{"ip6 with https port", "https://tm.example.net", tc.CRConfigMonitor{IP6: strPtr("2001:db8::1"), Port: intPtr(80), HTTPSPort: intPtr(8443)}, "https://[2001:db8::1]:8443"},
+		{"ip6 in ip", "tm.example.net", tc.CRConfigMonitor{IP: strPtr("2001:db8::1")}, "http://[2001:db8::1]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mo := NewMonitors([]string{test.seed})
+			test.monitor.ServerStatus = onlineStatus()
+			mo.SetFromCRConfig(&tc.CRConfig{Monitors: map[string]tc.CRConfigMonitor{"tm": test.monitor}})
+			if got := mo.Get(); !reflect.DeepEqual(got, []string{test.expected}) {
+				t.Errorf("monitors %v, expected [%s]", got, test.expected)
+			}
+			if _, err := MonitorURL(test.expected); err != nil {
+				t.Errorf("discovered monitor '%s' isn't a valid monitor URL: %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestSetFromCRConfig(t *testing.T) {
+	offline := tc.CRConfigServerStatus("OFFLINE")
+	crc := &tc.CRConfig{Monitors: map[string]tc.CRConfigMonitor{
+		"tm1": {FQDN: strPtr("tm1.example.net"), IP: strPtr("192.0.2.1"), Port: intPtr(80), HTTPSPort: intPtr(443), ServerStatus: onlineStatus()},
+		"tm2": {FQDN: strPtr("tm2.example.net"), Port: intPtr(80), ServerStatus: &offline},
+		"tm3": {IP: strPtr("192.0.2.3"), ServerStatus: onlineStatus()},
+		"tm4": {Port: intPtr(80), ServerStatus: onlineStatus()},
+		"tm5": {FQDN: strPtr("tm5.example.net")},
+	}}
+
+	mo := NewMonitors([]string{"tm-seed.example.net:81", "ftp://invalid.example.net"})
+	seed := []string{"http://tm-seed.example.net:81"}
+	if got := mo.Get(); !reflect.DeepEqual(got, seed) {
+		t.Fatalf("monitors before any CRConfig %v, expected the valid seed monitors %v", got, seed)
+	}
+
+	mo.SetFromCRConfig(crc)
+	expected := []string{"http://192.0.2.3", "http://tm1.example.net:80"}
+	if got := mo.Get(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("monitors %v, expected the ONLINE CRConfig monitors with an address %v", got, expected)
+	}
+	if got := mo.Seed(); !reflect.DeepEqual(got, seed) {
+		t.Errorf("seed %v after discovery, expected it unchanged %v", got, seed)
+	}
+
+	mo.SetFromCRConfig(nil)
+	if got := mo.Get(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("monitors %v after a nil CRConfig, expected them unchanged %v", got, expected)
+	}
+
+	mo.SetFromCRConfig(&tc.CRConfig{Monitors: map[string]tc.CRConfigMonitor{"tm2": crc.Monitors["tm2"]}})
+	if got := mo.Get(); !reflect.DeepEqual(got, seed) {
+		t.Errorf("monitors %v from a CRConfig with no ONLINE monitors, expected the seed monitors %v", got, seed)
+	}
+
+	httpsMo := NewMonitors([]string{"https://tm-seed.example.net"})
+	httpsMo.SetFromCRConfig(crc)
+	if expected := []string{"https://192.0.2.3", "https://tm1.example.net:443"}; !reflect.DeepEqual(httpsMo.Get(), expected) {
+		t.Errorf("monitors %v with an https seed, expected https with the httpsPort %v", httpsMo.Get(), expected)
+	}
+}
@@ -1,7 +1,12 @@
 package poller
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -10,74 +15,192 @@ var ErrAlreadyStarted = errors.New("already started")
 var ErrNoPollInterval = errors.New("no poll interval")
 var ErrNoIPoller = errors.New("no IPoller object")
 
+// DefaultMaxBackoff is the longest the Poller waits between polls after repeated failures, if the Poller has no MaxBackoff.
+const DefaultMaxBackoff = 2 * time.Minute
+
+// DefaultJitter is the fraction of each wait which is randomized, if the Poller has no Jitter.
+const DefaultJitter = 0.1
+
 type IPoller interface {
-	Poll()
+	// Poll polls once, returning any error. The ctx is canceled when the Poller is stopped, and Poll should return promptly when it is.
+	// Poll MUST NOT call Stop or Restart on its Poller, which wait for Poll to return, and would deadlock.
+	Poll(ctx context.Context) error
+	// Reset is called before the Poller starts, to reset any state from previous polling, e.g. after a config reload.
 	Reset()
 }
 
 type iPoller struct {
-	f func()
+	f func(ctx context.Context) error
 }
 
-func (ip *iPoller) Poll() {
-	ip.f()
+func (ip *iPoller) Poll(ctx context.Context) error {
+	return ip.f(ctx)
 }
 
 func (ip *iPoller) Reset() {}
 
 // MakeIPoller takes a poll func and returns an IPoller object
-func MakeIPoller(f func()) IPoller {
+func MakeIPoller(f func(ctx context.Context) error) IPoller {
 	return &iPoller{f: f}
 }
 
+// Result is the outcome of a single poll.
+type Result struct {
+	// Err is the error returned by the poll, or nil if it succeeded.
+	Err error
+	// Failures is the number of consecutive failed polls, including this one. It's 0 if the poll succeeded.
+	Failures int
+	// Duration is how long the poll took.
+	Duration time.Duration
+	// Next is how long the Poller will wait before the next poll, including backoff and jitter.
+	Next time.Duration
+}
+
+// Poller calls IPoller.Poll every Interval, until it's stopped.
+//
+// After consecutive failures, the wait doubles each time, up to MaxBackoff, and returns to Interval after a success.
+// Each wait is randomized by Jitter, so many Traffic Routers started together don't all poll Traffic Monitor at the same time.
+//
+// The exported fields MUST NOT be changed while the Poller is started. To change them, Stop, change, and Start again.
+//
 type Poller struct {
 	Interval time.Duration
 	IPoller  IPoller
+	// MaxBackoff is the longest wait between polls after repeated failures. If 0, DefaultMaxBackoff is used.
+	// If it's less than Interval, failures don't back off.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each wait to randomize it by, e.g. 0.1 waits between 90% and 110%. If 0, DefaultJitter is used. If negative, there is no jitter.
+	Jitter float64
+	// OnPoll, if not nil, is called with the Result of every poll, from the polling goroutine.
+	// Like Poll, it MUST NOT call Stop or Restart on this Poller, which would deadlock.
+	OnPoll func(Result)
 
-	stopChan chan struct{}
-	started  bool
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
+// Start starts polling in a new goroutine. The first poll is after one Interval.
+// Returns ErrAlreadyStarted if the Poller is already started.
 func (po *Poller) Start() error {
-	if po.Interval == 0 {
+	po.mutex.Lock()
+	defer po.mutex.Unlock()
+	if po.Interval <= 0 {
 		return ErrNoPollInterval
 	}
 	if po.IPoller == nil {
 		return ErrNoIPoller
 	}
-	if po.started {
+	if po.cancel != nil {
 		return ErrAlreadyStarted
 	}
-	if po.stopChan == nil {
-		po.stopChan = make(chan struct{})
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	po.cancel = cancel
+	po.done = make(chan struct{})
 	po.IPoller.Reset()
-	go poll(po)
-	po.started = true
+	go po.poll(ctx, po.done)
 	return nil
 }
 
+// Stop stops polling, canceling any poll in progress, and waits for the polling goroutine to finish.
+// After Stop returns, the Poller's fields may be changed, and it may be started again.
+// Returns ErrNotStarted if the Poller isn't started.
+//
+// Stop MUST NOT be called from the polling goroutine, i.e. from IPoller.Poll or OnPoll, because it waits for that goroutine to finish, and would deadlock.
+//
 func (po *Poller) Stop() error {
-	if !po.started {
+	po.mutex.Lock()
+	defer po.mutex.Unlock()
+	if po.cancel == nil {
 		return ErrNotStarted
 	}
-	<-po.stopChan
-	po.started = false
+	po.cancel()
+	<-po.done
+	po.cancel = nil
+	po.done = nil
 	return nil
 }
 
-func poll(po *Poller) {
-	timer := time.NewTimer(po.Interval)
+// Restart stops the Poller if it's started, and starts it again.
+func (po *Poller) Restart() error {
+	if err := po.Stop(); err != nil && err != ErrNotStarted {
+		return err
+	}
+	return po.Start()
+}
+
+func (po *Poller) poll(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	// the fields may not change while started, but copy them anyway, so this goroutine never reads the Poller after Stop returns.
+	interval, iPoller, onPoll := po.Interval, po.IPoller, po.OnPoll
+	maxBackoff := po.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	jitter := po.Jitter
+	if jitter == 0 {
+		jitter = DefaultJitter
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	failures := 0
+	timer := time.NewTimer(withJitter(interval, jitter, rnd))
+	defer timer.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-timer.C:
-			po.IPoller.Poll()
-			timer.Reset(po.Interval)
-		case <-po.stopChan:
-			if !timer.Stop() {
-				<-timer.C
-			}
+		}
+
+		start := time.Now()
+		err := iPoller.Poll(ctx)
+		if ctx.Err() != nil {
+			return // stopped mid-poll, the error is the cancel, not a failure
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		next := withJitter(backoff(interval, maxBackoff, failures), jitter, rnd)
+		if onPoll != nil {
+			onPoll(Result{Err: err, Failures: failures, Duration: time.Since(start), Next: next})
+		}
+		timer.Reset(next)
+	}
+}
+
+// backoff returns the wait after the given number of consecutive failures: interval doubled for each failure, up to maxBackoff.
+// The wait is never less than interval.
+func backoff(interval time.Duration, maxBackoff time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 0; i < failures && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff && maxBackoff > interval {
+		wait = maxBackoff
+	}
+	if wait < interval {
+		wait = interval
+	}
+	return wait
+}
+
+// withJitter returns d randomized by up to the fraction jitter in either direction. If jitter is negative, returns d.
+func withJitter(d time.Duration, jitter float64, rnd *rand.Rand) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	return d + time.Duration(float64(d)*jitter*(2*rnd.Float64()-1))
+}
+
+// MakeResultLogger returns an OnPoll func which logs failed polls, prefixed with name.
+func MakeResultLogger(name string) func(Result) {
+	return func(result Result) {
+		if result.Err == nil {
 			return
 		}
+		fmt.Println("ERROR: CRITICAL! " + name + ": poll failed " + strconv.Itoa(result.Failures) + " times in a row, trying again in " + result.Next.String() + ": " + result.Err.Error())
 	}
 }
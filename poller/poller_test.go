@@ -0,0 +1,257 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIPoller counts its polls and resets, and fails the polls for which fail returns true.
+type testIPoller struct {
+	mutex  sync.Mutex
+	polls  int
+	resets int
+	fail   func(poll int) bool
+	block  bool
+}
+
+func (ip *testIPoller) Poll(ctx context.Context) error {
+	ip.mutex.Lock()
+	ip.polls++
+	poll := ip.polls
+	block := ip.block
+	ip.mutex.Unlock()
+	if block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if ip.fail != nil && ip.fail(poll) {
+		return errors.New("poll failed")
+	}
+	return nil
+}
+
+func (ip *testIPoller) Reset() {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	ip.resets++
+}
+
+func (ip *testIPoller) counts() (int, int) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+	return ip.polls, ip.resets
+}
+
+// resultChan returns an OnPoll func which sends each Result to the returned chan, without blocking the Poller if it's full.
+func resultChan() (func(Result), chan Result) {
+	results := make(chan Result, 100)
+	return func(result Result) {
+		select {
+		case results <- result:
+		default:
+		}
+	}, results
+}
+
+func waitResult(t *testing.T, results chan Result) Result {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a poll")
+	}
+	return Result{}
+}
+
+func TestStartErrors(t *testing.T) {
+	if err := (&Poller{IPoller: &testIPoller{}}).Start(); err != ErrNoPollInterval {
+		t.Errorf("Start with no Interval returned '%v', expected '%v'", err, ErrNoPollInterval)
+	}
+	if err := (&Poller{Interval: time.Millisecond}).Start(); err != ErrNoIPoller {
+		t.Errorf("Start with no IPoller returned '%v', expected '%v'", err, ErrNoIPoller)
+	}
+	if err := (&Poller{Interval: time.Millisecond, IPoller: &testIPoller{}}).Stop(); err != ErrNotStarted {
+		t.Errorf("Stop before Start returned '%v', expected '%v'", err, ErrNotStarted)
+	}
+}
+
+func TestStartStopRestart(t *testing.T) {
+	ip := &testIPoller{}
+	onPoll, results := resultChan()
+	po := &Poller{Interval: time.Millisecond, IPoller: ip, OnPoll: onPoll}
+	if err := po.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := po.Start(); err != ErrAlreadyStarted {
+		t.Errorf("second Start returned '%v', expected '%v'", err, ErrAlreadyStarted)
+	}
+	for i := 0; i < 3; i++ {
+		waitResult(t, results)
+	}
+	if err := po.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	stoppedPolls, resets := ip.counts()
+	if resets != 1 {
+		t.Errorf("Reset called %d times after one Start, expected 1", resets)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if polls, _ := ip.counts(); polls != stoppedPolls {
+		t.Errorf("polled %d times after Stop returned, expected none", polls-stoppedPolls)
+	}
+	if err := po.Stop(); err != ErrNotStarted {
+		t.Errorf("second Stop returned '%v', expected '%v'", err, ErrNotStarted)
+	}
+
+	if err := po.Restart(); err != nil {
+		t.Fatalf("Restart stopped: %v", err)
+	}
+	drain(results)
+	waitResult(t, results)
+	if err := po.Restart(); err != nil {
+		t.Fatalf("Restart started: %v", err)
+	}
+	drain(results)
+	waitResult(t, results)
+	if err := po.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if _, resets := ip.counts(); resets != 3 {
+		t.Errorf("Reset called %d times after three Starts, expected 3", resets)
+	}
+}
+
+func drain(results chan Result) {
+	for {
+		select {
+		case <-results:
+		default:
+			return
+		}
+	}
+}
+
+func TestStopCancelsPoll(t *testing.T) {
+	ip := &testIPoller{block: true}
+	onPoll, results := resultChan()
+	po := &Poller{Interval: time.Millisecond, IPoller: ip, OnPoll: onPoll}
+	if err := po.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for polls := 0; polls == 0; polls, _ = ip.counts() {
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan error)
+	go func() { stopped <- po.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop didn't cancel the poll in progress")
+	}
+	if len(results) != 0 {
+		t.Error("OnPoll called for a poll canceled by Stop, expected it not to be")
+	}
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	ip := &testIPoller{fail: func(int) bool { return true }}
+	onPoll, results := resultChan()
+	po := &Poller{Interval: 50 * time.Millisecond, MaxBackoff: time.Hour, Jitter: -1, IPoller: ip, OnPoll: onPoll}
+	if err := po.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for result := waitResult(t, results); result.Next < 400*time.Millisecond; result = waitResult(t, results) {
+	}
+	start := time.Now()
+	if err := po.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Stop during a %v backoff took %v, expected it to return without waiting", 400*time.Millisecond, elapsed)
+	}
+}
+
+func TestOnPollBackoff(t *testing.T) {
+	// fail the first two polls, then succeed, then fail again
+	ip := &testIPoller{fail: func(poll int) bool { return poll != 3 }}
+	onPoll, results := resultChan()
+	interval := time.Millisecond
+	po := &Poller{Interval: interval, Jitter: -1, IPoller: ip, OnPoll: onPoll}
+	if err := po.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	expected := []struct {
+		failed   bool
+		failures int
+		next     time.Duration
+	}{
+		{true, 1, 2 * interval},
+		{true, 2, 4 * interval},
+		{false, 0, interval},
+		{true, 1, 2 * interval},
+	}
+	for i, ex := range expected {
+		result := waitResult(t, results)
+		if (result.Err != nil) != ex.failed || result.Failures != ex.failures || result.Next != ex.next {
+			t.Errorf("poll %d: err '%v' failures %d next %v, expected failed %v failures %d next %v", i+1, result.Err, result.Failures, result.Next, ex.failed, ex.failures, ex.next)
+		}
+	}
+	if err := po.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		interval   time.Duration
+		maxBackoff time.Duration
+		failures   int
+		expected   time.Duration
+	}{
+		{"no failures", time.Second, time.Minute, 0, time.Second},
+		{"one failure", time.Second, time.Minute, 1, 2 * time.Second},
+		{"three failures", time.Second, time.Minute, 3, 8 * time.Second},
+		{"capped", time.Second, time.Minute, 10, time.Minute},
+		{"max less than interval", time.Minute, time.Second, 3, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := backoff(test.interval, test.maxBackoff, test.failures); got != test.expected {
+				t.Errorf("backoff = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	d := 10 * time.Second
+	if got := withJitter(d, -1, rnd); got != d {
+		t.Errorf("withJitter(negative) = %v, expected %v", got, d)
+	}
+	min, max := d, d
+	for i := 0; i < 1000; i++ {
+		got := withJitter(d, 0.1, rnd)
+		if got < 9*time.Second || got > 11*time.Second {
+			t.Fatalf("withJitter(0.1) = %v, expected within 10%% of %v", got, d)
+		}
+		if got < min {
+			min = got
+		}
+		if got > max {
+			max = got
+		}
+	}
+	if min > 9500*time.Millisecond || max < 10500*time.Millisecond {
+		t.Errorf("withJitter(0.1) ranged %v to %v over 1000 waits, expected it randomized in both directions", min, max)
+	}
+}
@@ -1,35 +1,55 @@
 package pollercrconfig
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfig"
+	"github.com/rob05c/traffic_router/guard"
+	"github.com/rob05c/traffic_router/monitorclient"
 	"github.com/rob05c/traffic_router/poller"
 	"github.com/rob05c/traffic_router/shared"
 )
 
-func MakePoller(interval time.Duration, monitors []string, shared *shared.Shared) (*poller.Poller, *IPoller) {
+// MakePoller creates a CRConfig poller. The swap func is called with each new snapshot built from a polled CRConfig, and must atomically start serving it.
+// The onPoll func, if not nil, is called with the result of every poll.
+func MakePoller(interval time.Duration, monitors *monitorclient.Monitors, client *monitorclient.Client, stateDir string, sharedPtr *shared.Ptr, crcGuard *guard.Guard, swap func(*shared.Shared), onPoll func(poller.Result)) (*poller.Poller, *IPoller) {
 	// TODO make interval part of shared, for threadsafe updating
 	iPoller := &IPoller{
-		Monitors: monitors,
-		Shared:   shared,
+		Monitors:  monitors,
+		Client:    client,
+		StateDir:  stateDir,
+		SharedPtr: sharedPtr,
+		Guard:     crcGuard,
+		Swap:      swap,
 	}
 	poller := &poller.Poller{
 		Interval: interval,
 		IPoller:  iPoller,
+		OnPoll:   onPoll,
 	}
 	return poller, iPoller
 }
 
-// Poller polls Monitors every Interval, and updates the CRStates.
+// Poller polls Monitors every Interval, and rebuilds the routing snapshot from the CRConfig.
 type IPoller struct {
-	// monitors is the array of Traffic Monitor FQDNs to poll
-	// TODO make Monitors part of Shared, so it can be set/get in a threadsafe manner
-	Monitors []string // TODO change to URL?
-	Shared   *shared.Shared
+	// Monitors is the Traffic Monitors to poll, which may be updated while the Poller is started.
+	Monitors *monitorclient.Monitors
+	// Client is the Traffic Monitor HTTP client. It MUST NOT be changed while the Poller is started.
+	Client *monitorclient.Client
+	// StateDir is the directory to persist each successfully applied CRConfig to. If empty, it isn't persisted.
+	StateDir  string
+	SharedPtr *shared.Ptr
+	// Guard refuses CRConfigs which catastrophically change routing.
+	Guard *guard.Guard
+	// Swap is called with the new Shared built from each valid polled CRConfig, and must atomically start serving it.
+	Swap func(*shared.Shared)
 
 	currentMonitor int
 }
@@ -38,36 +58,54 @@ func (po *IPoller) Reset() {
 	po.currentMonitor = 0
 }
 
-func (po *IPoller) Poll() {
-	if len(po.Monitors) == 0 {
-		fmt.Println("ERROR: CRITICAL! pollercrstates: no monitors! Cannot poll!")
-		return
+// Poll gets the CRConfig from the next monitor, trying each monitor in turn until one succeeds, as monitorclient.Client.GetAny.
+// If the CRConfig hasn't changed since it was last successfully polled, nothing is decoded or updated.
+// Returns an error if all monitors fail.
+func (po *IPoller) Poll(ctx context.Context) error {
+	err := po.Client.GetAny(ctx, po.Monitors, &po.currentMonitor, "/publish/CrConfig", po.handle)
+	if err == monitorclient.ErrNotModified {
+		return nil
 	}
+	return err
+}
 
-	triedMonitors := 0
+// handle decodes the CRConfig, builds a new routing snapshot from it, swaps it in, and persists it.
+// Returns an error if the CRConfig is invalid or refused by the guard, in which case the old snapshot continues to be served.
+func (po *IPoller) handle(body io.Reader) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return errors.New("reading: " + err.Error())
+	}
 	crConfig := &tc.CRConfig{}
-	for {
-		if triedMonitors == len(po.Monitors) {
-			fmt.Println("ERROR: CRITICAL! pollercrconfig: all monitors failed, CRConfig Poll failed! Trying again after interval.")
-		}
-
-		monitorFQDN := po.Monitors[po.currentMonitor]
-		urlStr := "http://" + monitorFQDN + "/publish/CrConfig"
-		resp, err := http.Get(urlStr) // TODO use client, add timeouts
-		po.currentMonitor = (po.currentMonitor + 1) % len(po.Monitors)
-		triedMonitors++
-		if err != nil {
-			fmt.Println("ERROR: pollercrconfig: getting CRConfig from monitor '" + monitorFQDN + "', trying next monitor: " + err.Error())
-			continue
-		}
-		defer resp.Body.Close()
+	if err := json.Unmarshal(data, crConfig); err != nil {
+		return errors.New("decoding: " + err.Error())
+	}
+	oldShared := po.SharedPtr.Get()
+	overridden, err := po.Guard.Check(oldShared.GetCRConfig(), crConfig)
+	if err != nil {
+		return err
+	}
+	newShared, err := oldShared.NewWithCRConfig(crConfig)
+	if err != nil {
+		return errors.New("building routing snapshot, rejecting and continuing to serve the old one: " + err.Error())
+	}
+	po.Swap(newShared)
+	if overridden {
+		po.Guard.UseOverride() // only once it's served, so a CRConfig which fails to build doesn't waste the override
+	}
+	po.Monitors.SetFromCRConfig(crConfig)
+	fmt.Println("INFO pollercrconfig: swapped in new routing snapshot from polled CRConfig")
+	po.persist(data)
+	return nil
+}
 
-		if err := json.NewDecoder(resp.Body).Decode(crConfig); err != nil {
-			fmt.Println("ERROR: pollercrstates: decoding CRConfig from monitor '" + monitorFQDN + "', trying next monitor: " + err.Error())
-			resp.Body.Close() // optimization
-			continue
-		}
-		break
+// persist writes the applied CRConfig to the state directory, so it's loaded on restart if Traffic Monitor is unreachable.
+// Errors are logged, not returned: the CRConfig has been applied, and failing to persist it shouldn't fail the poll.
+func (po *IPoller) persist(data []byte) {
+	if po.StateDir == "" {
+		return
+	}
+	if err := crconfig.WriteStateFile(po.StateDir, crconfig.StateCRConfigFileName, data); err != nil {
+		fmt.Println("ERROR: pollercrconfig: persisting CRConfig to state dir '" + po.StateDir + "': " + err.Error())
 	}
-	po.Shared.SetCRConfig(crConfig)
 }
@@ -0,0 +1,167 @@
+package pollercrconfig
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfig"
+	"github.com/rob05c/traffic_router/guard"
+	"github.com/rob05c/traffic_router/monitorclient"
+	"github.com/rob05c/traffic_router/shared"
+)
+
+// testMonitor is a Traffic Monitor serving body as its CRConfig.
+type testMonitor struct {
+	mutex sync.Mutex
+	body  string
+}
+
+func (tm *testMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	w.Write([]byte(tm.body))
+}
+
+func (tm *testMonitor) set(body string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.body = body
+}
+
+// loadTestCRConfig returns the shared package test CRConfig, for the CDN domain cdn.example.net.
+func loadTestCRConfig(t *testing.T) string {
+	t.Helper()
+	bts, err := ioutil.ReadFile("../shared/testdata/crconfig.json")
+	if err != nil {
+		t.Fatalf("reading test CRConfig: %v", err)
+	}
+	return string(bts)
+}
+
+// newTestPoller returns an IPoller of a Traffic Monitor serving the test CRConfig, persisting to stateDir,
+// and serving a Shared built from the test CRConfig. The returned func returns the number of Shareds swapped in.
+func newTestPoller(t *testing.T, stateDir string) (*IPoller, *testMonitor, func() int) {
+	t.Helper()
+	tm := &testMonitor{body: loadTestCRConfig(t)}
+	svr := httptest.NewServer(tm)
+	t.Cleanup(svr.Close)
+
+	crc := &tc.CRConfig{}
+	if err := json.Unmarshal([]byte(tm.body), crc); err != nil {
+		t.Fatalf("decoding test CRConfig: %v", err)
+	}
+	sh := shared.NewShared(nil, nil, nil, crc, &tc.CRStates{}, nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	ptr := shared.NewPtr(sh)
+	swapsMutex := sync.Mutex{}
+	swaps := 0
+	swap := func(newShared *shared.Shared) {
+		swapsMutex.Lock()
+		defer swapsMutex.Unlock()
+		swaps++
+		ptr.Set(newShared)
+	}
+	getSwaps := func() int {
+		swapsMutex.Lock()
+		defer swapsMutex.Unlock()
+		return swaps
+	}
+	_, ip := MakePoller(time.Second, monitorclient.NewMonitors([]string{svr.URL}), monitorclient.New(0, 0), stateDir, ptr, guard.New(0), swap, nil)
+	return ip, tm, getSwaps
+}
+
+func TestPollPersistsState(t *testing.T) {
+	stateDir := t.TempDir()
+	statePath := filepath.Join(stateDir, crconfig.StateCRConfigFileName)
+	ip, tm, swaps := newTestPoller(t, stateDir)
+
+	changed := strings.Replace(loadTestCRConfig(t), "1600000000", "1600000100", 1)
+	tm.set(changed)
+	if err := ip.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if swaps() != 1 {
+		t.Fatalf("%d snapshots swapped in, expected 1", swaps())
+	}
+	if bts, err := ioutil.ReadFile(statePath); err != nil || string(bts) != changed {
+		t.Fatalf("state file err %v, expected the applied CRConfig", err)
+	}
+
+	tm.set(`{"config": {"domain_name": "cdn.example.net"}}`)
+	if err := ip.Poll(context.Background()); err == nil {
+		t.Fatal("Poll of an invalid CRConfig succeeded, expected an error")
+	}
+	if bts, err := ioutil.ReadFile(statePath); err != nil || string(bts) != changed {
+		t.Errorf("state file err %v after an invalid CRConfig, expected the last applied CRConfig", err)
+	}
+
+	noState, tm, _ := newTestPoller(t, "")
+	tm.set(changed)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := noState.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll without a state dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(wd, crconfig.StateCRConfigFileName)); !os.IsNotExist(err) {
+		t.Errorf("Poll without a state dir wrote a state file, expected none")
+	}
+}
+
+func TestPollGuardOverride(t *testing.T) {
+	ip, tm, swaps := newTestPoller(t, "")
+	original := loadTestCRConfig(t)
+	otherDomain := strings.Replace(original, "cdn.example.net", "cdn.other.net", -1)
+
+	tm.set(otherDomain)
+	if err := ip.Poll(context.Background()); err == nil || swaps() != 0 {
+		t.Fatalf("Poll of a CRConfig changing the domain returned err %v and swapped %d, expected it refused", err, swaps())
+	}
+
+	// an overridden CRConfig which can't be built doesn't use up the override
+	ip.Guard.Override()
+	overrides := guard.Overrides.Value()
+	invalid := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(otherDomain), &invalid); err != nil {
+		t.Fatal(err)
+	}
+	delete(invalid, "contentServers")
+	invalidBts, err := json.Marshal(invalid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.set(string(invalidBts))
+	if err := ip.Poll(context.Background()); err == nil || swaps() != 0 {
+		t.Fatalf("Poll of an overridden CRConfig which can't be built returned err %v and swapped %d, expected an error", err, swaps())
+	}
+	if guard.Overrides.Value() != overrides {
+		t.Errorf("overrides metric moved for an overridden CRConfig which wasn't applied, expected it unchanged")
+	}
+
+	tm.set(otherDomain)
+	if err := ip.Poll(context.Background()); err != nil || swaps() != 1 {
+		t.Fatalf("Poll of an overridden CRConfig returned err %v and swapped %d, expected it applied", err, swaps())
+	}
+	if guard.Overrides.Value() != overrides+1 {
+		t.Errorf("overrides metric moved %d for an applied overridden CRConfig, expected 1", guard.Overrides.Value()-overrides)
+	}
+
+	// the override is used up, so changing the domain back is refused
+	tm.set(original)
+	if err := ip.Poll(context.Background()); err == nil || swaps() != 1 {
+		t.Errorf("Poll after the override was used returned err %v and swapped %d, expected it refused", err, swaps())
+	}
+}
@@ -1,35 +1,48 @@
 package pollercrstates
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfig"
+	"github.com/rob05c/traffic_router/monitorclient"
 	"github.com/rob05c/traffic_router/poller"
 	"github.com/rob05c/traffic_router/shared"
 )
 
-func MakePoller(interval time.Duration, monitors []string, shared *shared.Shared) (*poller.Poller, *IPoller) {
+// MakePoller creates a CRStates poller. The onPoll func, if not nil, is called with the result of every poll.
+func MakePoller(interval time.Duration, monitors *monitorclient.Monitors, client *monitorclient.Client, stateDir string, sharedPtr *shared.Ptr, onPoll func(poller.Result)) (*poller.Poller, *IPoller) {
 	// TODO make interval part of shared, for threadsafe updating
 	iPoller := &IPoller{
-		Monitors: monitors,
-		Shared:   shared,
+		Monitors:  monitors,
+		Client:    client,
+		StateDir:  stateDir,
+		SharedPtr: sharedPtr,
 	}
 	poller := &poller.Poller{
 		Interval: interval,
 		IPoller:  iPoller,
+		OnPoll:   onPoll,
 	}
 	return poller, iPoller
 }
 
 // Poller polls Monitors every Interval, and updates the CRStates.
 type IPoller struct {
-	// monitors is the array of Traffic Monitor FQDNs to poll
-	// TODO make Monitors part of Shared, so it can be set/get in a threadsafe manner
-	Monitors []string // TODO change to URL?
-	Shared   *shared.Shared
+	// Monitors is the Traffic Monitors to poll, which may be updated while the Poller is started.
+	Monitors *monitorclient.Monitors
+	// Client is the Traffic Monitor HTTP client. It MUST NOT be changed while the Poller is started.
+	Client *monitorclient.Client
+	// StateDir is the directory to persist each successfully applied CRStates to. If empty, it isn't persisted.
+	StateDir string
+	// SharedPtr is the Shared currently being served. CRStates are set on whichever Shared is current, and are shared with the snapshots built from it, so they aren't lost when a new snapshot is swapped in.
+	SharedPtr *shared.Ptr
 
 	currentMonitor int
 }
@@ -38,36 +51,39 @@ func (po *IPoller) Reset() {
 	po.currentMonitor = 0
 }
 
-func (po *IPoller) Poll() {
-	if len(po.Monitors) == 0 {
-		fmt.Println("ERROR: CRITICAL! pollercrstates: no monitors! Cannot poll!")
-		return
+// Poll gets the CRStates from the next monitor, trying each monitor in turn until one succeeds, as monitorclient.Client.GetAny.
+// If the CRStates hasn't changed since it was last successfully polled, nothing is decoded or updated.
+// Returns an error if all monitors fail.
+func (po *IPoller) Poll(ctx context.Context) error {
+	err := po.Client.GetAny(ctx, po.Monitors, &po.currentMonitor, "/publish/CrStates", po.handle)
+	if err == monitorclient.ErrNotModified {
+		return nil
 	}
+	return err
+}
 
-	triedMonitors := 0
+// handle decodes the CRStates, sets them on the current Shared, and persists them.
+func (po *IPoller) handle(body io.Reader) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return errors.New("reading: " + err.Error())
+	}
 	crStates := &tc.CRStates{}
-	for {
-		if triedMonitors == len(po.Monitors) {
-			fmt.Println("ERROR: CRITICAL! pollercrstates: all monitors failed, CRStates Poll failed! Trying again after interval.")
-		}
-
-		monitorFQDN := po.Monitors[po.currentMonitor]
-		urlStr := "http://" + monitorFQDN + "/publish/CrStates"
-		resp, err := http.Get(urlStr) // TODO use client, add timeouts
-		po.currentMonitor = (po.currentMonitor + 1) % len(po.Monitors)
-		triedMonitors++
-		if err != nil {
-			fmt.Println("ERROR: pollercrstates: getting CRStates from monitor '" + monitorFQDN + "', trying next monitor: " + err.Error())
-			continue
-		}
-		defer resp.Body.Close()
+	if err := json.Unmarshal(data, crStates); err != nil {
+		return errors.New("decoding: " + err.Error())
+	}
+	po.SharedPtr.Get().SetCRStates(crStates)
+	po.persist(data)
+	return nil
+}
 
-		if err := json.NewDecoder(resp.Body).Decode(crStates); err != nil {
-			fmt.Println("ERROR: pollercrstates: decoding CRStates from monitor '" + monitorFQDN + "', trying next monitor: " + err.Error())
-			resp.Body.Close() // optimization
-			continue
-		}
-		break
+// persist writes the applied CRStates to the state directory, so it's loaded on restart if Traffic Monitor is unreachable.
+// Errors are logged, not returned: the CRStates has been applied, and failing to persist it shouldn't fail the poll.
+func (po *IPoller) persist(data []byte) {
+	if po.StateDir == "" {
+		return
+	}
+	if err := crconfig.WriteStateFile(po.StateDir, crconfig.StateCRStatesFileName, data); err != nil {
+		fmt.Println("ERROR: pollercrstates: persisting CRStates to state dir '" + po.StateDir + "': " + err.Error())
 	}
-	po.Shared.SetCRStates(crStates)
 }
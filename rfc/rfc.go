@@ -5,6 +5,9 @@ const HdrLocation = "Location"
 // ValidFQDN returns whether str is a valid RFC1035§2.3.1 Fully Qualified Domain Name.
 func ValidFQDN(str string) bool {
 	// TODO move to lib/go-rfc
+	if str == "" {
+		return false
+	}
 	newLabel := true
 	prevCh := 'a' // arbitrary previous char which is valid to begin a label.
 	for _, ch := range str {
@@ -12,9 +15,9 @@ func ValidFQDN(str string) bool {
 			(ch >= 'A' && ch <= 'Z') ||
 			(ch >= '0' && ch <= '9' && !newLabel) || // labels cannot begin with numbers
 			(ch == '-' && !newLabel) || // labels cannot begin with hyphens
-			(ch == '.' && prevCh != '-') { // labels cannot end with hyphens
-			prevCh = '-'
-			newLabel = false
+			(ch == '.' && !newLabel && prevCh != '-') { // labels cannot end with hyphens, or be empty
+			prevCh = ch
+			newLabel = ch == '.'
 			continue
 		}
 		return false
@@ -0,0 +1,37 @@
+package rfc
+
+import (
+	"testing"
+)
+
+func TestValidFQDN(t *testing.T) {
+	tests := []struct {
+		fqdn     string
+		expected bool
+	}{
+		{"example", true},
+		{"edge.ds.cdn.example.net", true},
+		{"edge.ds.cdn.example.net.", true},
+		{"EDGE.ds.CDN.example.net", true},
+		{"edge-1.ds-name.cdn.example.net", true},
+		{"a1.b2.c3", true},
+		{"", false},
+		{".", false},
+		{"edge..cdn.example.net", false},
+		{".edge.cdn.example.net", false},
+		{"edge.cdn.example.net..", false},
+		{"-edge.cdn.example.net", false},
+		{"edge.-cdn.example.net", false},
+		{"edge-.cdn.example.net", false},
+		{"edge.cdn.example.net-", false},
+		{"1edge.cdn.example.net", false},
+		{"edge_1.cdn.example.net", false},
+		{`.*\.ds\..*`, false},
+		{`edge\.ds\.cdn\.example\.net`, false},
+	}
+	for _, test := range tests {
+		if got := ValidFQDN(test.fqdn); got != test.expected {
+			t.Errorf("ValidFQDN('%s') = %t, expected %t", test.fqdn, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// Availability is the health of caches and Delivery Services, per a Traffic Monitor CRStates.
+//
+// An Availability is immutable after creation, and may safely be used by multiple goroutines.
+// A new one is built each time the CRStates are set, so polled health takes caches out of rotation without rebuilding the routing tables.
+//
+type Availability struct {
+	// caches is whether each cache is available. Caches not in the CRStates are unavailable.
+	caches map[tc.CacheName]bool
+	// unavailableDSes is the set of Delivery Services Traffic Monitor has marked unavailable.
+	unavailableDSes map[tc.DeliveryServiceName]struct{}
+	// disabledLocations is the set of cachegroups Traffic Monitor has disabled for each Delivery Service.
+	disabledLocations map[tc.DeliveryServiceName]map[tc.CacheGroupName]struct{}
+}
+
+// BuildAvailabilityFromCRStates builds the Availability of the given CRStates. If crs is nil, no cache is available.
+func BuildAvailabilityFromCRStates(crs *tc.CRStates) *Availability {
+	av := &Availability{
+		caches:            map[tc.CacheName]bool{},
+		unavailableDSes:   map[tc.DeliveryServiceName]struct{}{},
+		disabledLocations: map[tc.DeliveryServiceName]map[tc.CacheGroupName]struct{}{},
+	}
+	if crs == nil {
+		return av
+	}
+	for cacheName, isAvail := range crs.Caches {
+		av.caches[cacheName] = isAvail.IsAvailable
+	}
+	for dsName, dsState := range crs.DeliveryService {
+		if !dsState.IsAvailable {
+			av.unavailableDSes[dsName] = struct{}{}
+		}
+		if len(dsState.DisabledLocations) == 0 {
+			continue
+		}
+		disabled := make(map[tc.CacheGroupName]struct{}, len(dsState.DisabledLocations))
+		for _, cg := range dsState.DisabledLocations {
+			disabled[cg] = struct{}{}
+		}
+		av.disabledLocations[dsName] = disabled
+	}
+	return av
+}
+
+// CacheAvailable returns whether the given cache is available.
+func (av *Availability) CacheAvailable(cache tc.CacheName) bool {
+	return av.caches[cache]
+}
+
+// DSAvailable returns whether the given Delivery Service is available.
+// Delivery Services missing from the CRStates are available, because Traffic Monitor may not have seen a new DS yet.
+func (av *Availability) DSAvailable(ds tc.DeliveryServiceName) bool {
+	_, unavailable := av.unavailableDSes[ds]
+	return !unavailable
+}
+
+// DisabledLocations returns the set of cachegroups disabled for the given Delivery Service, or nil if none are.
+// The returned map MUST NOT be modified.
+func (av *Availability) DisabledLocations(ds tc.DeliveryServiceName) map[tc.CacheGroupName]struct{} {
+	return av.disabledLocations[ds]
+}
+
+// GetAvailability returns the Availability of the current CRStates.
+//
+// Handlers should get it once per request, so the whole request is routed with the same health.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetAvailability() *Availability {
+	return sh.getHealth().availability
+}
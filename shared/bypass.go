@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/rfc"
+)
+
+// BypassDestinationDNS is the CRConfig deliveryServices bypassDestination key of the DNS bypass.
+const BypassDestinationDNS = "DNS"
+
+// DNSBypass is where to send DNS Delivery Service clients when no cache is available, typically the origin.
+// At least one of IP, IP6, and CNAME is set.
+type DNSBypass struct {
+	IP  net.IP
+	IP6 net.IP
+	// CNAME is the bypass FQDN, without the trailing period. It's returned for requests of address types the bypass has no IP for.
+	CNAME string
+	// TTL is the TTL of bypass answers. If the CRConfig bypass has no ttl, it's the DS A or AAAA ttl.
+	TTL *uint32
+}
+
+// BuildDNSBypass returns the DNS bypass destination of the Delivery Service, or nil if it doesn't have one.
+func BuildDNSBypass(ds tc.CRConfigDeliveryService) (*DNSBypass, error) {
+	crcBypass := ds.BypassDestination[BypassDestinationDNS]
+	if crcBypass == nil {
+		return nil, nil
+	}
+	bypass := &DNSBypass{}
+	if crcBypass.IP != nil && *crcBypass.IP != "" {
+		ip := net.ParseIP(*crcBypass.IP)
+		if ip == nil || ip.To4() == nil {
+			return nil, errors.New("ip '" + *crcBypass.IP + "' not valid IPv4")
+		}
+		bypass.IP = ip.To4()
+	}
+	if crcBypass.IP6 != nil && *crcBypass.IP6 != "" {
+		ip := net.ParseIP(*crcBypass.IP6)
+		if ip == nil || ip.To4() != nil {
+			return nil, errors.New("ip6 '" + *crcBypass.IP6 + "' not valid IPv6")
+		}
+		bypass.IP6 = ip
+	}
+	if crcBypass.CName != nil && *crcBypass.CName != "" {
+		bypass.CNAME = strings.TrimSuffix(*crcBypass.CName, ".")
+		if !rfc.ValidFQDN(bypass.CNAME) {
+			return nil, errors.New("cname '" + *crcBypass.CName + "' not a valid FQDN")
+		}
+	}
+	if crcBypass.TTL != nil {
+		if *crcBypass.TTL < 0 || int64(*crcBypass.TTL) > int64(^uint32(0)) {
+			return nil, errors.New("ttl " + strconv.Itoa(*crcBypass.TTL) + " out of range")
+		}
+		ttl := uint32(*crcBypass.TTL)
+		bypass.TTL = &ttl
+	}
+	if bypass.IP == nil && bypass.IP6 == nil && bypass.CNAME == "" {
+		return nil, errors.New("no ip, ip6, or cname")
+	}
+	return bypass, nil
+}
+
+// GetDNSBypass returns the DNS bypass destination of the given Delivery Service, or nil if it doesn't have one.
+// The returned object MUST NOT be modified.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetDNSBypass(dsName tc.DeliveryServiceName) *DNSBypass {
+	return sh.dsConfigs[dsName].DNSBypass
+}
@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"net"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestBuildDNSBypass(t *testing.T) {
+	str := func(s string) *string { return &s }
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name      string
+		bypass    *tc.CRConfigBypassDestination
+		expectErr bool
+		expected  *DNSBypass
+	}{
+		{"none", nil, false, nil},
+		{"ip", &tc.CRConfigBypassDestination{IP: str("198.51.100.1")}, false, &DNSBypass{IP: net.ParseIP("198.51.100.1").To4()}},
+		{"ip6", &tc.CRConfigBypassDestination{IP6: str("2001:db8::1")}, false, &DNSBypass{IP6: net.ParseIP("2001:db8::1")}},
+		{"cname without trailing period", &tc.CRConfigBypassDestination{CName: str("origin.example.org.")}, false, &DNSBypass{CNAME: "origin.example.org"}},
+		{"ttl", &tc.CRConfigBypassDestination{IP: str("198.51.100.1"), TTL: intPtr(15)}, false, &DNSBypass{IP: net.ParseIP("198.51.100.1").To4(), TTL: func() *uint32 { ttl := uint32(15); return &ttl }()}},
+		{"empty", &tc.CRConfigBypassDestination{IP: str("")}, true, nil},
+		{"malformed ip", &tc.CRConfigBypassDestination{IP: str("198.51.100")}, true, nil},
+		{"ipv6 ip", &tc.CRConfigBypassDestination{IP: str("2001:db8::1")}, true, nil},
+		{"ipv4 ip6", &tc.CRConfigBypassDestination{IP6: str("198.51.100.1")}, true, nil},
+		{"malformed cname", &tc.CRConfigBypassDestination{CName: str("origin..example.org")}, true, nil},
+		{"negative ttl", &tc.CRConfigBypassDestination{IP: str("198.51.100.1"), TTL: intPtr(-1)}, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := tc.CRConfigDeliveryService{}
+			if test.bypass != nil {
+				ds.BypassDestination = map[string]*tc.CRConfigBypassDestination{BypassDestinationDNS: test.bypass}
+			}
+			bypass, err := BuildDNSBypass(ds)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("BuildDNSBypass = %+v, expected an error", bypass)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildDNSBypass: %v", err)
+			}
+			if (bypass == nil) != (test.expected == nil) {
+				t.Fatalf("BuildDNSBypass = %+v, expected %+v", bypass, test.expected)
+			}
+			if bypass == nil {
+				return
+			}
+			if !bypass.IP.Equal(test.expected.IP) || !bypass.IP6.Equal(test.expected.IP6) || bypass.CNAME != test.expected.CNAME || (bypass.TTL == nil) != (test.expected.TTL == nil) || (bypass.TTL != nil && *bypass.TTL != *test.expected.TTL) {
+				t.Errorf("BuildDNSBypass = %+v, expected %+v", bypass, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rob05c/traffic_router/chash"
+)
+
+// ringCache contains hash rings by the servers on them.
+//
+// Most Delivery Services in a cachegroup are assigned to the same servers, so their rings are identical.
+// Sharing them keeps memory proportional to the number of distinct server sets, rather than the number of Delivery Services.
+//
+type ringCache map[string]*chash.Ring
+
+// addRings returns servers with V4Ring and V6Ring built, from the cache if an identical ring was already built.
+// The server lists are sorted by hostname, so a list's rings are the same regardless of CRConfig order.
+func (rc ringCache) addRings(servers DNSDSServers) DNSDSServers {
+	sortServers(servers.V4s)
+	sortServers(servers.V6s)
+	servers.V4Ring = rc.getRing(servers.V4s)
+	servers.V6Ring = rc.getRing(servers.V6s)
+	return servers
+}
+
+func (rc ringCache) getRing(servers []DNSDSServer) *chash.Ring {
+	keyParts := make([]string, 0, len(servers))
+	for _, sv := range servers {
+		keyParts = append(keyParts, sv.HashID+" "+strconv.Itoa(sv.HashCount))
+	}
+	key := strings.Join(keyParts, "\n")
+	if ring, ok := rc[key]; ok {
+		return ring
+	}
+	ringServers := make([]chash.Server, 0, len(servers))
+	for _, sv := range servers {
+		ringServers = append(ringServers, chash.Server{HashID: sv.HashID, HashCount: sv.HashCount})
+	}
+	ring := chash.NewRing(ringServers)
+	rc[key] = ring
+	return ring
+}
+
+func sortServers(servers []DNSDSServer) {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].HostName < servers[j].HostName })
+}
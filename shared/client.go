@@ -0,0 +1,92 @@
+package shared
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Client is the client of a DNS or HTTP request, for routing.
+//
+// Localizing a client, finding its coverage zone, is the most expensive part of routing, so it's done lazily.
+// Handlers create a Client with only the request addresses, and requests are routed as a pipeline:
+// the name is validated, then matched to a Delivery Service, and only then, if the DS routes by location, is the client localized and a server selected.
+// Requests which are refused, don't exist, or don't depend on the client's location, such as random subdomain floods, never localize the client.
+//
+// A Client is for a single request, and is not safe for use by multiple goroutines.
+//
+type Client struct {
+	// Addr is the address the request came from.
+	Addr net.Addr
+	// IP is the IP of Addr. If nil, the client is in no zone, and can't be geolocated.
+	IP net.IP
+	// ECSIP is the EDNS Client Subnet address of the request, masked to ECSSourcePrefixLen, or nil if it has none.
+	// It's only used for DNS Delivery Services with ECS enabled.
+	ECSIP net.IP
+	// ECSSourcePrefixLen is the ECS source prefix length of the request.
+	ECSSourcePrefixLen int
+
+	loc      *ClientLocation // loc is the location of IP, once it's been localized.
+	ecsLoc   *ClientLocation // ecsLoc is the location of ECSIP, once it's been localized.
+	ecsScope int
+}
+
+// ClientLocation is the location of a Client.
+type ClientLocation struct {
+	// IP is the address which was localized, the ECS address or the request address. It's geolocated if it isn't in the coverage zone file.
+	IP net.IP
+	// Zone is the coverage zone, which is the cachegroup, or "" if IP isn't in the coverage zone file.
+	Zone string
+	// Scope is the prefix length of the subnet of IP in which every address is in Zone, as czf.ParsedCZF.GetZoneAndScope.
+	// If IP is nil, it's 0.
+	Scope int
+}
+
+// ECSScope returns the EDNS Client Subnet scope prefix length to respond with, per RFC 7871§7.2.1.
+//
+// If the client was localized by its ECS address, this is the scope of its coverage zone, or of its Deep CZF zone if that's longer,
+// so the answer is never cached for a network carved out of the client's, in either file.
+// If it's in no coverage zone, it's geolocated, which depends on the whole address, so it's at least the source prefix length.
+// Otherwise, it's 0, because the answer didn't depend on the client subnet.
+//
+func (cl *Client) ECSScope() int {
+	return cl.ecsScope
+}
+
+// localize returns the location of the client, looking it up in the coverage zone file if it hasn't been already.
+// If useECS and the client has an ECS address, it's localized by that, otherwise by the request address.
+func (sh *Shared) localize(cl *Client, useECS bool) *ClientLocation {
+	if !useECS || cl.ECSIP == nil {
+		if cl.loc == nil {
+			cl.loc = sh.localizeIP(cl.IP)
+		}
+		return cl.loc
+	}
+	if cl.ecsLoc != nil {
+		return cl.ecsLoc
+	}
+	cl.ecsLoc = sh.localizeIP(cl.ECSIP)
+
+	// if no zone matched, the client is geolocated, so the answer is only valid for the whole source subnet.
+	cl.ecsScope = cl.ECSSourcePrefixLen
+	if cl.ecsLoc.Zone != "" {
+		cl.ecsScope = cl.ecsLoc.Scope
+	}
+	if sh.deepCZF != nil {
+		// deep caching DSes are routed by the Deep CZF first, so the answer may only be valid for a narrower subnet, whether or not it's in a deep zone.
+		if _, deepScope := sh.deepCZF.GetZoneAndScope(cl.ECSIP); deepScope > cl.ecsScope {
+			cl.ecsScope = deepScope
+		}
+	}
+	fmt.Println("EVENT: Request: " + cl.Addr.String() + " ECS " + cl.ECSIP.String() + "/" + strconv.Itoa(cl.ECSSourcePrefixLen) + " czf zone '" + cl.ecsLoc.Zone + "' scope " + strconv.Itoa(cl.ecsScope))
+	return cl.ecsLoc
+}
+
+// localizeIP returns the location of ip in the coverage zone file. If ip is nil, it's in no zone.
+func (sh *Shared) localizeIP(ip net.IP) *ClientLocation {
+	if ip == nil {
+		return &ClientLocation{}
+	}
+	zone, scope := sh.czf.GetZoneAndScope(ip)
+	return &ClientLocation{IP: ip, Zone: zone, Scope: scope}
+}
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLocalizeLazily(t *testing.T) {
+	sh := loadTestShared(t)
+	tests := []struct {
+		domain         string
+		v4             bool
+		expectLocalize bool
+	}{
+		{"edge.dnsds.cdn.example.net.", true, true},
+		{"edge.dnsds.cdn.example.net", true, false}, // no trailing period, refused
+		{"example.org.", true, false},
+		{"nope.cdn.example.net.", true, false},
+		{"cdn.example.net.", true, false},
+		{"ccr.httpds.cdn.example.net.", true, false}, // routers aren't chosen by location
+		{"tr01.cdn.example.net.", true, false},
+	}
+	for _, test := range tests {
+		cl := &Client{Addr: testAddr{}, IP: net.ParseIP("10.0.0.5"), ECSIP: net.ParseIP("10.9.1.0"), ECSSourcePrefixLen: 24}
+		sh.GetServerForDomain(cl, test.domain, test.v4)
+		if localized := cl.loc != nil || cl.ecsLoc != nil; localized != test.expectLocalize {
+			t.Errorf("GetServerForDomain('%s') localized the client %t, expected %t", test.domain, localized, test.expectLocalize)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	sh := loadTestShared(t)
+	cl := &Client{Addr: testAddr{}, IP: net.ParseIP("10.0.0.5"), ECSIP: net.ParseIP("10.9.1.0"), ECSSourcePrefixLen: 24}
+
+	loc := sh.localize(cl, false)
+	if loc.Zone != "cg-east" || !loc.IP.Equal(cl.IP) {
+		t.Errorf("localize without ECS = '%s' %v, expected the request address in cg-east", loc.Zone, loc.IP)
+	}
+	if cl.ECSScope() != 0 {
+		t.Errorf("ECS scope %d when ECS wasn't used, expected 0", cl.ECSScope())
+	}
+	if again := sh.localize(cl, false); again != loc {
+		t.Error("localizing the client again looked it up again, expected the first location")
+	}
+
+	ecsLoc := sh.localize(cl, true)
+	if ecsLoc.Zone != "cg-west" || !ecsLoc.IP.Equal(cl.ECSIP) {
+		t.Errorf("localize with ECS = '%s' %v, expected the ECS address in cg-west", ecsLoc.Zone, ecsLoc.IP)
+	}
+	if cl.ECSScope() != 16 {
+		t.Errorf("ECS scope %d, expected the cg-west network 10.9.0.0/16", cl.ECSScope())
+	}
+
+	// an ECS address in no coverage zone is geolocated, so the answer depends on the whole source subnet
+	outside := &Client{Addr: testAddr{}, IP: net.ParseIP("10.0.0.5"), ECSIP: net.ParseIP("192.0.2.0"), ECSSourcePrefixLen: 24}
+	if loc := sh.localize(outside, true); loc.Zone != "" {
+		t.Errorf("localize with an ECS address outside the czf = '%s', expected no zone", loc.Zone)
+	}
+	if outside.ECSScope() != 24 {
+		t.Errorf("ECS scope %d outside the czf, expected the source prefix length 24", outside.ECSScope())
+	}
+
+	// without an ECS address, the request address is used even if ECS is
+	noECS := &Client{Addr: testAddr{}, IP: net.ParseIP("10.0.0.5")}
+	if loc := sh.localize(noECS, true); loc.Zone != "cg-east" {
+		t.Errorf("localize with ECS but no ECS address = '%s', expected the request address zone cg-east", loc.Zone)
+	}
+}
@@ -0,0 +1,91 @@
+package shared
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/deepczf"
+)
+
+// DeepServers is the servers of a Deep CZF zone, with the cachegroup of each, so caches in cachegroups disabled for the DS aren't routed to.
+type DeepServers struct {
+	DNSDSServers
+	CacheGroups map[tc.CacheName]tc.CacheGroupName
+}
+
+// BuildDeepServers returns map[ds][deepZone]servers, the servers of each Deep CZF zone, for the Delivery Services with deep caching.
+//
+// A zone's servers are the caches it lists which are assigned to the DS, from dsServers, so deep caches are selected like any other:
+// by availability, the DS disabledLocations, and consistent hash.
+// Zones with none of the DS' servers are omitted. If deepCZF is nil, returns an empty map.
+//
+func BuildDeepServers(deepCZF *deepczf.ParsedDeepCZF, dsConfigs map[tc.DeliveryServiceName]DSConfig, dsServers map[tc.DeliveryServiceName]map[tc.CacheGroupName]DNSDSServers) map[tc.DeliveryServiceName]map[string]DeepServers {
+	deepServers := map[tc.DeliveryServiceName]map[string]DeepServers{}
+	if deepCZF == nil {
+		return deepServers
+	}
+	rings := ringCache{}
+	for dsName, dsCfg := range dsConfigs {
+		if !dsCfg.DeepCaching {
+			continue
+		}
+		for zone, caches := range deepCZF.Caches {
+			zoneCaches := map[tc.CacheName]struct{}{}
+			for _, cache := range caches {
+				zoneCaches[cache] = struct{}{}
+			}
+			zoneServers := DNSDSServers{}
+			cacheGroups := map[tc.CacheName]tc.CacheGroupName{}
+			for cg, cgServers := range dsServers[dsName] {
+				for _, sv := range cgServers.V4s {
+					if _, ok := zoneCaches[sv.HostName]; ok {
+						zoneServers.V4s = append(zoneServers.V4s, sv)
+						cacheGroups[sv.HostName] = cg
+					}
+				}
+				for _, sv := range cgServers.V6s {
+					if _, ok := zoneCaches[sv.HostName]; ok {
+						zoneServers.V6s = append(zoneServers.V6s, sv)
+						cacheGroups[sv.HostName] = cg
+					}
+				}
+			}
+			if len(zoneServers.V4s) == 0 && len(zoneServers.V6s) == 0 {
+				continue
+			}
+			if deepServers[dsName] == nil {
+				deepServers[dsName] = map[string]DeepServers{}
+			}
+			deepServers[dsName][zone] = DeepServers{DNSDSServers: rings.addRings(zoneServers), CacheGroups: cacheGroups}
+		}
+	}
+	return deepServers
+}
+
+// getDeepServers returns up to max available servers of the client's Deep CZF zone, and the zone, if the DS has deep caching.
+// Caches in cachegroups in the DS disabledLocations are unavailable, as they are outside the Deep CZF.
+// If the DS doesn't have deep caching, the client isn't in a deep zone, or none of the zone's caches are available, returns no servers,
+// and the client is routed by the coverage zone file as usual.
+func (sh *Shared) getDeepServers(avail *Availability, dsName tc.DeliveryServiceName, clientIP net.IP, v4 bool, max int, hashKey string) ([]DNSDSServer, string) {
+	if clientIP == nil || sh.deepCZF == nil || !sh.dsConfigs[dsName].DeepCaching {
+		return nil, ""
+	}
+	zone := sh.deepCZF.GetZone(clientIP)
+	if zone == "" {
+		return nil, ""
+	}
+	zoneServers := sh.deepServers[dsName][zone]
+	disabled := avail.DisabledLocations(dsName)
+	servers := getServersWhere(zoneServers.DNSDSServers, v4, max, hashKey, func(cache tc.CacheName) bool {
+		if _, ok := disabled[zoneServers.CacheGroups[cache]]; ok {
+			return false
+		}
+		return avail.CacheAvailable(cache)
+	})
+	if len(servers) == 0 {
+		fmt.Println("EVENT: client '" + clientIP.String() + "' ds '" + string(dsName) + "' deep zone '" + zone + "' has no available caches, falling back to the czf")
+		return nil, ""
+	}
+	return servers, zone
+}
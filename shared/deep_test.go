@@ -0,0 +1,114 @@
+package shared
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+	"github.com/rob05c/traffic_router/deepczf"
+)
+
+func czfZone(network string) czf.CZFCoverageZone {
+	return czf.CZFCoverageZone{Network: []string{network}}
+}
+
+// loadTestDeepShared builds the test Shared with a Deep CZF zone 'deep1', of 10.5.0.0/16, listing edge2 and edge4.
+// The test httpds has deep caching, and of the zone's caches only edge2, in cg-east, is assigned to it.
+func loadTestDeepShared(t *testing.T) *Shared {
+	t.Helper()
+	sh := loadTestShared(t)
+	deepCZF, err := deepczf.Parse(&deepczf.DeepCZF{DeepCoverageZones: map[string]deepczf.DeepCoverageZone{
+		"deep1": {CZFCoverageZone: czfZone("10.5.0.0/16"), Caches: []string{"edge2", "edge4"}},
+		"deep2": {CZFCoverageZone: czfZone("10.6.0.0/16"), Caches: []string{"edge-missing"}},
+	}})
+	if err != nil {
+		t.Fatalf("parsing test Deep CZF: %v", err)
+	}
+	sh, err = newShared(sh.czf, deepCZF, nil, sh.GetCRConfig(), sh.health, nil, nil)
+	if err != nil {
+		t.Fatalf("building test Shared with Deep CZF: %v", err)
+	}
+	return sh
+}
+
+func TestBuildDeepServers(t *testing.T) {
+	sh := loadTestDeepShared(t)
+	if len(sh.deepServers) != 1 {
+		t.Fatalf("deep servers for %d DSes, expected only the deep caching httpds", len(sh.deepServers))
+	}
+	zones := sh.deepServers["httpds"]
+	if _, ok := zones["deep2"]; ok {
+		t.Error("deep zone with none of the DS' caches has servers, expected it omitted")
+	}
+	deep1, ok := zones["deep1"]
+	if !ok {
+		t.Fatal("deep zone 'deep1' has no servers, expected edge2")
+	}
+	if len(deep1.V4s) != 1 || deep1.V4s[0].HostName != "edge2" || len(deep1.V6s) != 0 {
+		t.Errorf("deep1 servers %+v %+v, expected only edge2 IPv4", deep1.V4s, deep1.V6s)
+	}
+	if expected := map[tc.CacheName]tc.CacheGroupName{"edge2": "cg-east"}; !reflect.DeepEqual(deep1.CacheGroups, expected) {
+		t.Errorf("deep1 cachegroups %v, expected %v", deep1.CacheGroups, expected)
+	}
+	if deep := BuildDeepServers(nil, sh.dsConfigs, sh.dsServers); len(deep) != 0 {
+		t.Errorf("BuildDeepServers with no Deep CZF returned %v, expected none", deep)
+	}
+}
+
+func TestGetServersWithFallbackDeep(t *testing.T) {
+	deepClient := net.ParseIP("10.5.0.9")
+	tests := []struct {
+		name             string
+		dsName           tc.DeliveryServiceName
+		clientIP         net.IP
+		crStates         func(crs *tc.CRStates)
+		expectedServers  []tc.CacheName
+		expectedLocation tc.CacheGroupName
+	}{
+		{"deep cache", "httpds", deepClient, nil, []tc.CacheName{"edge2"}, "deep1"},
+		{"not in a deep zone", "httpds", net.ParseIP("10.0.0.9"), nil, []tc.CacheName{"edge1", "edge2"}, "cg-east"},
+		{"ds without deep caching", "dnsds", deepClient, nil, []tc.CacheName{"edge1", "edge2", "edge3"}, "cg-east"},
+		{"deep cache unavailable", "httpds", deepClient, func(crs *tc.CRStates) {
+			crs.Caches["edge2"] = tc.IsAvailable{IsAvailable: false}
+		}, []tc.CacheName{"edge1"}, "cg-east"},
+		{"deep cache's cachegroup disabled", "httpds", deepClient, func(crs *tc.CRStates) {
+			crs.DeliveryService["httpds"] = tc.CRStatesDeliveryService{IsAvailable: true, DisabledLocations: []tc.CacheGroupName{"cg-east"}}
+		}, nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sh := loadTestDeepShared(t)
+			crs := allAvailable(sh.GetCRConfig())
+			if test.crStates != nil {
+				test.crStates(crs)
+			}
+			sh.SetCRStates(crs)
+			servers, location, _ := sh.getServersWithFallback(sh.GetAvailability(), test.dsName, "cg-east", test.clientIP, true, 0, "/path")
+			names := map[tc.CacheName]struct{}{}
+			for _, sv := range servers {
+				names[sv.HostName] = struct{}{}
+			}
+			expected := map[tc.CacheName]struct{}{}
+			for _, name := range test.expectedServers {
+				expected[name] = struct{}{}
+			}
+			if !reflect.DeepEqual(names, expected) || location != test.expectedLocation {
+				t.Errorf("servers %v in '%s', expected %v in '%s'", names, location, expected, test.expectedLocation)
+			}
+		})
+	}
+}
+
+func TestLocalizeECSScopeDeep(t *testing.T) {
+	sh := loadTestDeepShared(t)
+	// 10.5.1.0/24 is in cg-east, 10.0.0.0/8 less cg-west's 10.9.0.0/16, and in the deep zone 10.5.0.0/16, the narrower.
+	cl := &Client{Addr: testAddr{}, IP: net.ParseIP("10.0.0.5"), ECSIP: net.ParseIP("10.5.1.0"), ECSSourcePrefixLen: 24}
+	if loc := sh.localize(cl, true); loc.Zone != "cg-east" {
+		t.Errorf("localize = '%s', expected the czf zone cg-east", loc.Zone)
+	}
+	if cl.ECSScope() != 16 {
+		t.Errorf("ECS scope %d, expected the deep zone's 16, which is narrower than the czf zone's", cl.ECSScope())
+	}
+}
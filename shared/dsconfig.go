@@ -0,0 +1,159 @@
+package shared
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// Defaults for the CRConfig config/ttls address values, used when neither the Delivery Service nor the CRConfig config have them.
+const DefaultATTL = 60
+const DefaultAAAATTL = 60
+
+// DSConfig is the routing configuration of a Delivery Service, built from its CRConfig entry when the CRConfig is loaded,
+// so handlers don't need to parse the CRConfig in the request path.
+type DSConfig struct {
+	// MaxDNSIPs is the maximum number of cache addresses to return to a DNS request. If 0, all available caches are returned.
+	MaxDNSIPs int
+	// ECSEnabled is whether to geolocate DNS requests with the EDNS Client Subnet, if present.
+	ECSEnabled bool
+	// TTLs are the TTLs of the records served for the Delivery Service.
+	TTLs TTLs
+	// DNSBypass is where to send clients when no cache is available. If nil, clients get a SERVFAIL.
+	DNSBypass *DNSBypass
+	// ConsistentHashRegex is applied to HTTP request paths before hashing. If nil, the whole path is hashed.
+	ConsistentHashRegex *regexp.Regexp
+	// ConsistentHashQueryParams is the set of HTTP query parameters included in the hash. Others are ignored.
+	ConsistentHashQueryParams map[string]struct{}
+	// GeoProvider is the name of the geolocation provider for clients not in the coverage zone file, one of the geo.Provider constants.
+	GeoProvider string
+	// DeepCaching is whether the DS deepCachingType is ALWAYS, routing clients in the Deep CZF to their deep caches.
+	DeepCaching bool
+}
+
+// TTLs are the TTLs of the records served for a Delivery Service's names.
+type TTLs struct {
+	A    uint32
+	AAAA uint32
+	// SOA is the TTL of the SOA in negative answers for the Delivery Service's names, and hence how long resolvers cache them.
+	SOA uint32
+	// NS is the TTL of the CDN domain NS RRset in the authority section of answers for the Delivery Service's names.
+	// NS requests are only answered at the CDN domain apex, which isn't part of any Delivery Service, and use the CRConfig config/ttls NS.
+	NS uint32
+}
+
+// Addr returns the A TTL if v4, else the AAAA TTL.
+func (ttls TTLs) Addr(v4 bool) uint32 {
+	if v4 {
+		return ttls.A
+	}
+	return ttls.AAAA
+}
+
+// BuildDefaultTTLsFromCRConfig returns the TTLs from the CRConfig config/ttls, used for names which aren't in a Delivery Service,
+// and for Delivery Services without their own ttls.
+func BuildDefaultTTLsFromCRConfig(crc *tc.CRConfig) TTLs {
+	return TTLs{
+		A:    configTTL(crc, "A", DefaultATTL),
+		AAAA: configTTL(crc, "AAAA", DefaultAAAATTL),
+		SOA:  configTTL(crc, "SOA", DefaultSOATTL),
+		NS:   configTTL(crc, "NS", DefaultNSTTL),
+	}
+}
+
+// BuildDSTTLs returns the Delivery Service's ttls, with missing or malformed values taken from defaults.
+func BuildDSTTLs(ds tc.CRConfigDeliveryService, defaults TTLs) TTLs {
+	if ds.TTLs == nil {
+		return defaults
+	}
+	return TTLs{
+		A:    dsTTL(ds.TTLs.ASeconds, defaults.A),
+		AAAA: dsTTL(ds.TTLs.AAAASeconds, defaults.AAAA),
+		SOA:  dsTTL(ds.TTLs.SOASeconds, defaults.SOA),
+		NS:   dsTTL(ds.TTLs.NSSeconds, defaults.NS),
+	}
+}
+
+// dsTTL returns the Delivery Service ttls value, or def if it's missing or malformed.
+func dsTTL(val *string, def uint32) uint32 {
+	if val == nil {
+		return def
+	}
+	num, err := strconv.ParseUint(*val, 10, 32)
+	if err != nil {
+		return def
+	}
+	return uint32(num)
+}
+
+// BuildDSConfigsFromCRConfig builds the DSConfig of every Delivery Service in the CRConfig.
+// Delivery Services without ttls get the defaults.
+//
+// Returns any errors from malformed Delivery Service settings. The malformed settings are omitted, but the Delivery Service is still served.
+//
+func BuildDSConfigsFromCRConfig(crc *tc.CRConfig, defaultTTLs TTLs) (map[tc.DeliveryServiceName]DSConfig, error) {
+	errStrs := []string{}
+	dsConfigs := map[tc.DeliveryServiceName]DSConfig{}
+	for dsName, ds := range crc.DeliveryServices {
+		cfg := DSConfig{ECSEnabled: ds.EcsEnabled, TTLs: BuildDSTTLs(ds, defaultTTLs)}
+		if ds.GeoLocationProvider != nil {
+			cfg.GeoProvider = *ds.GeoLocationProvider
+		}
+		cfg.DeepCaching = ds.DeepCachingType != nil && *ds.DeepCachingType == tc.DeepCachingTypeAlways
+		if ds.MaxDNSIPsForLocation != nil && *ds.MaxDNSIPsForLocation > 0 {
+			cfg.MaxDNSIPs = *ds.MaxDNSIPsForLocation
+		}
+		dnsBypass, err := BuildDNSBypass(ds)
+		if err != nil {
+			errStrs = append(errStrs, "CRConfig ds '"+dsName+"' DNS bypassDestination: "+err.Error()+", skipping bypass!")
+		}
+		cfg.DNSBypass = dnsBypass
+		consistentHashRegex, err := BuildConsistentHashRegex(ds)
+		if err != nil {
+			errStrs = append(errStrs, "CRConfig ds '"+dsName+"' consistentHashRegex: "+err.Error()+", hashing whole paths!")
+		}
+		cfg.ConsistentHashRegex = consistentHashRegex
+		cfg.ConsistentHashQueryParams = BuildConsistentHashQueryParams(ds)
+		dsConfigs[tc.DeliveryServiceName(dsName)] = cfg
+	}
+	err := error(nil)
+	if len(errStrs) > 0 {
+		err = errors.New(strings.Join(errStrs, "\n"))
+	}
+	return dsConfigs, err
+}
+
+// GetTTLs returns the TTLs of the given Delivery Service's records.
+// If dsName is empty or not a Delivery Service, returns the CRConfig config/ttls defaults.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetTTLs(dsName tc.DeliveryServiceName) TTLs {
+	if dsCfg, ok := sh.dsConfigs[dsName]; ok {
+		return dsCfg.TTLs
+	}
+	return sh.defaultTTLs
+}
+
+// GetNameTTLs returns the TTLs of the Delivery Service matching domain, which may have a trailing period,
+// for the records which aren't the DS's own addresses: the SOA of negative answers, and the NS of the authority section.
+// If domain doesn't match a Delivery Service, returns the CRConfig config/ttls defaults.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetNameTTLs(domain string) TTLs {
+	domain = strings.TrimSuffix(domain, ".")
+	if secondMatch, ok := sh.httpSecondDNSMatches[domain]; ok {
+		return sh.GetTTLs(secondMatch.DSName)
+	}
+	if dsName, ok := sh.dnsMatches.Match(domain); ok {
+		return sh.GetTTLs(dsName)
+	}
+	if dsName, ok := sh.httpDNSMatches.Match(domain); ok {
+		return sh.GetTTLs(dsName)
+	}
+	return sh.defaultTTLs
+}
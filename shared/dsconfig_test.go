@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestBuildDSTTLs(t *testing.T) {
+	str := func(s string) *string { return &s }
+	defaults := TTLs{A: 60, AAAA: 61, SOA: 86400, NS: 3600}
+	tests := []struct {
+		name     string
+		ttls     *tc.CRConfigTTL
+		expected TTLs
+	}{
+		{"no ttls", nil, defaults},
+		{"all ttls", &tc.CRConfigTTL{ASeconds: str("30"), AAAASeconds: str("31"), SOASeconds: str("10"), NSSeconds: str("120")}, TTLs{A: 30, AAAA: 31, SOA: 10, NS: 120}},
+		{"some ttls", &tc.CRConfigTTL{AAAASeconds: str("31"), NSSeconds: str("120")}, TTLs{A: 60, AAAA: 31, SOA: 86400, NS: 120}},
+		{"malformed", &tc.CRConfigTTL{ASeconds: str("soon"), NSSeconds: str("-1")}, defaults},
+		{"out of range", &tc.CRConfigTTL{SOASeconds: str("4294967296")}, defaults},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BuildDSTTLs(tc.CRConfigDeliveryService{TTLs: test.ttls}, defaults); got != test.expected {
+				t.Errorf("BuildDSTTLs = %+v, expected %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetTTLs(t *testing.T) {
+	sh := loadTestShared(t)
+	tests := []struct {
+		name     string
+		ttls     TTLs
+		expected TTLs
+	}{
+		{"dnsds", sh.GetTTLs("dnsds"), TTLs{A: 30, AAAA: 30, SOA: 10, NS: 3600}},
+		{"not a ds", sh.GetTTLs("nope"), TTLs{A: 3600, AAAA: 3600, SOA: 86400, NS: 3600}},
+		{"dnsds name", sh.GetNameTTLs("edge.dnsds.cdn.example.net."), TTLs{A: 30, AAAA: 30, SOA: 10, NS: 3600}},
+		{"router name", sh.GetNameTTLs("tr01.cdn.example.net."), TTLs{A: 3600, AAAA: 3600, SOA: 86400, NS: 3600}},
+	}
+	for _, test := range tests {
+		if test.ttls != test.expected {
+			t.Errorf("%s TTLs %+v, expected %+v", test.name, test.ttls, test.expected)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"math"
+	"net"
+	"sort"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// BuildCGFallbacksFromCRConfig returns map[cachegroup]fallbacks, the cachegroups to try, in order, when a cachegroup has no available servers.
+//
+// The fallbacks are the cachegroup's CRConfig edgeLocations backupLocations list, followed by all other cachegroups ordered by distance from it,
+// if it has fallbackToClosest. Cachegroups with neither have no fallbacks.
+//
+// This is computed when the CRConfig is loaded, so the request path never has to compute distances.
+//
+func BuildCGFallbacksFromCRConfig(crc *tc.CRConfig) map[tc.CacheGroupName][]tc.CacheGroupName {
+	fallbacks := map[tc.CacheGroupName][]tc.CacheGroupName{}
+	for cgNameStr, cg := range crc.EdgeLocations {
+		cgName := tc.CacheGroupName(cgNameStr)
+		cgFallbacks := []tc.CacheGroupName{}
+		added := map[tc.CacheGroupName]struct{}{cgName: {}}
+		for _, fallbackStr := range cg.BackupLocations.List {
+			fallback := tc.CacheGroupName(fallbackStr)
+			if _, ok := added[fallback]; ok {
+				continue
+			}
+			added[fallback] = struct{}{}
+			cgFallbacks = append(cgFallbacks, fallback)
+		}
+		if cg.BackupLocations.FallbackToClosest {
+			cgFallbacks = append(cgFallbacks, closestCacheGroups(crc.EdgeLocations, cg, added)...)
+		}
+		if len(cgFallbacks) > 0 {
+			fallbacks[cgName] = cgFallbacks
+		}
+	}
+	return fallbacks
+}
+
+// closestCacheGroups returns the cachegroups in locations, except those in exclude, ordered by distance from cg.
+func closestCacheGroups(locations map[string]tc.CRConfigLatitudeLongitude, cg tc.CRConfigLatitudeLongitude, exclude map[tc.CacheGroupName]struct{}) []tc.CacheGroupName {
+	distances := []cgDistance{}
+	for otherNameStr, other := range locations {
+		if _, ok := exclude[tc.CacheGroupName(otherNameStr)]; ok {
+			continue
+		}
+		distances = append(distances, cgDistance{name: tc.CacheGroupName(otherNameStr), distance: GreatCircleDistanceKM(cg.Lat, cg.Lon, other.Lat, other.Lon)})
+	}
+	sort.Slice(distances, func(i, j int) bool {
+		if distances[i].distance != distances[j].distance {
+			return distances[i].distance < distances[j].distance
+		}
+		return distances[i].name < distances[j].name // break ties by name, so the order is stable across CRConfig loads.
+	})
+	names := make([]tc.CacheGroupName, 0, len(distances))
+	for _, cgDist := range distances {
+		names = append(names, cgDist.name)
+	}
+	return names
+}
+
+// cgDistance is a cachegroup and its distance from a location.
+type cgDistance struct {
+	name     tc.CacheGroupName
+	distance float64
+}
+
+// EarthRadiusKM is the mean radius of the Earth, in kilometers.
+const EarthRadiusKM = 6371.0
+
+// GreatCircleDistanceKM returns the great-circle distance in kilometers between two points given in degrees, by the haversine formula.
+func GreatCircleDistanceKM(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	return haversineToKM(haversine(lat1*degToRad, lon1*degToRad, math.Cos(lat1*degToRad), lat2*degToRad, lon2*degToRad, math.Cos(lat2*degToRad)))
+}
+
+const degToRad = math.Pi / 180
+
+// haversine returns the haversine of the central angle between two points given in radians, with the cosines of their latitudes.
+// It increases with distance, so points can be compared by it without the inverse haversine of haversineToKM.
+func haversine(lat1 float64, lon1 float64, cosLat1 float64, lat2 float64, lon2 float64, cosLat2 float64) float64 {
+	sinDLat := math.Sin((lat2 - lat1) / 2)
+	sinDLon := math.Sin((lon2 - lon1) / 2)
+	return sinDLat*sinDLat + cosLat1*cosLat2*sinDLon*sinDLon
+}
+
+// haversineToKM returns the great-circle distance in kilometers of the haversine h.
+func haversineToKM(h float64) float64 {
+	return 2 * EarthRadiusKM * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// getServersWithFallback returns up to max servers available in avail for the DS, from the cachegroup cg if it has any,
+// otherwise from the first of its fallbacks which does.
+//
+// Cachegroups in the DS disabledLocations of the CRStates are treated as having no available servers,
+// so Traffic Monitor can take a cachegroup out of rotation for one DS, and its clients fall back as if it were down.
+//
+// If the DS has deep caching and clientIP is in a Deep CZF zone with available caches, those are returned before any cachegroup.
+// If cg is empty, the client isn't in the coverage zone file, and is geolocated by clientIP to the nearest cachegroup with available servers.
+//
+// Returns the servers, the cachegroup they're in or their Deep CZF zone, and the number of fallback hops taken to get there,
+// 0 if they're in cg itself, were geolocated, or are deep caches.
+// If no cachegroup has an available server, returns no servers.
+//
+func (sh *Shared) getServersWithFallback(avail *Availability, dsName tc.DeliveryServiceName, cg tc.CacheGroupName, clientIP net.IP, v4 bool, max int, hashKey string) ([]DNSDSServer, tc.CacheGroupName, int) {
+	if servers, deepZone := sh.getDeepServers(avail, dsName, clientIP, v4, max, hashKey); len(servers) > 0 {
+		return servers, tc.CacheGroupName(deepZone), 0
+	}
+
+	dsServers := sh.dsServers[dsName]
+	disabled := avail.DisabledLocations(dsName)
+	getCGServers := func(cg tc.CacheGroupName) []DNSDSServer {
+		if _, ok := disabled[cg]; ok {
+			return nil
+		}
+		return getServers(dsServers[cg], v4, avail, max, hashKey)
+	}
+
+	if cg == "" {
+		servers, geoCG := sh.getServersByGeo(dsName, clientIP, getCGServers)
+		return servers, geoCG, 0
+	}
+	if servers := getCGServers(cg); len(servers) > 0 {
+		return servers, cg, 0
+	}
+	for i, fallback := range sh.cgFallbacks[cg] {
+		if servers := getCGServers(fallback); len(servers) > 0 {
+			return servers, fallback, i + 1
+		}
+	}
+	return nil, "", 0
+}
@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestBuildCGFallbacksFromCRConfig(t *testing.T) {
+	crc := &tc.CRConfig{EdgeLocations: map[string]tc.CRConfigLatitudeLongitude{
+		// a lists c, itself, and c again, then falls back to the closest others
+		"a": {Lat: 0, Lon: 0, BackupLocations: tc.CRConfigBackupLocations{List: []string{"c", "a", "c"}, FallbackToClosest: true}},
+		"b": {Lat: 0, Lon: 10},
+		"c": {Lat: 0, Lon: 50, BackupLocations: tc.CRConfigBackupLocations{List: []string{"b"}}},
+		// d is equally far from a and b, which are ordered by name
+		"d": {Lat: 0, Lon: 5, BackupLocations: tc.CRConfigBackupLocations{FallbackToClosest: true}},
+	}}
+	expected := map[tc.CacheGroupName][]tc.CacheGroupName{
+		"a": {"c", "d", "b"},
+		"c": {"b"},
+		"d": {"a", "b", "c"},
+	}
+	if got := BuildCGFallbacksFromCRConfig(crc); !reflect.DeepEqual(got, expected) {
+		t.Errorf("BuildCGFallbacksFromCRConfig = %v, expected %v", got, expected)
+	}
+}
+
+func TestGetServersWithFallback(t *testing.T) {
+	// cg-east has edge1, edge2, and edge3, and falls back to cg-west, which has edge4 and no fallbacks.
+	tests := []struct {
+		name          string
+		cg            tc.CacheGroupName
+		crStates      func(crs *tc.CRStates)
+		expectedCG    tc.CacheGroupName
+		expectedHops  int
+		expectServers bool
+	}{
+		{"own cachegroup", "cg-east", nil, "cg-east", 0, true},
+		{"cachegroup down", "cg-east", func(crs *tc.CRStates) {
+			for _, cache := range []tc.CacheName{"edge1", "edge2", "edge3"} {
+				crs.Caches[cache] = tc.IsAvailable{IsAvailable: false}
+			}
+		}, "cg-west", 1, true},
+		{"cachegroup disabled", "cg-east", func(crs *tc.CRStates) {
+			crs.DeliveryService["dnsds"] = tc.CRStatesDeliveryService{IsAvailable: true, DisabledLocations: []tc.CacheGroupName{"cg-east"}}
+		}, "cg-west", 1, true},
+		{"fallback down too", "cg-east", func(crs *tc.CRStates) {
+			for cache := range crs.Caches {
+				crs.Caches[cache] = tc.IsAvailable{IsAvailable: false}
+			}
+		}, "", 0, false},
+		{"no fallbacks", "cg-west", func(crs *tc.CRStates) {
+			crs.Caches["edge4"] = tc.IsAvailable{IsAvailable: false}
+		}, "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sh := loadTestShared(t)
+			crs := allAvailable(sh.GetCRConfig())
+			if test.crStates != nil {
+				test.crStates(crs)
+			}
+			sh.SetCRStates(crs)
+			servers, cg, hops := sh.getServersWithFallback(sh.GetAvailability(), "dnsds", test.cg, net.ParseIP("10.0.0.5"), true, 0, "edge.dnsds.cdn.example.net")
+			if cg != test.expectedCG || hops != test.expectedHops || (len(servers) > 0) != test.expectServers {
+				t.Errorf("getServersWithFallback = %d servers in '%s' after %d hops, expected servers %t in '%s' after %d hops", len(servers), cg, hops, test.expectServers, test.expectedCG, test.expectedHops)
+			}
+		})
+	}
+}
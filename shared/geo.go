@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// getServersByGeo returns the servers of the nearest cachegroup to the client which has any, for clients not in the coverage zone file.
+//
+// The client is located by the DS geolocationProvider, and cachegroups are tried in order of great-circle distance from it, using the CRConfig edgeLocations.
+// The getCGServers func returns the available servers of a cachegroup, or none if it's disabled or has none for the DS.
+//
+// Returns the servers and the cachegroup they're in. If the client can't be located, or no cachegroup has an available server, returns no servers.
+//
+func (sh *Shared) getServersByGeo(dsName tc.DeliveryServiceName, clientIP net.IP, getCGServers func(cg tc.CacheGroupName) []DNSDSServer) ([]DNSDSServer, tc.CacheGroupName) {
+	if clientIP == nil {
+		return nil, ""
+	}
+	provider := sh.geo.Get(sh.dsConfigs[dsName].GeoProvider)
+	if provider == nil {
+		fmt.Println("EVENT: client '" + clientIP.String() + "' ds '" + string(dsName) + "' not in czf, and no geolocation provider is configured")
+		return nil, ""
+	}
+	loc, ok := provider.Locate(clientIP)
+	if !ok {
+		fmt.Println("EVENT: client '" + clientIP.String() + "' ds '" + string(dsName) + "' not in czf, and could not be geolocated")
+		return nil, ""
+	}
+	nearest := [geoCandidates]cgDistance{}
+	for _, cgDist := range nearestCacheGroups(sh.cgLocations, loc.Lat, loc.Lon, &nearest) {
+		if servers := getCGServers(cgDist.name); len(servers) > 0 {
+			fmt.Printf("EVENT: client '%v' ds '%v' not in czf, geolocated to %v,%v nearest cg with available servers '%v'\n", clientIP, dsName, loc.Lat, loc.Lon, cgDist.name)
+			return servers, cgDist.name
+		}
+	}
+	if len(sh.cgLocations) <= geoCandidates {
+		return nil, ""
+	}
+
+	// none of the nearest cachegroups has available servers, which is rare enough to sort all the others
+	tried := make(map[tc.CacheGroupName]struct{}, geoCandidates)
+	for _, cgDist := range nearest {
+		tried[cgDist.name] = struct{}{}
+	}
+	for _, cg := range closestCacheGroups(sh.edgeLocations, tc.CRConfigLatitudeLongitude{Lat: loc.Lat, Lon: loc.Lon}, tried) {
+		if servers := getCGServers(cg); len(servers) > 0 {
+			fmt.Printf("EVENT: client '%v' ds '%v' not in czf, geolocated to %v,%v nearest cg with available servers '%v'\n", clientIP, dsName, loc.Lat, loc.Lon, cg)
+			return servers, cg
+		}
+	}
+	return nil, ""
+}
+
+// geoCandidates is the number of nearest cachegroups found for a geolocated client in a single pass over the cachegroups.
+// Only if none of them has available servers are the rest sorted by distance.
+const geoCandidates = 8
+
+// cgLocation is the location of a cachegroup, in radians, with the cosine of its latitude precomputed.
+type cgLocation struct {
+	name   tc.CacheGroupName
+	lat    float64
+	lon    float64
+	cosLat float64
+}
+
+// buildCGLocations returns the cachegroups of the CRConfig edgeLocations, sorted by name, so nearestCacheGroups breaks ties by name.
+func buildCGLocations(edgeLocations map[string]tc.CRConfigLatitudeLongitude) []cgLocation {
+	locations := make([]cgLocation, 0, len(edgeLocations))
+	for name, loc := range edgeLocations {
+		locations = append(locations, cgLocation{name: tc.CacheGroupName(name), lat: loc.Lat * degToRad, lon: loc.Lon * degToRad, cosLat: math.Cos(loc.Lat * degToRad)})
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].name < locations[j].name })
+	return locations
+}
+
+// nearestCacheGroups returns the len(nearest) cachegroups of locations nearest to lat,lon, nearest first, in the given array.
+// Ties are broken by name, so the order is the same as closestCacheGroups.
+//
+// This is a single pass keeping the nearest cachegroups in order by insertion, so it doesn't allocate or sort every cachegroup on every request.
+// Cachegroups are compared by haversine, which is cheaper than the distance, and only the kept ones are converted to kilometers.
+// The locations must be sorted by name, as buildCGLocations.
+//
+func nearestCacheGroups(locations []cgLocation, lat float64, lon float64, nearest *[geoCandidates]cgDistance) []cgDistance {
+	lat, lon = lat*degToRad, lon*degToRad
+	cosLat := math.Cos(lat)
+	n := 0
+	for _, cg := range locations {
+		distance := haversine(lat, lon, cosLat, cg.lat, cg.lon, cg.cosLat)
+		if n == len(nearest) && distance >= nearest[n-1].distance {
+			continue // not nearer than any kept, and a tie goes to the kept one, which is first by name
+		}
+		i := n
+		if n < len(nearest) {
+			n++
+		} else {
+			i = n - 1 // replace the farthest
+		}
+		for ; i > 0 && nearest[i-1].distance > distance; i-- {
+			nearest[i] = nearest[i-1]
+		}
+		nearest[i] = cgDistance{name: cg.name, distance: distance}
+	}
+	for i := 0; i < n; i++ {
+		nearest[i].distance = haversineToKM(nearest[i].distance)
+	}
+	return nearest[:n]
+}
@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// randEdgeLocations returns num random cachegroup locations. Coordinates are rounded to whole degrees, so some cachegroups tie.
+func randEdgeLocations(rnd *rand.Rand, num int) map[string]tc.CRConfigLatitudeLongitude {
+	locations := map[string]tc.CRConfigLatitudeLongitude{}
+	for i := 0; i < num; i++ {
+		locations["cg"+strconv.Itoa(i)] = tc.CRConfigLatitudeLongitude{Lat: float64(rnd.Intn(181) - 90), Lon: float64(rnd.Intn(361) - 180)}
+	}
+	return locations
+}
+
+func TestNearestCacheGroups(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for _, num := range []int{0, 1, 3, geoCandidates, geoCandidates + 1, 50, 500} {
+		locations := randEdgeLocations(rnd, num)
+		cgLocations := buildCGLocations(locations)
+		for i := 0; i < 100; i++ {
+			client := tc.CRConfigLatitudeLongitude{Lat: float64(rnd.Intn(181) - 90), Lon: float64(rnd.Intn(361) - 180)}
+			expected := closestCacheGroups(locations, client, nil)
+			if len(expected) > geoCandidates {
+				expected = expected[:geoCandidates]
+			}
+
+			nearest := [geoCandidates]cgDistance{}
+			got := []tc.CacheGroupName{}
+			for _, cgDist := range nearestCacheGroups(cgLocations, client.Lat, client.Lon, &nearest) {
+				got = append(got, cgDist.name)
+			}
+			if len(got) != len(expected) || (len(got) > 0 && !reflect.DeepEqual(got, expected)) {
+				t.Fatalf("%d cachegroups, client %v,%v: nearest %v, expected %v", num, client.Lat, client.Lon, got, expected)
+			}
+		}
+	}
+}
+
+func TestNearestCacheGroupsTies(t *testing.T) {
+	// every cachegroup is the same distance away, so they're ordered by name
+	locations := map[string]tc.CRConfigLatitudeLongitude{}
+	for i := geoCandidates * 2; i > 0; i-- {
+		locations["cg"+strconv.Itoa(100+i)] = tc.CRConfigLatitudeLongitude{Lat: 10, Lon: 20}
+	}
+	nearest := [geoCandidates]cgDistance{}
+	got := nearestCacheGroups(buildCGLocations(locations), 0, 0, &nearest)
+	for i, cgDist := range got {
+		if expected := tc.CacheGroupName("cg" + strconv.Itoa(101+i)); cgDist.name != expected {
+			t.Errorf("nearest %d = '%s', expected '%s'", i, cgDist.name, expected)
+		}
+	}
+}
+
+func TestGreatCircleDistanceKM(t *testing.T) {
+	tests := []struct {
+		name       string
+		lat1, lon1 float64
+		lat2, lon2 float64
+		expected   float64
+	}{
+		{"same point", 40, -75, 40, -75, 0},
+		{"antipodes", 0, 0, 0, 180, math.Pi * EarthRadiusKM},
+		{"pole to pole", 90, 0, -90, 0, math.Pi * EarthRadiusKM},
+		{"new york to los angeles", 40.7128, -74.0060, 34.0522, -118.2437, 3935.7},
+		{"across the antimeridian", 0, 179, 0, -179, 222.4},
+	}
+	for _, test := range tests {
+		if got := GreatCircleDistanceKM(test.lat1, test.lon1, test.lat2, test.lon2); math.Abs(got-test.expected) > 0.5 {
+			t.Errorf("%s: GreatCircleDistanceKM = %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func BenchmarkNearestCacheGroups(b *testing.B) {
+	cgLocations := buildCGLocations(randEdgeLocations(rand.New(rand.NewSource(42)), 500))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nearest := [geoCandidates]cgDistance{}
+		nearestCacheGroups(cgLocations, 40, -75, &nearest)
+	}
+}
+
+func BenchmarkClosestCacheGroups(b *testing.B) {
+	locations := randEdgeLocations(rand.New(rand.NewSource(42)), 500)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		closestCacheGroups(locations, tc.CRConfigLatitudeLongitude{Lat: 40, Lon: -75}, nil)
+	}
+}
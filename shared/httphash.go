@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// BuildConsistentHashRegex returns the compiled consistentHashRegex of the Delivery Service, or nil if it doesn't have one.
+//
+// Note the Java Traffic Router uses Java regular expressions, and this uses Go's RE2 syntax.
+// Most path regexes are the same in both, but features like backreferences and lookaheads will fail to compile.
+//
+func BuildConsistentHashRegex(ds tc.CRConfigDeliveryService) (*regexp.Regexp, error) {
+	if ds.ConsistentHashRegex == nil || *ds.ConsistentHashRegex == "" {
+		return nil, nil
+	}
+	return regexp.Compile(*ds.ConsistentHashRegex)
+}
+
+// BuildConsistentHashQueryParams returns the set of the Delivery Service's consistentHashQueryParams, or nil if it has none.
+func BuildConsistentHashQueryParams(ds tc.CRConfigDeliveryService) map[string]struct{} {
+	if len(ds.ConsistentHashQueryParams) == 0 {
+		return nil
+	}
+	params := map[string]struct{}{}
+	for _, param := range ds.ConsistentHashQueryParams {
+		params[param] = struct{}{}
+	}
+	return params
+}
+
+// buildHTTPHashKey returns the string to consistent hash an HTTP request for the given Delivery Service by, as the Java Traffic Router does.
+//
+// This is the request path, or if the DS has a consistentHashRegex which matches it, the concatenation of the regex's capture groups.
+// It's followed by the DS consistentHashQueryParams in the request, sorted, as name=value, so the order of parameters doesn't matter,
+// and parameters which don't identify content, such as cache-busters, don't change the cache.
+//
+func buildHTTPHashKey(dsCfg DSConfig, reqURL *url.URL) string {
+	key := reqURL.Path
+	if dsCfg.ConsistentHashRegex != nil {
+		if groups := dsCfg.ConsistentHashRegex.FindStringSubmatch(reqURL.Path); len(groups) > 1 {
+			key = strings.Join(groups[1:], "")
+		}
+	}
+	if len(dsCfg.ConsistentHashQueryParams) == 0 || reqURL.RawQuery == "" {
+		return key
+	}
+	params := []string{}
+	for _, param := range strings.Split(reqURL.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		parts := strings.Split(param, "=")
+		for i, part := range parts {
+			if unescaped, err := url.QueryUnescape(part); err == nil {
+				parts[i] = unescaped
+			}
+		}
+		if _, ok := dsCfg.ConsistentHashQueryParams[parts[0]]; !ok {
+			continue
+		}
+		params = append(params, strings.Join(parts, "="))
+	}
+	sort.Strings(params)
+	return key + strings.Join(dedupSorted(params), "")
+}
+
+// dedupSorted removes duplicates from the sorted strs, in place, and returns it.
+func dedupSorted(strs []string) []string {
+	if len(strs) < 2 {
+		return strs
+	}
+	deduped := strs[:1]
+	for _, str := range strs[1:] {
+		if str != deduped[len(deduped)-1] {
+			deduped = append(deduped, str)
+		}
+	}
+	return deduped
+}
@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestBuildHTTPHashKey(t *testing.T) {
+	// the test httpds has consistentHashRegex ^/[^/]+(/.*\.mp4)$ and consistentHashQueryParams v and id.
+	sh := loadTestShared(t)
+	tests := []struct {
+		name     string
+		ds       tc.DeliveryServiceName
+		url      string
+		expected string
+	}{
+		{"regex capture", "httpds", "/a/b/c.mp4", "/b/c.mp4"},
+		{"regex doesn't match", "httpds", "/a/b/c.ts", "/a/b/c.ts"},
+		{"query params", "httpds", "/a/b/c.mp4?id=1&v=2", "/b/c.mp4id=1v=2"},
+		{"query params in any order", "httpds", "/a/b/c.mp4?v=2&id=1", "/b/c.mp4id=1v=2"},
+		{"other query params ignored", "httpds", "/a/b/c.mp4?t=12345&id=1&v=2&", "/b/c.mp4id=1v=2"},
+		{"escaped query params", "httpds", "/a/b/c.mp4?i%64=1%2F2", "/b/c.mp4id=1/2"},
+		{"duplicate query params", "httpds", "/a/b/c.mp4?id=1&id=1", "/b/c.mp4id=1"},
+		{"no regex or params", "dnsds", "/a/b/c.mp4?id=1", "/a/b/c.mp4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reqURL, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buildHTTPHashKey(sh.dsConfigs[test.ds], reqURL); got != test.expected {
+				t.Errorf("buildHTTPHashKey('%s') = '%s', expected '%s'", test.url, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestBuildConsistentHashRegex(t *testing.T) {
+	str := func(s string) *string { return &s }
+	if re, err := BuildConsistentHashRegex(tc.CRConfigDeliveryService{}); re != nil || err != nil {
+		t.Errorf("BuildConsistentHashRegex(none) = %v, %v, expected nil", re, err)
+	}
+	if re, err := BuildConsistentHashRegex(tc.CRConfigDeliveryService{ConsistentHashRegex: str("")}); re != nil || err != nil {
+		t.Errorf("BuildConsistentHashRegex(empty) = %v, %v, expected nil", re, err)
+	}
+	// Java lookaheads aren't RE2
+	if _, err := BuildConsistentHashRegex(tc.CRConfigDeliveryService{ConsistentHashRegex: str("^/(?=a).*$")}); err == nil {
+		t.Error("BuildConsistentHashRegex(lookahead) succeeded, expected an error")
+	}
+}
+
+func TestGetServerForHTTPHashesContent(t *testing.T) {
+	sh := loadTestShared(t)
+	cl := &Client{Addr: testAddr{}, IP: []byte{10, 0, 0, 5}}
+	get := func(rawURL string) DNSDSServer {
+		t.Helper()
+		reqURL, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sv, _, result := sh.GetServerForHTTP(cl, "ccr.httpds.cdn.example.net", reqURL, true)
+		if result != ResultOK {
+			t.Fatalf("GetServerForHTTP('%s') = %s, expected %s", rawURL, result, ResultOK)
+		}
+		return sv
+	}
+	// requests for the same content, by the regex and query params, go to the same cache, whatever their prefix or other params
+	for i := 0; i < 20; i++ {
+		content := "/" + string(rune('a'+i)) + ".mp4?id=" + string(rune('a'+i))
+		first := get("/one" + content)
+		if second := get("/two" + content + "&t=123"); second.HostName != first.HostName {
+			t.Errorf("'%s' went to '%s' and '%s', expected the same cache", content, first.HostName, second.HostName)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package shared
+
+import (
+	"strings"
+
+	"github.com/rob05c/traffic_router/match"
+)
+
+// Result is the outcome of looking up a requested domain.
+// Handlers translate it into a DNS Rcode or HTTP status.
+type Result int
+
+const (
+	// ResultOK means the lookup succeeded.
+	ResultOK Result = iota
+	// ResultRefused means the domain is not in the CDN domain, and we aren't authoritative for it.
+	ResultRefused
+	// ResultServFail means there was a server error, e.g. no available servers for the DS.
+	ResultServFail
+	// ResultNXDomain means the domain is in the CDN domain, but doesn't exist.
+	ResultNXDomain
+	// ResultNoData means the domain exists, but has no records of the requested type.
+	ResultNoData
+	// ResultBypass means no cache is available for the DNS Delivery Service, and the client should be sent to its bypass destination.
+	ResultBypass
+)
+
+func (r Result) String() string {
+	switch r {
+	case ResultOK:
+		return "OK"
+	case ResultRefused:
+		return "Refused"
+	case ResultServFail:
+		return "ServFail"
+	case ResultNXDomain:
+		return "NXDomain"
+	case ResultNoData:
+		return "NoData"
+	case ResultBypass:
+		return "Bypass"
+	default:
+		return "Invalid"
+	}
+}
+
+// GetDomainResult returns the Result for a request for a type we have no records of, for the given domain.
+// This is ResultRefused if the domain isn't in the CDN domain, ResultNoData if it exists, and ResultNXDomain if it doesn't.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetDomainResult(domain string) Result {
+	domain = strings.TrimSuffix(domain, ".")
+	if !sh.inCDNDomain(domain) {
+		return ResultRefused
+	}
+	if sh.nameExists(domain) {
+		return ResultNoData
+	}
+	return ResultNXDomain
+}
+
+// NameExists returns whether domain, which may have a trailing period, exists in the CDN domain.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) NameExists(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	return sh.inCDNDomain(domain) && sh.nameExists(domain)
+}
+
+// GetNameAddrTypes returns whether the existing name domain, which may have a trailing period, has A and AAAA records.
+// This is used for DNSSEC denial of existence, which must list the types a name has.
+//
+// A DNS Delivery Service name has the address types of its caches in any cachegroup and of its DNS bypass,
+// and an HTTP Delivery Service name has the address types of the routers.
+// This doesn't depend on availability, so a NoData answer must also leave out the requested type, which its lookup found none of.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetNameAddrTypes(domain string) (bool, bool) {
+	domain = strings.TrimSuffix(domain, ".")
+	if secondMatch, ok := sh.httpSecondDNSMatches[domain]; ok {
+		sv, ok := sh.GetCRConfig().ContentServers[string(secondMatch.CacheName)]
+		if !ok {
+			return false, false
+		}
+		return sv.Ip != nil && *sv.Ip != "", sv.Ip6 != nil && *sv.Ip6 != ""
+	}
+	if ns, ok := sh.nameServerMatches[domain]; ok {
+		return ns.IP != nil, ns.IP6 != nil
+	}
+	if dsName, ok := sh.dnsMatches.Match(domain); ok {
+		hasV4, hasV6 := false, false
+		for _, servers := range sh.dsServers[dsName] {
+			hasV4 = hasV4 || len(servers.V4s) > 0
+			hasV6 = hasV6 || len(servers.V6s) > 0
+		}
+		if bypass := sh.GetDNSBypass(dsName); bypass != nil {
+			hasV4 = hasV4 || bypass.IP != nil
+			hasV6 = hasV6 || bypass.IP6 != nil
+		}
+		return hasV4, hasV6
+	}
+	if _, ok := sh.httpDNSMatches.Match(domain); ok {
+		return len(sh.allRouters.V4s) > 0, len(sh.allRouters.V6s) > 0
+	}
+	return false, false
+}
+
+// inCDNDomain returns whether domain, without a trailing period, is the CDN domain or a name inside it.
+func (sh *Shared) inCDNDomain(domain string) bool {
+	return domain == sh.cdnDomain || strings.HasSuffix(domain, "."+sh.cdnDomain)
+}
+
+// nameExists returns whether domain, without a trailing period, exists in the CDN domain.
+func (sh *Shared) nameExists(domain string) bool {
+	if len(sh.GetStaticDNSEntries(domain)) > 0 {
+		return true
+	}
+	if _, ok := sh.httpSecondDNSMatches[domain]; ok {
+		return true
+	}
+	if _, ok := sh.nameServerMatches[domain]; ok {
+		return true
+	}
+	if _, ok := sh.dnsMatches.Match(domain); ok {
+		return true
+	}
+	if _, ok := sh.httpDNSMatches.Match(domain); ok {
+		return true
+	}
+	return sh.nameExistsWithoutAddrs(domain)
+}
+
+// nameExistsWithoutAddrs returns whether domain, without a trailing period, is a name with no addresses which nonetheless exists:
+// the CDN domain itself, or an empty non-terminal such as ds-name.cdn-domain.
+//
+// Empty non-terminals must be NoData, not NXDomain. Per RFC 8020, an NXDomain means nothing exists beneath the name,
+// which would break resolvers using QNAME minimisation (RFC 7816).
+func (sh *Shared) nameExistsWithoutAddrs(domain string) bool {
+	if domain == sh.cdnDomain {
+		return true
+	}
+	_, ok := sh.emptyNonTerminals[domain]
+	return ok
+}
+
+// BuildEmptyNonTerminals returns the set of names between each of the given names and the CDN domain, exclusive.
+//
+// Only literal names are known. Names matched by regexes can't be enumerated, so their ancestors will be NXDomain unless some literal name shares them.
+//
+func BuildEmptyNonTerminals(cdnDomain string, names []string) map[string]struct{} {
+	ents := map[string]struct{}{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, "."+cdnDomain) {
+			continue
+		}
+		for {
+			dotI := strings.Index(name, ".")
+			if dotI < 0 {
+				break
+			}
+			name = name[dotI+1:]
+			if name == cdnDomain {
+				break
+			}
+			ents[name] = struct{}{}
+		}
+	}
+	return ents
+}
+
+// literalNames returns the FQDNs of all literal names in the given matches, map keys, name servers, and static entries.
+func literalNames(matchSets []DSMatches, secondDNSMatches map[string]SecondDNSMatch, nameServers NameServers, staticDNSEntries map[string][]StaticDNSEntry) []string {
+	names := []string{}
+	for _, matches := range matchSets {
+		for _, dsMatch := range matches {
+			for _, ma := range dsMatch.Matches {
+				if lit, ok := match.Literal(ma); ok {
+					names = append(names, lit)
+				}
+			}
+		}
+	}
+	for name, _ := range secondDNSMatches {
+		names = append(names, name)
+	}
+	for _, ns := range nameServers.Servers {
+		names = append(names, ns.FQDN)
+	}
+	for name, _ := range staticDNSEntries {
+		names = append(names, name)
+	}
+	return names
+}
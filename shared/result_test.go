@@ -0,0 +1,164 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestGetDomainResult(t *testing.T) {
+	sh := loadTestShared(t)
+	tests := []struct {
+		domain   string
+		expected Result
+	}{
+		{"example.org.", ResultRefused},
+		{"notcdn.example.net.", ResultRefused},
+		{"example.net.", ResultRefused},
+		{"cdn.example.net.", ResultNoData},                // the apex
+		{"cdn.example.net", ResultNoData},                 // without the trailing period
+		{"edge.dnsds.cdn.example.net.", ResultNoData},     // DNS DS match
+		{"ccr.httpds.cdn.example.net.", ResultNoData},     // HTTP DS literal match
+		{"edge1.httpds.cdn.example.net.", ResultNoData},   // HTTP DS second DNS request
+		{"tr01.cdn.example.net.", ResultNoData},           // name server
+		{"www.dnsds.cdn.example.net.", ResultNoData},      // static entry
+		{"httpds.cdn.example.net.", ResultNoData},         // empty non-terminal
+		{"nope.cdn.example.net.", ResultNXDomain},         // nothing
+		{"x.ccr.httpds.cdn.example.net.", ResultNXDomain}, // beneath a literal
+		{"edge9.httpds.cdn.example.net.", ResultNXDomain}, // not a server of the DS
+	}
+	for _, test := range tests {
+		if got := sh.GetDomainResult(test.domain); got != test.expected {
+			t.Errorf("GetDomainResult('%s') = %s, expected %s", test.domain, got, test.expected)
+		}
+		expectedExists := test.expected == ResultNoData
+		if got := sh.NameExists(test.domain); got != expectedExists {
+			t.Errorf("NameExists('%s') = %t, expected %t", test.domain, got, expectedExists)
+		}
+	}
+}
+
+func TestGetDomainResultMixedCaseCRConfig(t *testing.T) {
+	// names in the CRConfig are matched case-insensitively: the routing tables are lowercase, like the names handlers look up.
+	crc := loadTestCRConfig(t)
+	crc.Config["domain_name"] = "CDN.Example.NET"
+	ds := crc.DeliveryServices["httpds"]
+	ds.MatchSets[0].MatchList[0].Regex = `.*\.HttpDS\..*`
+	routingName := "CCR"
+	ds.RoutingName = &routingName
+	crc.DeliveryServices["httpds"] = ds
+	router := crc.ContentRouters["tr01"]
+	delete(crc.ContentRouters, "tr01")
+	crc.ContentRouters["TR01"] = router
+
+	sh := NewShared(nil, nil, nil, crc, allAvailable(crc), nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	if got := sh.GetCDNDomain(); got != "cdn.example.net" {
+		t.Errorf("GetCDNDomain() = '%s', expected 'cdn.example.net'", got)
+	}
+	tests := []struct {
+		domain   string
+		expected Result
+	}{
+		{"cdn.example.net.", ResultNoData},
+		{"ccr.httpds.cdn.example.net.", ResultNoData},
+		{"httpds.cdn.example.net.", ResultNoData},
+		{"tr01.cdn.example.net.", ResultNoData},
+		{"www.dnsds.cdn.example.net.", ResultNoData},
+		{"nope.cdn.example.net.", ResultNXDomain},
+	}
+	for _, test := range tests {
+		if got := sh.GetDomainResult(test.domain); got != test.expected {
+			t.Errorf("GetDomainResult('%s') = %s, expected %s", test.domain, got, test.expected)
+		}
+	}
+	if !sh.IsCDNDomain("cdn.example.net.") {
+		t.Error("IsCDNDomain('cdn.example.net.') = false, expected true")
+	}
+}
+
+func TestGetNameAddrTypes(t *testing.T) {
+	sh := loadTestShared(t)
+	tests := []struct {
+		domain     string
+		expectedV4 bool
+		expectedV6 bool
+	}{
+		{"edge1.httpds.cdn.example.net.", true, true},  // edge1 has an ip and ip6
+		{"edge2.httpds.cdn.example.net.", true, false}, // edge2 has only an ip
+		{"tr01.cdn.example.net.", true, true},
+		{"tr02.cdn.example.net.", true, false},
+		{"edge.dnsds.cdn.example.net.", true, true},
+		{"ccr.httpds.cdn.example.net.", true, true},
+		{"httpds.cdn.example.net.", false, false},
+		{"nope.cdn.example.net.", false, false},
+	}
+	for _, test := range tests {
+		v4, v6 := sh.GetNameAddrTypes(test.domain)
+		if v4 != test.expectedV4 || v6 != test.expectedV6 {
+			t.Errorf("GetNameAddrTypes('%s') = %t %t, expected %t %t", test.domain, v4, v6, test.expectedV4, test.expectedV6)
+		}
+	}
+}
+
+func TestGetServerForDomainResult(t *testing.T) {
+	sh := loadTestShared(t)
+	tests := []struct {
+		domain   string
+		v4       bool
+		expected Result
+	}{
+		{"edge.dnsds.cdn.example.net.", true, ResultOK},
+		{"edge.dnsds.cdn.example.net.", false, ResultOK},
+		{"edge.dnsds.cdn.example.net", true, ResultRefused}, // no trailing period
+		{"example.org.", true, ResultRefused},
+		{"nope.cdn.example.net.", true, ResultNXDomain},
+		{"cdn.example.net.", true, ResultNoData},
+		{"httpds.cdn.example.net.", true, ResultNoData},
+		{"edge2.httpds.cdn.example.net.", false, ResultNoData},
+		{"ccr.httpds.cdn.example.net.", true, ResultOK},
+		{"tr01.cdn.example.net.", false, ResultOK},
+	}
+	for _, test := range tests {
+		cl := &Client{Addr: testAddr{}, IP: []byte{10, 0, 0, 5}}
+		_, _, _, result := sh.GetServerForDomain(cl, test.domain, test.v4)
+		if result != test.expected {
+			t.Errorf("GetServerForDomain('%s', v4 %t) = %s, expected %s", test.domain, test.v4, result, test.expected)
+		}
+	}
+
+	// with every cache down, the DNS DS has a bypass
+	sh.SetCRStates(&tc.CRStates{})
+	cl := &Client{Addr: testAddr{}, IP: []byte{10, 0, 0, 5}}
+	if _, dsName, _, result := sh.GetServerForDomain(cl, "edge.dnsds.cdn.example.net.", true); result != ResultBypass || dsName != "dnsds" {
+		t.Errorf("GetServerForDomain with no caches available = '%s' %s, expected 'dnsds' %s", dsName, result, ResultBypass)
+	}
+}
+
+func TestResultString(t *testing.T) {
+	tests := []struct {
+		result   Result
+		expected string
+	}{
+		{ResultOK, "OK"},
+		{ResultRefused, "Refused"},
+		{ResultServFail, "ServFail"},
+		{ResultNXDomain, "NXDomain"},
+		{ResultNoData, "NoData"},
+		{ResultBypass, "Bypass"},
+		{Result(-1), "Invalid"},
+	}
+	for _, test := range tests {
+		if got := test.result.String(); got != test.expected {
+			t.Errorf("Result(%d).String() = '%s', expected '%s'", int(test.result), got, test.expected)
+		}
+	}
+}
+
+// testAddr is a net.Addr for test clients.
+type testAddr struct{}
+
+func (testAddr) Network() string { return "udp" }
+func (testAddr) String() string  { return "10.0.0.5:53" }
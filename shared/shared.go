@@ -7,20 +7,27 @@ package shared
 // All functions which are safe for handlers say they are safe for usage by handlers.
 // If func does not say it is safe for use by handlers, IT IS NOT.
 //
+// All names are lowercase. DNS names are case-insensitive, and resolvers randomize the case of queries (draft-vixie-dnsext-dns0x20),
+// so the routing tables are built with lowercase names, and handlers must lowercase requested names before passing them to any func here.
+//
 
 import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
+	"net/url"
 	"strings"
 	"sync/atomic"
 	"unsafe"
 
 	"github.com/apache/trafficcontrol/lib/go-tc"
 	"github.com/apache/trafficcontrol/lib/go-util"
+	"github.com/rob05c/traffic_router/chash"
 	"github.com/rob05c/traffic_router/czf"
+	"github.com/rob05c/traffic_router/deepczf"
+	"github.com/rob05c/traffic_router/dnssec"
+	"github.com/rob05c/traffic_router/geo"
 	"github.com/rob05c/traffic_router/match"
 )
 
@@ -46,31 +53,80 @@ type Shared struct {
 	// httpDNSMatches matches client request FQDN to DS name, for the initial DNS request for an HTTP DS.
 	// TODO combine matches, and have a match return the DS type?
 	httpDNSMatches DSMatches
-	// httpSecondDNSMatches contains map[fqdn]cache for the second DNS lookup of an HTTP DS,
+	// httpSecondDNSMatches contains map[fqdn]match for the second DNS lookup of an HTTP DS,
 	// of the form cache-name.ds-name.cdn-domain
-	httpSecondDNSMatches map[string]tc.CacheName
+	httpSecondDNSMatches map[string]SecondDNSMatch
 	// dsServers matches ds to servers assigned to that DS (and hashed in order).
 	dsServers map[tc.DeliveryServiceName]map[tc.CacheGroupName]DNSDSServers
+	// deepCZF is the Deep Coverage Zone File, for deep caching DSes. May be nil.
+	deepCZF *deepczf.ParsedDeepCZF
+	// deepServers is map[ds][deepZone]servers, the servers of each Deep CZF zone, for deep caching DSes.
+	deepServers map[tc.DeliveryServiceName]map[string]DeepServers
+	// geo is the geolocation providers, for clients not in the czf.
+	geo geo.Providers
+	// edgeLocations is the CRConfig edgeLocations, the locations of the cachegroups, for geolocated clients.
+	edgeLocations map[string]tc.CRConfigLatitudeLongitude
+	// cgLocations is the edgeLocations sorted by name, for finding the nearest cachegroups to geolocated clients without sorting them all.
+	cgLocations []cgLocation
+	// cgFallbacks maps cachegroups to the cachegroups to try, in order, when they have no available servers.
+	cgFallbacks map[tc.CacheGroupName][]tc.CacheGroupName
 	// cgRouters maps cachegroups to router servers
 	cgRouters map[tc.CacheGroupName]DNSDSServers
-	// serverAvailable is whether the given server is available, per the Traffic Monitor CRStates.
-	serverAvailable map[tc.CacheName]bool
+	// allRouters is the routers of all cachegroups, for HTTP DS initial DNS requests, with their hash rings.
+	allRouters DNSDSServers
 	// cdnDomain is the config/domain_name in the CRConfig, the TLD of the CDN.
 	cdnDomain string
 	certs     map[string]*tls.Certificate
+	// soa is the Start of Authority of the cdnDomain.
+	soa SOA
+	// nameServers are the Traffic Routers authoritative for the cdnDomain, for NS records.
+	nameServers NameServers
+	// nameServerMatches contains map[fqdn]nameserver, for A and AAAA requests for the name servers themselves.
+	nameServerMatches map[string]NameServer
+	// emptyNonTerminals is the set of names in the cdnDomain with no records, but with names beneath them, e.g. ds-name.cdn-domain.
+	emptyNonTerminals map[string]struct{}
+	// signer signs responses with DNSSEC. If nil, DNSSEC is disabled.
+	signer *dnssec.Signer
+	// dnskeyTTL is the TTL of the DNSKEY RRset, from the CRConfig config/ttls.
+	dnskeyTTL uint32
+	// dsConfigs contains the routing configuration of each Delivery Service.
+	dsConfigs map[tc.DeliveryServiceName]DSConfig
+	// staticDNSEntries contains map[fqdn]entries for the Delivery Service static DNS entries, which take precedence over routing.
+	staticDNSEntries map[string][]StaticDNSEntry
+	// defaultTTLs are the CRConfig config/ttls, for names outside Delivery Services and Delivery Services without ttls.
+	defaultTTLs TTLs
 
-	crStates *unsafe.Pointer
 	crConfig *unsafe.Pointer
+	// health is the *health, the CRStates and the Availability built from them.
+	// It's shared by every Shared built from this one with NewWithCRConfig, so CRStates set on any of them are seen by all.
+	health *unsafe.Pointer
+}
+
+// health is the CRStates and the Availability built from them, which are set together so handlers never see one without the other.
+type health struct {
+	crStates     *tc.CRStates
+	availability *Availability
+}
+
+func (sh *Shared) getHealth() *health {
+	return (*health)(atomic.LoadPointer(sh.health))
 }
 
 func (sh *Shared) GetCRStates() *tc.CRStates {
-	crStates := (*tc.CRStates)(atomic.LoadPointer(sh.crStates))
-	return crStates
+	return sh.getHealth().crStates
 }
 
+// SetCRStates sets the CRStates, and the cache and Delivery Service availability used for routing.
+// The CRStates MUST NOT be modified after they're set.
+//
+// The CRStates are shared with every snapshot built from sh with NewWithCRConfig, including one being built or swapped in concurrently,
+// so a poll is never lost to a CRConfig swap.
+//
+// Safe for use by pollers while handlers are serving.
+//
 func (sh *Shared) SetCRStates(crStates *tc.CRStates) {
-	ptr := (unsafe.Pointer)(crStates)
-	atomic.StorePointer(sh.crStates, ptr)
+	hl := &health{crStates: crStates, availability: BuildAvailabilityFromCRStates(crStates)}
+	atomic.StorePointer(sh.health, (unsafe.Pointer)(hl))
 }
 
 // GetCRConfig gets the Content Router Config (CRConfig).
@@ -89,31 +145,43 @@ func (sh *Shared) SetCRConfig(crConfig *tc.CRConfig) {
 }
 
 // NewShared creates a new Shared data object.
+// The dnssecKeys may be nil, in which case DNSSEC is disabled.
+// The deepCZF may be nil, in which case deep caching is disabled.
+// The geoProviders may be empty, in which case clients not in the czf can't be routed.
 // Logs all errors, fatal and non-fatal.
 // On fatal error, returns nil
-func NewShared(czf *czf.ParsedCZF, crc *tc.CRConfig, crs *tc.CRStates, certs map[string]*tls.Certificate) *Shared {
-	// TODO pre fetch and cache this, for performance. This is in the request path.
-	//      Also, validate. Make sure it exists, is a valid FQDN, not empty, etc.
-	iCDNDomain, ok := crc.Config["domain_name"] // : "top.comcast.net",
-	if !ok {
-		fmt.Printf("ERROR: GetServerForDomain: CRConfig missing config/domain_name, cannot serve!\n")
-		// TODO validate on load, and refuse to load CRConfig
-		return nil
+func NewShared(czf *czf.ParsedCZF, deepCZF *deepczf.ParsedDeepCZF, geoProviders geo.Providers, crc *tc.CRConfig, crs *tc.CRStates, certs map[string]*tls.Certificate, dnssecKeys *dnssec.Keys) *Shared {
+	signer := (*dnssec.Signer)(nil)
+	if dnssecKeys != nil {
+		signer = dnssec.NewSigner(dnssecKeys)
 	}
-	cdnDomain, ok := iCDNDomain.(string)
-	if !ok {
-		fmt.Printf("ERROR: GetServerForDomain: CRConfig config/domain_name not a string, cannot serve!\n")
-		// TODO validate on load, and refuse to load CRConfig
+	sh, err := newShared(czf, deepCZF, geoProviders, crc, new(unsafe.Pointer), certs, signer)
+	if err != nil {
+		fmt.Println("ERROR: NewShared: " + err.Error() + ", cannot serve!")
 		return nil
 	}
-
-	sh := &Shared{czf: czf, cdnDomain: cdnDomain}
 	sh.SetCRStates(crs)
+	return sh
+}
+
+// newShared builds a new Shared data object. The signer may be nil, in which case DNSSEC is disabled.
+// The healthPtr is the *health pointer to share, which the caller must set if it's new.
+// Logs non-fatal errors, such as malformed Delivery Services, which are omitted without preventing the others from being served.
+// Returns an error if the CRConfig is invalid, and can't be served at all.
+func newShared(czf *czf.ParsedCZF, deepCZF *deepczf.ParsedDeepCZF, geoProviders geo.Providers, crc *tc.CRConfig, healthPtr *unsafe.Pointer, certs map[string]*tls.Certificate, signer *dnssec.Signer) (*Shared, error) {
+	cdnDomain, err := ValidateCRConfig(crc)
+	if err != nil {
+		return nil, errors.New("invalid CRConfig: " + err.Error())
+	}
+	if signer != nil && !strings.EqualFold(signer.Zone(), cdnDomain+".") {
+		return nil, errors.New("DNSSEC keys are for zone '" + signer.Zone() + "', but the CRConfig domain is '" + cdnDomain + "'")
+	}
+
+	sh := &Shared{czf: czf, deepCZF: deepCZF, geo: geoProviders, cdnDomain: cdnDomain, signer: signer, crConfig: new(unsafe.Pointer), health: healthPtr}
 	sh.SetCRConfig(crc)
 
-	err := error(nil)
 	if sh.dnsMatches, sh.httpDNSMatches, err = BuildMatchesFromCRConfig(crc, cdnDomain); err != nil {
-		fmt.Printf("Error building DS Matches from CRConfig: " + err.Error())
+		fmt.Println("Error building DS Matches from CRConfig: " + err.Error())
 	}
 
 	sh.httpSecondDNSMatches = BuildHTTPSecondDNSMatches(crc, cdnDomain)
@@ -121,19 +189,50 @@ func NewShared(czf *czf.ParsedCZF, crc *tc.CRConfig, crs *tc.CRStates, certs map
 	dsServers, err := BuildDSServersFromCRConfig(crc)
 	sh.dsServers = dsServers
 	if err != nil {
-		fmt.Printf("Error building DS Servers from CRConfig: " + err.Error())
+		fmt.Println("Error building DS Servers from CRConfig: " + err.Error())
 	}
 
+	sh.cgFallbacks = BuildCGFallbacksFromCRConfig(crc)
+	sh.edgeLocations = crc.EdgeLocations
+	sh.cgLocations = buildCGLocations(crc.EdgeLocations)
+
 	cgRouters, err := BuildCGRoutersFromCRConfig(crc)
 	sh.cgRouters = cgRouters
+	sh.allRouters = ringCache{}.addRings(combineCGRouters(cgRouters))
 	if err != nil {
-		fmt.Printf("Error building CG Routers from CRConfig: " + err.Error())
+		fmt.Println("Error building CG Routers from CRConfig: " + err.Error())
 	}
 
-	sh.serverAvailable = BuildServerAvailableFromCRStates(crs)
+	nameServers, err := BuildNameServersFromCRConfig(crc, cdnDomain)
+	sh.nameServers = nameServers
+	if err != nil {
+		fmt.Println("Error building Name Servers from CRConfig: " + err.Error())
+	}
+	sh.nameServerMatches = map[string]NameServer{}
+	for _, ns := range nameServers.Servers {
+		sh.nameServerMatches[ns.FQDN] = ns
+	}
+	sh.soa = BuildSOAFromCRConfig(crc, cdnDomain, nameServers)
+
+	staticDNSEntries, err := BuildStaticDNSEntriesFromCRConfig(crc, cdnDomain)
+	sh.staticDNSEntries = staticDNSEntries
+	if err != nil {
+		fmt.Println("Error building Static DNS Entries from CRConfig: " + err.Error())
+	}
+
+	sh.emptyNonTerminals = BuildEmptyNonTerminals(cdnDomain, literalNames([]DSMatches{sh.dnsMatches, sh.httpDNSMatches}, sh.httpSecondDNSMatches, nameServers, staticDNSEntries))
+
+	sh.dnskeyTTL = configTTL(crc, "DNSKEY", DefaultDNSKEYTTL)
+	sh.defaultTTLs = BuildDefaultTTLsFromCRConfig(crc)
+	dsConfigs, err := BuildDSConfigsFromCRConfig(crc, sh.defaultTTLs)
+	sh.dsConfigs = dsConfigs
+	if err != nil {
+		fmt.Println("Error building DS Configs from CRConfig: " + err.Error())
+	}
+	sh.deepServers = BuildDeepServers(deepCZF, sh.dsConfigs, sh.dsServers)
 
 	sh.certs = certs
-	return sh
+	return sh, nil
 }
 
 // GetCZF gets the Coverage Zone File (CZF).
@@ -145,6 +244,15 @@ func (sh *Shared) GetCZF() *czf.ParsedCZF {
 	return sh.czf
 }
 
+// GetDeepCZF gets the Deep Coverage Zone File, or nil if deep caching is disabled.
+// The returned Deep CZF MUST NOT be modified.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetDeepCZF() *deepczf.ParsedDeepCZF {
+	return sh.deepCZF
+}
+
 func (sh *Shared) GetCerts() map[string]*tls.Certificate {
 	return sh.certs
 }
@@ -160,16 +268,22 @@ type DNSDS struct {
 // DNSDSServers contains hash rings of servers to route to, for a certain deliveryservice and cachegroup.
 // These have already been hashed, but will need checked for health before sending clients to them.
 // If a server is unhealthy, the next server in the hash ring should be used instead.
-// TODO sort by hash
 type DNSDSServers struct {
 	V4s []DNSDSServer
 	V6s []DNSDSServer
+	// V4Ring is the consistent hash ring of V4s. Its server indices are indices into V4s.
+	V4Ring *chash.Ring
+	// V6Ring is the consistent hash ring of V6s. Its server indices are indices into V6s.
+	V6Ring *chash.Ring
 }
 
 type DNSDSServer struct {
 	HostName tc.CacheName
 	Addr     string         // Addr is the IPv4 or IPv6 address of the server, to be returned via DNS.
 	Status   tc.CacheStatus // Status is the TO Status (Reported, ONLINE, etc) - TODO change to int, faster compare?
+	// HashID and HashCount are the server's CRConfig hashId and hashCount, which determine its points on the hash ring.
+	HashID    string
+	HashCount int
 }
 
 type DSAndMatch struct {
@@ -305,13 +419,21 @@ func BuildDSServersFromCRConfig(crc *tc.CRConfig) (map[tc.DeliveryServiceName]ma
 			errStrs = append(errStrs, "CRConfig server '"+serverName+"' has nil cachegroup, skipping!")
 			continue
 		}
-		if (server.Ip == nil && *server.Ip != "") && (server.Ip6 == nil && *server.Ip6 != "") {
+		if (server.Ip == nil || *server.Ip == "") && (server.Ip6 == nil || *server.Ip6 == "") {
 			errStrs = append(errStrs, "CRConfig server '"+serverName+"' has nil ip and ip6, skipping!")
 			continue
 		}
 		if tc.CacheStatus(*server.ServerStatus) != tc.CacheStatusReported && tc.CacheStatus(*server.ServerStatus) != tc.CacheStatusOnline {
 			continue
 		}
+		hashID := serverName
+		if server.HashId != nil && *server.HashId != "" {
+			hashID = *server.HashId
+		}
+		hashCount := chash.DefaultHashCount
+		if server.HashCount != nil && *server.HashCount > 0 {
+			hashCount = *server.HashCount
+		}
 		for dsNameStr, _ := range server.DeliveryServices {
 			dsName := tc.DeliveryServiceName(dsNameStr)
 			if dsServers[dsName] == nil {
@@ -326,9 +448,11 @@ func BuildDSServersFromCRConfig(crc *tc.CRConfig) (map[tc.DeliveryServiceName]ma
 					cg := tc.CacheGroupName(*server.CacheGroup)
 					dsServer := dsServers[dsName][cg]
 					dsServer.V4s = append(dsServer.V4s, DNSDSServer{
-						HostName: tc.CacheName(serverName),
-						Addr:     ip.String(),
-						Status:   tc.CacheStatus(*server.ServerStatus),
+						HostName:  tc.CacheName(serverName),
+						Addr:      ip.String(),
+						Status:    tc.CacheStatus(*server.ServerStatus),
+						HashID:    hashID,
+						HashCount: hashCount,
 					})
 					dsServers[dsName][cg] = dsServer
 				}
@@ -342,15 +466,23 @@ func BuildDSServersFromCRConfig(crc *tc.CRConfig) (map[tc.DeliveryServiceName]ma
 					cg := tc.CacheGroupName(*server.CacheGroup)
 					dsServer := dsServers[dsName][cg]
 					dsServer.V6s = append(dsServer.V6s, DNSDSServer{
-						HostName: tc.CacheName(serverName),
-						Addr:     ip.String(),
-						Status:   tc.CacheStatus(*server.ServerStatus),
+						HostName:  tc.CacheName(serverName),
+						Addr:      ip.String(),
+						Status:    tc.CacheStatus(*server.ServerStatus),
+						HashID:    hashID,
+						HashCount: hashCount,
 					})
 					dsServers[dsName][cg] = dsServer
 				}
 			}
 		}
 	}
+	rings := ringCache{}
+	for _, cgServers := range dsServers {
+		for cg, servers := range cgServers {
+			cgServers[cg] = rings.addRings(servers)
+		}
+	}
 	err := error(nil)
 	if len(errStrs) > 0 {
 		err = errors.New(strings.Join(errStrs, "\n"))
@@ -390,9 +522,11 @@ func BuildCGRoutersFromCRConfig(crc *tc.CRConfig) (map[tc.CacheGroupName]DNSDSSe
 				cg := tc.CacheGroupName(*router.Location)
 				cgRouter := cgRouters[cg]
 				cgRouter.V4s = append(cgRouter.V4s, DNSDSServer{
-					HostName: tc.CacheName(routerName),
-					Addr:     ip.String(),
-					Status:   tc.CacheStatus(*router.ServerStatus),
+					HostName:  tc.CacheName(routerName),
+					Addr:      ip.String(),
+					Status:    tc.CacheStatus(*router.ServerStatus),
+					HashID:    routerName,
+					HashCount: chash.DefaultHashCount,
 				})
 				cgRouters[cg] = cgRouter
 			}
@@ -406,9 +540,11 @@ func BuildCGRoutersFromCRConfig(crc *tc.CRConfig) (map[tc.CacheGroupName]DNSDSSe
 				cg := tc.CacheGroupName(*router.Location)
 				cgRouter := cgRouters[cg]
 				cgRouter.V6s = append(cgRouter.V6s, DNSDSServer{
-					HostName: tc.CacheName(routerName),
-					Addr:     ip.String(),
-					Status:   tc.CacheStatus(*router.ServerStatus),
+					HostName:  tc.CacheName(routerName),
+					Addr:      ip.String(),
+					Status:    tc.CacheStatus(*router.ServerStatus),
+					HashID:    routerName,
+					HashCount: chash.DefaultHashCount,
 				})
 				cgRouters[cg] = cgRouter
 			}
@@ -429,119 +565,200 @@ func ParseIPOrCIDR(str string) net.IP {
 	return ip
 }
 
-func BuildServerAvailableFromCRStates(crs *tc.CRStates) map[tc.CacheName]bool {
-	avail := map[tc.CacheName]bool{}
-	for cacheName, isAvail := range crs.Caches {
-		avail[cacheName] = isAvail.IsAvailable
-	}
-	return avail
-}
-
-// GetServerForDomain returns the IP of the cache, the cache hostname, the DS name, whether to immediately return a refused (because the domain was not in the DS list), and whether to return a SERVFAIL (because there was a server error looking up the DS). Error messages are logged.
-// TODO NXDOMAIN instead of refusing if it's a CDN domain e.g. top.comcast.net but just a nonexistent DS?
-// TODO change to return multiple IPs, depending on DS configuration.
-func (sh *Shared) GetServerForDomain(addr net.Addr, zone string, domain string, v4 bool) (string, string, string, bool, bool) {
+// GetServerForDomain returns the caches to return to the client, the DS name, the TTL of the answer, and the Result of the lookup. Error messages are logged.
+//
+// For DNS Delivery Services, this is up to the DS maxDnsIpsForLocation available caches in the client's cachegroup.
+// For everything else, it's a single server: the Traffic Router for HTTP Delivery Services, or the named server for cache and name server FQDNs.
+//
+// The Result is ResultRefused if the domain isn't in the CDN domain,
+// ResultNXDomain if it's in the CDN domain but doesn't exist,
+// ResultNoData if it exists but has no address of the requested type,
+// ResultBypass if no cache is available for a DNS DS and the client should be sent to the DS DNS bypass destination,
+// and ResultServFail if there was a server error looking up the DS.
+// The client is only localized if the domain is a DNS Delivery Service, so invalid and nonexistent names cost no location lookup.
+// The TTL is the Delivery Service's A or AAAA ttl, or the CRConfig config/ttls for names which aren't in a Delivery Service.
+// If the Result is not ResultOK, the returned servers are empty. If it's ResultBypass, the DS name and TTL are still returned.
+//
+func (sh *Shared) GetServerForDomain(cl *Client, domain string, v4 bool) ([]DNSDSServer, string, uint32, Result) {
 	if !strings.HasSuffix(domain, ".") {
-		fmt.Printf("EVENT: Request: %v czf zone '%v' requested A '%v' missing trailing '.' - returning Refused\n", addr.String(), zone, domain)
-		return "", "", "", true, false // "", refuse, no servfail
+		fmt.Printf("EVENT: Request: %v requested A '%v' missing trailing '.' - returning Refused\n", cl.Addr.String(), domain)
+		return nil, "", 0, ResultRefused
 	}
 	domain = domain[:len(domain)-1] // remove trailing . because we want to match without it
-	if !strings.HasSuffix(domain, sh.cdnDomain) {
-		fmt.Printf("EVENT: Request: %v czf zone '%v' requested A '%v' which we're not authoritative for, returning Refused\n", addr.String(), zone, domain)
-		return "", "", "", true, false // "", refuse, no servfail
+	if !sh.inCDNDomain(domain) {
+		fmt.Printf("EVENT: Request: %v requested A '%v' which we're not authoritative for, returning Refused\n", cl.Addr.String(), domain)
+		return nil, "", 0, ResultRefused
 	}
 
 	// fastest lookup, so we do it first.
 	// TODO change to a trie, even faster.
-	if cacheName, ok := sh.httpSecondDNSMatches[domain]; ok {
-		return sh.GetServerName(cacheName, v4)
+	if secondMatch, ok := sh.httpSecondDNSMatches[domain]; ok {
+		return sh.GetServerName(secondMatch, v4)
+	}
+	if ns, ok := sh.nameServerMatches[domain]; ok {
+		return sh.GetNameServerAddr(ns, v4)
 	}
 	if dsName, ok := sh.dnsMatches.Match(domain); ok {
-		return sh.GetServerForDomainDNS(addr, zone, domain, v4, dsName)
+		return sh.GetServerForDomainDNS(cl, domain, v4, dsName)
 	}
 	if dsName, ok := sh.httpDNSMatches.Match(domain); ok {
-		return sh.GetServerForDomainHTTP(addr, zone, domain, v4, dsName)
+		return sh.GetServerForDomainHTTP(cl, domain, v4, dsName)
+	}
+
+	if sh.nameExistsWithoutAddrs(domain) {
+		fmt.Printf("EVENT: Request: %v requested A '%v' - name exists but has no addresses, returning NoData\n", cl.Addr.String(), domain)
+		return nil, "", 0, ResultNoData
 	}
 
-	fmt.Printf("EVENT: Request: %v czf zone '%v' requested A '%v' - no DS match, returning Refused\n", addr.String(), zone, domain)
-	return "", "", "", true, false // "", refuse, no servfail
+	fmt.Printf("EVENT: Request: %v requested A '%v' - no DS match, returning NXDomain\n", cl.Addr.String(), domain)
+	return nil, "", 0, ResultNXDomain
 }
 
-func (sh *Shared) GetServerName(cacheName tc.CacheName, v4 bool) (string, string, string, bool, bool) {
-	// this is used for the second DNS lookup after an HTTP DS 302,
-	// so this will never be used by the HTTP Server, and the DNS server doesn't need a Names.
-	// TODO fix anyway, for safety. Make httpSecondDNSMatches contain the DS Name?
-	dsName := ""
+// GetServerName returns the address of the matched cache, for the second DNS lookup after an HTTP DS 302.
+// The TTL is that of the HTTP DS the cache was redirected to for.
+func (sh *Shared) GetServerName(secondMatch SecondDNSMatch, v4 bool) ([]DNSDSServer, string, uint32, Result) {
+	cacheName := secondMatch.CacheName
+	ttl := sh.GetTTLs(secondMatch.DSName).Addr(v4)
 	// TODO make faster. This is in the request path, and can be easily optimized.
 	sv, ok := sh.GetCRConfig().ContentServers[string(cacheName)]
 	if !ok {
 		// Should never happen. Maybe unless the CRConfig is malformed?
 		// TODO log
-		return "", "", "", false, true // "", no refuse, servfail
+		return nil, "", 0, ResultServFail
 	}
 	if v4 {
 		if sv.Ip == nil {
-			fmt.Printf("ERROR: client requested cache.ds.cdn A for server '%v' with no IPv4 address, returning Refused\n", string(cacheName))
-			return "", "", "", true, false // "", refuse, no servfail
+			fmt.Printf("ERROR: client requested cache.ds.cdn A for server '%v' with no IPv4 address, returning NoData\n", string(cacheName))
+			return nil, "", 0, ResultNoData
 		}
 		// TODO parse IP to verify. Super-important, we REALLY don't want to give non-IPs to A reqs and heinously violate the DNS specs
-		return *sv.Ip, dsName, string(cacheName), false, false // ip, no refuse, no servfail
+		return []DNSDSServer{{HostName: cacheName, Addr: *sv.Ip}}, string(secondMatch.DSName), ttl, ResultOK
 	}
 
 	if sv.Ip6 == nil {
-		fmt.Printf("ERROR: client requested cache.ds.cdn AAAA for server '%v' with no IPv6 address, returning Refused\n", string(cacheName))
-		return "", "", "", true, false // "", refuse, no servfail
+		fmt.Printf("ERROR: client requested cache.ds.cdn AAAA for server '%v' with no IPv6 address, returning NoData\n", string(cacheName))
+		return nil, "", 0, ResultNoData
 	}
 	// TODO parse IP to verify. Super-important, we REALLY don't want to give non-IPs to A reqs and heinously violate the DNS specs
-	return *sv.Ip6, string(cacheName), dsName, false, false // ip, no refuse, no servfail
+	return []DNSDSServer{{HostName: cacheName, Addr: *sv.Ip6}}, string(secondMatch.DSName), ttl, ResultOK
+}
+
+// GetNameServerAddr returns the address of the given name server, for A and AAAA requests for the NS targets of the CDN domain.
+// The TTL is the NS TTL, the same as the glue.
+func (sh *Shared) GetNameServerAddr(ns NameServer, v4 bool) ([]DNSDSServer, string, uint32, Result) {
+	ip := ns.IP
+	if !v4 {
+		ip = ns.IP6
+	}
+	if ip == nil {
+		fmt.Printf("EVENT: client requested name server '%v' IPv4=%v, but it has no address of that type, returning NoData\n", ns.FQDN, v4)
+		return nil, "", 0, ResultNoData
+	}
+	return []DNSDSServer{{HostName: tc.CacheName(ns.FQDN), Addr: ip.String()}}, "", sh.nameServers.TTL, ResultOK
 }
 
+// GetServerForDomainDNS returns the caches for the given DNS Delivery Service request, localizing the client if the DS is available.
+// If the DS has ECS enabled, the client is localized by its ECS address, if it has one.
 func (sh *Shared) GetServerForDomainDNS(
-	addr net.Addr,
-	zone string,
+	cl *Client,
 	domain string,
 	v4 bool,
 	dsName tc.DeliveryServiceName,
-) (string, string, string, bool, bool) {
-	dsServers, ok := sh.dsServers[dsName]
-	if !ok {
-		// should never happen (we found a match, but it wasn't in the list of ds servers
-		fmt.Printf("EVENT: Request: %v czf zone %v requested A '%v' ds '%v' - match, but not in dsServers! should never happen! Returning ServFail\n", addr.String(), zone, domain, dsName, ok)
-		return "", "", "", false, true // "", no refuse, servfail
+) ([]DNSDSServer, string, uint32, Result) {
+	if _, ok := sh.dsServers[dsName]; !ok {
+		// the DS has no ONLINE or REPORTED servers in the CRConfig.
+		fmt.Printf("EVENT: Request: %v requested A '%v' ds '%v' - match, but not in dsServers! Returning ServFail or bypass\n", cl.Addr.String(), domain, dsName)
+		return sh.getDNSBypass(cl, domain, v4, dsName)
+	}
+	avail := sh.GetAvailability()
+	if !avail.DSAvailable(dsName) {
+		fmt.Printf("EVENT: Request: %v requested A '%v' ds '%v' - match, but the ds is unavailable in the CRStates! Returning ServFail or bypass\n", cl.Addr.String(), domain, dsName)
+		return sh.getDNSBypass(cl, domain, v4, dsName)
 	}
 
-	cg := tc.CacheGroupName(zone)
-	cgServers, ok := dsServers[cg]
-	if !ok {
-		// we found a match, but there were no servers in the found cachegroup with an Edge on this DS.
-		fmt.Printf("EVENT: Request: %v czf zone %v requested A '%v' ds '%v' - match, but the requested DS had no servers in the matched cachegroup! Returning ServFail", addr.String(), zone, domain, dsName)
-		return "", "", "", false, true // "", no refuse, servfail
+	dsCfg := sh.dsConfigs[dsName]
+	loc := sh.localize(cl, dsCfg.ECSEnabled)
+
+	// DNS DSes hash the FQDN, so all requests for the same name go to the same caches.
+	servers, cg, hops := sh.getServersWithFallback(avail, dsName, tc.CacheGroupName(loc.Zone), loc.IP, v4, dsCfg.MaxDNSIPs, domain)
+	if len(servers) == 0 {
+		// we found a match, but there were no available servers of the requested IP type in the cg or its fallbacks on the DS.
+		fmt.Printf("EVENT: Request: %v czf zone %v requested A %v ds '%v' - match, but no available servers of type IPv4=%v in the cg or its fallbacks on the ds! Returning ServFail or bypass\n", cl.Addr.String(), loc.Zone, domain, dsName, v4)
+		return sh.getDNSBypass(cl, domain, v4, dsName)
 	}
 
-	dsServer, ok := getServer(cgServers, v4, sh.serverAvailable)
+	// hops is how many fallbacks were taken to get to cg, 0 if it's the client's own.
+	fmt.Printf("EVENT: Request: '%v' czf zone '%v' requested A '%v' ds '%v' cg '%v' fallback hops %v matched servers '%+v', returning\n", cl.Addr.String(), loc.Zone, domain, dsName, cg, hops, servers)
+
+	return servers, string(dsName), sh.GetTTLs(dsName).Addr(v4), ResultOK
+}
+
+// GetServerForHTTP returns the cache to redirect an HTTP Delivery Service request to, the DS name, and the Result of the lookup.
+// The domain is the requested Host, without a trailing period.
+//
+// The cache is chosen by consistent hash of the request path and the DS consistentHashQueryParams,
+// after applying the DS consistentHashRegex, so requests for the same content go to the same cache.
+// If v4, the cache is chosen from those with IPv4 addresses, otherwise IPv6, so the client can reach it.
+// The client is only localized once the domain matches an available HTTP DS.
+//
+// The Result is ResultRefused if the domain isn't in the CDN domain, ResultNXDomain if it isn't an HTTP Delivery Service,
+// and ResultServFail if the DS has no available cache.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetServerForHTTP(cl *Client, domain string, reqURL *url.URL, v4 bool) (DNSDSServer, string, Result) {
+	if !sh.inCDNDomain(domain) {
+		fmt.Printf("EVENT: Request: %v requested HTTP '%v' which we're not authoritative for, returning Refused\n", cl.Addr.String(), domain)
+		return DNSDSServer{}, "", ResultRefused
+	}
+	dsName, ok := sh.httpDNSMatches.Match(domain)
 	if !ok {
-		// we found a match, but there were no servers of the requested IP type on the CG assigned to the DS.
-		fmt.Printf("EVENT: Request: %v czf zone %v requested A %v ds '%v' - match, but no servers of type IPv4=%v in the cg on the ds! Returning ServFail\n", addr.String(), zone, domain, dsName, ok, v4)
-		return "", "", "", false, true // "", no refuse, servfail
+		fmt.Printf("EVENT: Request: %v requested HTTP '%v' - no HTTP DS match, returning NXDomain\n", cl.Addr.String(), domain)
+		return DNSDSServer{}, "", ResultNXDomain
 	}
+	avail := sh.GetAvailability()
+	if !avail.DSAvailable(dsName) {
+		fmt.Printf("EVENT: Request: %v requested HTTP '%v' ds '%v' - match, but the ds is unavailable in the CRStates! Returning ServFail\n", cl.Addr.String(), domain, dsName)
+		return DNSDSServer{}, "", ResultServFail
+	}
+	loc := sh.localize(cl, false) // HTTP DSes don't use ECS, the client made the request itself
+	hashKey := buildHTTPHashKey(sh.dsConfigs[dsName], reqURL)
+	servers, cg, hops := sh.getServersWithFallback(avail, dsName, tc.CacheGroupName(loc.Zone), loc.IP, v4, 1, hashKey)
+	if len(servers) == 0 {
+		fmt.Printf("EVENT: Request: %v czf zone '%v' requested HTTP '%v' ds '%v' - match, but no available servers of type IPv4=%v in the cg or its fallbacks on the ds! Returning ServFail\n", cl.Addr.String(), loc.Zone, domain, dsName, v4)
+		return DNSDSServer{}, "", ResultServFail
+	}
+	fmt.Printf("EVENT: Request: '%v' czf zone '%v' requested HTTP '%v' hash key '%v' ds '%v' cg '%v' fallback hops %v matched server '%+v', returning\n", cl.Addr.String(), loc.Zone, domain, hashKey, dsName, cg, hops, servers[0])
+	return servers[0], string(dsName), ResultOK
+}
 
-	fmt.Printf("EVENT: Request: '%v' czf zone '%v' requested A '%v' ds '%v' matched server '%+v', returning\n", addr.String(), zone, domain, dsName, dsServer)
-
-	return dsServer.Addr, string(dsServer.HostName), string(dsName), false, false // addr, no refuse, no servfail
+// getDNSBypass returns ResultBypass and the bypass TTL if the DNS DS has a bypass destination, for when no cache is available.
+// Otherwise, returns ResultServFail.
+func (sh *Shared) getDNSBypass(cl *Client, domain string, v4 bool, dsName tc.DeliveryServiceName) ([]DNSDSServer, string, uint32, Result) {
+	bypass := sh.GetDNSBypass(dsName)
+	if bypass == nil {
+		return nil, "", 0, ResultServFail
+	}
+	ttl := sh.GetTTLs(dsName).Addr(v4)
+	if bypass.TTL != nil {
+		ttl = *bypass.TTL
+	}
+	fmt.Printf("BYPASS: Request: '%v' requested A '%v' ds '%v' IPv4=%v - no available servers, returning bypass ip '%v' ip6 '%v' cname '%v'\n", cl.Addr.String(), domain, dsName, v4, bypass.IP, bypass.IP6, bypass.CNAME)
+	return nil, string(dsName), ttl, ResultBypass
 }
 
 // GetServerForDomainHTTP returns the server IP to return to the client, for the given HTTP DS' initial DNS request.
 //
 // Because it's an HTTP DS, the initial DNS request returns the IP of a Traffic Router
 // (which will then be requested over HTTP by the client, and which will return a 302 to a cache).
+// Routers are chosen by hash, not location, so the client isn't localized.
 //
 func (sh *Shared) GetServerForDomainHTTP(
-	addr net.Addr,
-	zone string,
+	cl *Client,
 	domain string,
 	v4 bool,
 	dsName tc.DeliveryServiceName,
-) (string, string, string, bool, bool) {
+) ([]DNSDSServer, string, uint32, Result) {
 	// TODO add geolocation of routers
 	routersMap := sh.GetCRConfig().ContentRouters
 	routers := []tc.CRConfigRouter{}
@@ -550,84 +767,77 @@ func (sh *Shared) GetServerForDomainHTTP(
 	}
 	if len(routers) == 0 {
 		// TODO log
-		return "", "", "", false, true // "", no refuse, servfail - no routers = servfail. Also wtf, we're a router!?!
+		return nil, "", 0, ResultServFail // no routers = servfail. Also wtf, we're a router!?!
 	}
 
 	// If Routers had regular cache CGs, we could get the right CG here.
 	// Since they don't, put all CGs in one big array to get.
 	// TODO put self first (since the client got to us first in DNS, self should be nearest)
 
-	allRouters := combineCGRouters(sh.cgRouters)
-	router, ok := getRouter(allRouters, v4)
+	router, ok := getRouter(sh.allRouters, v4, domain)
 
 	if !ok {
-		fmt.Printf("EVENT: Request: '%v' czf zone '%v' requested A '%v' http ds '%v' matched no router, returning servfail!\n", addr.String(), zone, domain, dsName)
-		return "", "", "", false, true // "", no refuse, servfail
+		fmt.Printf("EVENT: Request: '%v' requested A '%v' http ds '%v' matched no router, returning servfail!\n", cl.Addr.String(), domain, dsName)
+		return nil, "", 0, ResultServFail
 	}
 	// TODO add Fallback CG failover
 	// TODO if Fallback also fails, return self. Obviously.
 
-	fmt.Printf("EVENT: Request: '%v' czf zone '%v' requested A '%v' http ds '%v' matched router server '%+v', returning\n", addr.String(), zone, domain, dsName, router)
+	fmt.Printf("EVENT: Request: '%v' requested A '%v' http ds '%v' matched router server '%+v', returning\n", cl.Addr.String(), domain, dsName, router)
 
-	return router.Addr, string(router.HostName), string(dsName), false, false // addr, no refuse, no servfail
+	return []DNSDSServer{router}, string(dsName), sh.GetTTLs(dsName).Addr(v4), ResultOK
 }
 
-// getServer finds a server from the list, for the given IP type.
-// TODO consistent-hash DNSDSServers.
-// TODO use fallback CG if cg is unavailable.
-func getServer(allServers DNSDSServers, v4 bool, serverAvailable map[tc.CacheName]bool) (DNSDSServer, bool) {
-	servers := allServers.V4s
+// getServers returns up to max available servers from the list, for the given IP type. If max is 0, all available servers are returned.
+//
+// The servers are chosen by consistent hash of hashKey, walking the hash ring past unavailable servers.
+// So, requests for the same content consistently go to the same servers, and only move when those servers become unavailable.
+//
+func getServers(allServers DNSDSServers, v4 bool, avail *Availability, max int, hashKey string) []DNSDSServer {
+	return getServersWhere(allServers, v4, max, hashKey, avail.CacheAvailable)
+}
+
+// getServersWhere is getServers, returning the servers for which isAvailable returns true.
+func getServersWhere(allServers DNSDSServers, v4 bool, max int, hashKey string, isAvailable func(tc.CacheName) bool) []DNSDSServer {
+	servers, ring := allServers.V4s, allServers.V4Ring
 	if !v4 {
-		servers = allServers.V6s
+		servers, ring = allServers.V6s, allServers.V6Ring
 	}
-	// fmt.Printf("DEBUG servers '%v' v4 %v server '%v'\n", allServers, v4, servers)
 	if len(servers) == 0 {
-		return DNSDSServer{}, false
-	} else if len(servers) == 1 {
-		return servers[0], true // must check, because math.Intn(0) panics.
+		return nil
 	}
-
-	randI := rand.Intn(len(servers) - 1)
-	startI := randI
-	sv := servers[randI]
-	for {
-		if serverAvailable[sv.HostName] {
-			break
-		}
-		randI++
-		if randI == len(servers) {
-			randI = 0 // loop around if we're at the end
-		}
-		if randI == startI {
-			return DNSDSServer{}, false // we looped over all servers, and none were available
-		}
+	if max <= 0 || max > len(servers) {
+		max = len(servers)
 	}
 
-	return servers[randI], true
+	available := make([]DNSDSServer, 0, max)
+	ring.Walk(chash.Hash(hashKey), func(serverI int) bool {
+		sv := servers[serverI]
+		if isAvailable(sv.HostName) {
+			available = append(available, sv)
+		}
+		return len(available) < max
+	})
+	return available
 }
 
-func getRouter(allServers DNSDSServers, v4 bool) (DNSDSServer, bool) {
-	servers := allServers.V4s
+// getRouter returns the router for hashKey by consistent hash, for the given IP type.
+func getRouter(allServers DNSDSServers, v4 bool, hashKey string) (DNSDSServer, bool) {
+	servers, ring := allServers.V4s, allServers.V4Ring
 	if !v4 {
-		servers = allServers.V6s
-	}
-	// fmt.Printf("DEBUG servers '%v' v4 %v server '%v'\n", allServers, v4, servers)
-	if len(servers) == 0 {
-		return DNSDSServer{}, false
-	} else if len(servers) == 1 {
-		return servers[0], true // must check, because math.Intn(0) panics.
+		servers, ring = allServers.V6s, allServers.V6Ring
 	}
-
-	randI := rand.Intn(len(servers) - 1) // TODO change rand to consistent hash
-
 	// TODO if we ever have Router health, it would be added here (like it is for the corresponding cache func)
-
-	return servers[randI], true
+	router := DNSDSServer{}
+	ok := false
+	ring.Walk(chash.Hash(hashKey), func(serverI int) bool {
+		router, ok = servers[serverI], true
+		return false
+	})
+	return router, ok
 }
 
 func combineCGRouters(routers map[tc.CacheGroupName]DNSDSServers) DNSDSServers {
-	// TODO this is done in the request path, and is unnecessary, and slow.
-	//      If this stick around, it should be done when the CRConfig is fetched, and cached.
 	svs := DNSDSServers{}
 	for _, router := range routers {
 		svs.V4s = append(svs.V4s, router.V4s...)
@@ -636,7 +846,13 @@ func combineCGRouters(routers map[tc.CacheGroupName]DNSDSServers) DNSDSServers {
 	return svs
 }
 
-// BuildHTTPSecondDNSMatches returns a map[fqdn]cache for the DNS FQDNs for HTTP Delivery Services.
+// SecondDNSMatch is the cache and HTTP Delivery Service of a cache-name.ds-name.cdn-domain FQDN.
+type SecondDNSMatch struct {
+	CacheName tc.CacheName
+	DSName    tc.DeliveryServiceName
+}
+
+// BuildHTTPSecondDNSMatches returns a map[fqdn]match for the DNS FQDNs for HTTP Delivery Services.
 //
 // That is, for an HTTP DS, a client
 // 1. Makes a DNS request to TR, and gets an IP back of TR itself
@@ -647,13 +863,13 @@ func combineCGRouters(routers map[tc.CacheGroupName]DNSDSServers) DNSDSServers {
 //
 // The matches here are for step 4, the second DNS lookup of an HTTP DS, for cache-name.ds-name.cdn-domain.
 //
-func BuildHTTPSecondDNSMatches(crc *tc.CRConfig, cdnDomain string) map[string]tc.CacheName {
-	matches := map[string]tc.CacheName{}
+func BuildHTTPSecondDNSMatches(crc *tc.CRConfig, cdnDomain string) map[string]SecondDNSMatch {
+	matches := map[string]SecondDNSMatch{}
 	for svName, sv := range crc.ContentServers {
 		for dsName, _ := range sv.DeliveryServices {
 			// TODO only include HTTP DSes, exclude DNS DSes here.
-			fqdn := svName + "." + dsName + "." + cdnDomain
-			matches[fqdn] = tc.CacheName(svName)
+			fqdn := strings.ToLower(svName + "." + dsName + "." + cdnDomain)
+			matches[fqdn] = SecondDNSMatch{CacheName: tc.CacheName(svName), DSName: tc.DeliveryServiceName(dsName)}
 		}
 	}
 	return matches
@@ -0,0 +1,107 @@
+package shared
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+)
+
+// loadTestCRConfig loads the test CRConfig, with DNS DS dnsds and HTTP DS httpds in the CDN domain cdn.example.net.
+func loadTestCRConfig(t *testing.T) *tc.CRConfig {
+	t.Helper()
+	bts, err := ioutil.ReadFile("testdata/crconfig.json")
+	if err != nil {
+		t.Fatalf("reading test CRConfig: %v", err)
+	}
+	crc := &tc.CRConfig{}
+	if err := json.Unmarshal(bts, crc); err != nil {
+		t.Fatalf("decoding test CRConfig: %v", err)
+	}
+	return crc
+}
+
+// loadTestShared builds a Shared from the test CRConfig and CZF, with every cache available.
+func loadTestShared(t *testing.T) *Shared {
+	t.Helper()
+	crc := loadTestCRConfig(t)
+	cz, err := czf.LoadCZF("testdata/czf.json")
+	if err != nil {
+		t.Fatalf("loading test CZF: %v", err)
+	}
+	parsedCZF, err := czf.Parse(cz)
+	if err != nil {
+		t.Fatalf("parsing test CZF: %v", err)
+	}
+	sh := NewShared(parsedCZF, nil, nil, crc, allAvailable(crc), nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	return sh
+}
+
+// allAvailable returns CRStates with every cache in crc available.
+func allAvailable(crc *tc.CRConfig) *tc.CRStates {
+	crs := &tc.CRStates{Caches: map[tc.CacheName]tc.IsAvailable{}, DeliveryService: map[tc.DeliveryServiceName]tc.CRStatesDeliveryService{}}
+	for name := range crc.ContentServers {
+		crs.Caches[tc.CacheName(name)] = tc.IsAvailable{IsAvailable: true}
+	}
+	return crs
+}
+
+// newTestSharedWith builds a Shared from the test CRConfig and CZF as loadTestShared, after calling modify on the CRConfig.
+func newTestSharedWith(t *testing.T, modify func(crc *tc.CRConfig)) *Shared {
+	t.Helper()
+	sh := loadTestShared(t)
+	crc := sh.GetCRConfig()
+	modify(crc)
+	sh = NewShared(sh.czf, nil, nil, crc, allAvailable(crc), nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	return sh
+}
+
+func TestGetServerForDomainDNSMaxDNSIPs(t *testing.T) {
+	// the client is in cg-east, which has three IPv4 dnsds caches, edge1, edge2, and edge3.
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name      string
+		maxDNSIPs *int
+		expected  int
+	}{
+		{"max 2", intPtr(2), 2},
+		{"max 1", intPtr(1), 1},
+		{"max more than the cachegroup has", intPtr(10), 3},
+		{"no max", nil, 3},
+		{"max 0", intPtr(0), 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sh := newTestSharedWith(t, func(crc *tc.CRConfig) {
+				ds := crc.DeliveryServices["dnsds"]
+				ds.MaxDNSIPsForLocation = test.maxDNSIPs
+				crc.DeliveryServices["dnsds"] = ds
+			})
+			cl := &Client{Addr: testAddr{}, IP: []byte{10, 0, 0, 5}}
+			servers, _, _, result := sh.GetServerForDomainDNS(cl, "edge.dnsds.cdn.example.net", true, "dnsds")
+			if result != ResultOK {
+				t.Fatalf("GetServerForDomainDNS = %s, expected %s", result, ResultOK)
+			}
+			addrs := map[string]struct{}{}
+			for _, sv := range servers {
+				addrs[sv.Addr] = struct{}{}
+			}
+			if len(servers) != test.expected || len(addrs) != len(servers) {
+				t.Errorf("GetServerForDomainDNS returned %d servers %v, expected %d distinct", len(servers), servers, test.expected)
+			}
+			again, _, _, _ := sh.GetServerForDomainDNS(cl, "edge.dnsds.cdn.example.net", true, "dnsds")
+			if !reflect.DeepEqual(servers, again) {
+				t.Errorf("GetServerForDomainDNS returned %v then %v, expected the same servers for the same name", servers, again)
+			}
+		})
+	}
+}
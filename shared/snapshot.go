@@ -0,0 +1,80 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+// ValidateCRConfig returns the CDN domain of the CRConfig, lowercase and without a trailing period, or an error if the CRConfig can't be served.
+//
+// Malformed Delivery Services, servers, and routers aren't errors here: they're skipped when the routing tables are built,
+// so one bad DS doesn't break the others. This only rejects CRConfigs which can't be served at all,
+// or which are so empty they're almost certainly a bad fetch, and would stop routing for the whole CDN.
+//
+func ValidateCRConfig(crc *tc.CRConfig) (string, error) {
+	if crc == nil {
+		return "", errors.New("nil CRConfig")
+	}
+	iCDNDomain, ok := crc.Config["domain_name"]
+	if !ok {
+		return "", errors.New("missing config/domain_name")
+	}
+	cdnDomain, ok := iCDNDomain.(string)
+	if !ok {
+		return "", errors.New("config/domain_name not a string")
+	}
+	cdnDomain = strings.ToLower(strings.TrimSuffix(cdnDomain, "."))
+	if cdnDomain == "" || strings.ContainsAny(cdnDomain, " \t\n/") {
+		return "", errors.New("config/domain_name '" + cdnDomain + "' not a valid domain")
+	}
+	if len(crc.DeliveryServices) == 0 {
+		return "", errors.New("no deliveryServices")
+	}
+	if len(crc.ContentServers) == 0 {
+		return "", errors.New("no contentServers")
+	}
+	return cdnDomain, nil
+}
+
+// NewWithCRConfig builds a new Shared from the given CRConfig, with all routing tables rebuilt,
+// and the CZF, Deep CZF, geolocation providers, certificates, DNSSEC signer, and CRStates of sh.
+//
+// The CRStates aren't copied: both snapshots share them, so CRStates polled while the new Shared is being built and swapped in
+// are set on both, and aren't lost with the old snapshot.
+//
+// Returns an error if the CRConfig is invalid, in which case sh should continue to be served.
+// The new Shared isn't served until the caller swaps it in, e.g. with Ptr.Set and the server pointers.
+//
+// Safe for use by pollers while sh is being served.
+//
+func (sh *Shared) NewWithCRConfig(crc *tc.CRConfig) (*Shared, error) {
+	return newShared(sh.czf, sh.deepCZF, sh.geo, crc, sh.health, sh.certs, sh.signer)
+}
+
+// Ptr is an atomic pointer to the Shared currently being served, so pollers always update the current snapshot,
+// and a new snapshot may be safely swapped in while they're running.
+type Ptr struct {
+	real *unsafe.Pointer
+}
+
+// NewPtr creates a new Ptr to the given Shared.
+func NewPtr(sh *Shared) *Ptr {
+	ptr := (unsafe.Pointer)(sh)
+	return &Ptr{real: &ptr}
+}
+
+// Get returns the current Shared.
+// This may safely be called by multiple goroutines.
+func (sp *Ptr) Get() *Shared {
+	return (*Shared)(atomic.LoadPointer(sp.real))
+}
+
+// Set atomically sets the current Shared.
+// This may safely be called by multiple goroutines.
+func (sp *Ptr) Set(sh *Shared) {
+	atomic.StorePointer(sp.real, (unsafe.Pointer)(sh))
+}
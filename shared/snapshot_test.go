@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestNewWithCRConfigSharesCRStates(t *testing.T) {
+	old := loadTestShared(t)
+	sp := NewPtr(old)
+
+	next, err := old.NewWithCRConfig(loadTestCRConfig(t))
+	if err != nil {
+		t.Fatalf("NewWithCRConfig: %v", err)
+	}
+
+	// a CRStates poll landing on the old snapshot, between building the new one and swapping it in
+	crs := allAvailable(old.GetCRConfig())
+	crs.Caches["edge1"] = tc.IsAvailable{IsAvailable: false}
+	sp.Get().SetCRStates(crs)
+	sp.Set(next)
+
+	if got := sp.Get().GetCRStates(); got != crs {
+		t.Errorf("new snapshot CRStates = %p, expected the CRStates set on the old snapshot %p", got, crs)
+	}
+	if sp.Get().GetAvailability().CacheAvailable("edge1") {
+		t.Error("new snapshot edge1 available, expected unavailable per the CRStates set on the old snapshot")
+	}
+	if !sp.Get().GetAvailability().CacheAvailable("edge2") {
+		t.Error("new snapshot edge2 unavailable, expected available")
+	}
+
+	// and polls after the swap are seen by both
+	crs2 := allAvailable(old.GetCRConfig())
+	next.SetCRStates(crs2)
+	if got := old.GetCRStates(); got != crs2 {
+		t.Errorf("old snapshot CRStates = %p, expected the CRStates set on the new snapshot %p", got, crs2)
+	}
+}
+
+func TestValidateCRConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(crc *tc.CRConfig)
+		expected  string
+		expectErr bool
+	}{
+		{"valid", func(crc *tc.CRConfig) {}, "cdn.example.net", false},
+		{"trailing dot", func(crc *tc.CRConfig) { crc.Config["domain_name"] = "cdn.example.net." }, "cdn.example.net", false},
+		{"missing domain", func(crc *tc.CRConfig) { delete(crc.Config, "domain_name") }, "", true},
+		{"domain not string", func(crc *tc.CRConfig) { crc.Config["domain_name"] = 42 }, "", true},
+		{"empty domain", func(crc *tc.CRConfig) { crc.Config["domain_name"] = "." }, "", true},
+		{"no deliveryServices", func(crc *tc.CRConfig) { crc.DeliveryServices = nil }, "", true},
+		{"no contentServers", func(crc *tc.CRConfig) { crc.ContentServers = nil }, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			crc := loadTestCRConfig(t)
+			test.modify(crc)
+			cdnDomain, err := ValidateCRConfig(crc)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got domain '%s'", cdnDomain)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cdnDomain != test.expected {
+				t.Errorf("domain = '%s', expected '%s'", cdnDomain, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/dnssec"
+)
+
+// Defaults for the CRConfig config/soa and config/ttls values, used when the CRConfig doesn't have them.
+// These match the defaults of the Java Traffic Router.
+const DefaultSOAAdmin = "traffic_ops"
+const DefaultSOARefresh = 28800
+const DefaultSOARetry = 7200
+const DefaultSOAExpire = 604800
+const DefaultSOAMinimum = 30
+const DefaultSOATTL = 86400
+const DefaultNSTTL = 3600
+const DefaultDNSKEYTTL = 30
+
+// SOA is the Start of Authority of the CDN domain, built from the CRConfig config/soa.
+// All names are FQDNs without the trailing period.
+type SOA struct {
+	PrimaryNS string
+	Admin     string
+	Serial    uint32
+	Refresh   uint32
+	Retry     uint32
+	Expire    uint32
+	Minimum   uint32
+	TTL       uint32
+}
+
+// NameServer is a Traffic Router, which is an authoritative name server for the CDN domain.
+// The FQDN is always inside the CDN domain, so its addresses may be served as glue.
+type NameServer struct {
+	FQDN string
+	IP   net.IP
+	IP6  net.IP
+}
+
+// NameServers is the NS RRSet of the CDN domain.
+type NameServers struct {
+	TTL     uint32
+	Servers []NameServer
+}
+
+// BuildNameServersFromCRConfig builds the CDN domain's name servers from the CRConfig Traffic Routers.
+// Each router becomes routerName.cdnDomain, so glue can always be served for it.
+//
+// Returns the name servers sorted by FQDN, and any errors from malformed routers.
+// As with Delivery Services, a malformed router doesn't prevent the others from being served.
+//
+func BuildNameServersFromCRConfig(crc *tc.CRConfig, cdnDomain string) (NameServers, error) {
+	errStrs := []string{}
+	nameServers := NameServers{TTL: configTTL(crc, "NS", DefaultNSTTL)}
+	for routerName, router := range crc.ContentRouters {
+		if router.ServerStatus == nil {
+			errStrs = append(errStrs, "CRConfig router '"+routerName+"' has nil status, skipping!")
+			continue
+		}
+		if tc.CacheStatus(*router.ServerStatus) != tc.CacheStatusReported && tc.CacheStatus(*router.ServerStatus) != tc.CacheStatusOnline {
+			continue
+		}
+		ns := NameServer{FQDN: strings.ToLower(routerName + "." + cdnDomain)}
+		if router.IP != nil && *router.IP != "" {
+			if ip := ParseIPOrCIDR(*router.IP); ip == nil || ip.To4() == nil {
+				errStrs = append(errStrs, "CRConfig router '"+routerName+"' ip '"+*router.IP+"' not valid IPv4, skipping!")
+			} else {
+				ns.IP = ip.To4()
+			}
+		}
+		if router.IP6 != nil && *router.IP6 != "" {
+			if ip := ParseIPOrCIDR(*router.IP6); ip == nil || ip.To4() != nil {
+				errStrs = append(errStrs, "CRConfig router '"+routerName+"' ip6 '"+*router.IP6+"' not valid IPv6, skipping!")
+			} else {
+				ns.IP6 = ip
+			}
+		}
+		if ns.IP == nil && ns.IP6 == nil {
+			errStrs = append(errStrs, "CRConfig router '"+routerName+"' has no valid ip or ip6, not adding as a name server!")
+			continue
+		}
+		nameServers.Servers = append(nameServers.Servers, ns)
+	}
+	sort.Slice(nameServers.Servers, func(i, j int) bool { return nameServers.Servers[i].FQDN < nameServers.Servers[j].FQDN })
+
+	err := error(nil)
+	if len(errStrs) > 0 {
+		err = errors.New(strings.Join(errStrs, "\n"))
+	}
+	return nameServers, err
+}
+
+// BuildSOAFromCRConfig builds the CDN domain's SOA from the CRConfig config/soa.
+// The primary name server is the first of nameServers, which must be sorted.
+// The serial is the CRConfig stats/date, so secondaries and monitoring see a new serial for each snapshot.
+func BuildSOAFromCRConfig(crc *tc.CRConfig, cdnDomain string, nameServers NameServers) SOA {
+	soaCfg, _ := crc.Config["soa"].(map[string]interface{}) // nil is ok, missing values get defaults
+
+	admin := DefaultSOAAdmin
+	if adminStr, ok := soaCfg["admin"].(string); ok && adminStr != "" {
+		admin = strings.TrimSuffix(adminStr, ".")
+	}
+	if !strings.Contains(admin, ".") {
+		admin += "." + cdnDomain // like the Java TR, an admin without a domain is in the CDN domain
+	}
+
+	primaryNS := cdnDomain // no routers is a broken CDN, but still serve a valid SOA
+	if len(nameServers.Servers) > 0 {
+		primaryNS = nameServers.Servers[0].FQDN
+	}
+
+	serial := uint32(time.Now().Unix())
+	if crc.Stats.DateUnixSeconds != nil {
+		serial = uint32(*crc.Stats.DateUnixSeconds)
+	}
+
+	return SOA{
+		PrimaryNS: primaryNS,
+		Admin:     admin,
+		Serial:    serial,
+		Refresh:   configUint32(soaCfg, "refresh", DefaultSOARefresh),
+		Retry:     configUint32(soaCfg, "retry", DefaultSOARetry),
+		Expire:    configUint32(soaCfg, "expire", DefaultSOAExpire),
+		Minimum:   configUint32(soaCfg, "minimum", DefaultSOAMinimum),
+		TTL:       configTTL(crc, "SOA", DefaultSOATTL),
+	}
+}
+
+// configTTL returns the CRConfig config/ttls value for the given record type, or def if it's missing or malformed.
+func configTTL(crc *tc.CRConfig, recordType string, def uint32) uint32 {
+	ttls, _ := crc.Config["ttls"].(map[string]interface{})
+	return configUint32(ttls, recordType, def)
+}
+
+// configUint32 returns the number in the CRConfig config object m at key, or def if it's missing or malformed.
+// Traffic Ops serializes most config numbers as strings, but numbers are accepted too.
+func configUint32(m map[string]interface{}, key string, def uint32) uint32 {
+	switch val := m[key].(type) {
+	case string:
+		num, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return def
+		}
+		return uint32(num)
+	case float64:
+		if val < 0 || val > float64(^uint32(0)) {
+			return def
+		}
+		return uint32(val)
+	}
+	return def
+}
+
+// GetSOA returns the SOA of the CDN domain.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetSOA() SOA { return sh.soa }
+
+// GetNameServers returns the NS RRSet of the CDN domain.
+// The returned object MUST NOT be modified.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetNameServers() NameServers { return sh.nameServers }
+
+// IsCDNDomain returns whether domain, which must have a trailing period, is the CDN domain itself, the apex of the zone we're authoritative for.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) IsCDNDomain(domain string) bool {
+	return strings.TrimSuffix(domain, ".") == sh.cdnDomain
+}
+
+// GetSigner returns the DNSSEC signer for the CDN domain, or nil if DNSSEC is disabled.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetSigner() *dnssec.Signer { return sh.signer }
+
+// GetDNSKEYTTL returns the TTL of the CDN domain's DNSKEY RRset.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetDNSKEYTTL() uint32 { return sh.dnskeyTTL }
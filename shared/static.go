@@ -0,0 +1,151 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/rfc"
+)
+
+// Static DNS entry types, as in the CRConfig deliveryServices staticDnsEntries.
+const StaticDNSEntryTypeA = "A"
+const StaticDNSEntryTypeAAAA = "AAAA"
+const StaticDNSEntryTypeCNAME = "CNAME"
+const StaticDNSEntryTypeTXT = "TXT"
+
+// StaticDNSEntry is a static DNS record of a Delivery Service, served as-is instead of routing to a cache.
+type StaticDNSEntry struct {
+	// Type is one of the StaticDNSEntryType constants.
+	Type string
+	// IP is the address of A and AAAA entries.
+	IP net.IP
+	// Value is the target FQDN of CNAME entries without the trailing period, or the text of TXT entries.
+	Value string
+	TTL   uint32
+}
+
+// BuildStaticDNSEntriesFromCRConfig returns map[fqdn]entries of the Delivery Service staticDnsEntries.
+// FQDNs are lowercase, without the trailing period.
+//
+// Like the Java Traffic Router, entry names are relative to the Delivery Service domain, ds-name.cdn-domain,
+// where ds-name is from the first DS HOST match of the form `.*\.ds-name\..*`. An empty name is the DS domain itself.
+//
+// Returns any errors from malformed entries. As with Delivery Services, a malformed entry doesn't prevent the others from being served.
+//
+func BuildStaticDNSEntriesFromCRConfig(crc *tc.CRConfig, cdnDomain string) (map[string][]StaticDNSEntry, error) {
+	errStrs := []string{}
+	entries := map[string][]StaticDNSEntry{}
+	for dsName, ds := range crc.DeliveryServices {
+		if len(ds.StaticDNSEntries) == 0 {
+			continue
+		}
+		dsDomain, ok := getDSDomain(ds, cdnDomain)
+		if !ok {
+			errStrs = append(errStrs, "CRConfig ds '"+dsName+"' has static DNS entries, but no HOST match of the form '.*\\.ds-name\\..*' to get its domain, skipping!")
+			continue
+		}
+		for _, crcEntry := range ds.StaticDNSEntries {
+			fqdn := dsDomain
+			if name := strings.TrimSuffix(crcEntry.Name, "."); name != "" && name != "@" {
+				fqdn = strings.ToLower(name) + "." + fqdn
+			}
+			entry, err := buildStaticDNSEntry(crcEntry)
+			if err != nil {
+				errStrs = append(errStrs, "CRConfig ds '"+dsName+"' static DNS entry '"+fqdn+"': "+err.Error()+", skipping!")
+				continue
+			}
+			entries[fqdn] = append(entries[fqdn], entry)
+		}
+	}
+
+	for fqdn, fqdnEntries := range entries {
+		// A CNAME can't coexist with other data (RFC 1034§3.6.2), and there can only be one.
+		cnameI := -1
+		for i, entry := range fqdnEntries {
+			if entry.Type == StaticDNSEntryTypeCNAME {
+				cnameI = i
+				break
+			}
+		}
+		if cnameI >= 0 && len(fqdnEntries) > 1 {
+			errStrs = append(errStrs, "CRConfig static DNS entry '"+fqdn+"' has a CNAME and other entries, serving only the CNAME!")
+			entries[fqdn] = []StaticDNSEntry{fqdnEntries[cnameI]}
+			continue
+		}
+		// sort, so answers are stable across CRConfig loads.
+		sort.SliceStable(fqdnEntries, func(i, j int) bool { return fqdnEntries[i].Type < fqdnEntries[j].Type })
+	}
+
+	err := error(nil)
+	if len(errStrs) > 0 {
+		err = errors.New(strings.Join(errStrs, "\n"))
+	}
+	return entries, err
+}
+
+func buildStaticDNSEntry(crcEntry tc.CRConfigStaticDNSEntry) (StaticDNSEntry, error) {
+	if crcEntry.TTL < 0 || int64(crcEntry.TTL) > int64(^uint32(0)) {
+		return StaticDNSEntry{}, errors.New("ttl " + strconv.Itoa(crcEntry.TTL) + " out of range")
+	}
+	entry := StaticDNSEntry{Type: strings.ToUpper(crcEntry.Type), TTL: uint32(crcEntry.TTL)}
+	switch entry.Type {
+	case StaticDNSEntryTypeA:
+		ip := net.ParseIP(crcEntry.Value)
+		if ip == nil || ip.To4() == nil {
+			return StaticDNSEntry{}, errors.New("A value '" + crcEntry.Value + "' not valid IPv4")
+		}
+		entry.IP = ip.To4()
+	case StaticDNSEntryTypeAAAA:
+		ip := net.ParseIP(crcEntry.Value)
+		if ip == nil || ip.To4() != nil {
+			return StaticDNSEntry{}, errors.New("AAAA value '" + crcEntry.Value + "' not valid IPv6")
+		}
+		entry.IP = ip
+	case StaticDNSEntryTypeCNAME:
+		entry.Value = strings.TrimSuffix(crcEntry.Value, ".")
+		if !rfc.ValidFQDN(entry.Value) {
+			return StaticDNSEntry{}, errors.New("CNAME value '" + crcEntry.Value + "' not a valid FQDN")
+		}
+	case StaticDNSEntryTypeTXT:
+		entry.Value = crcEntry.Value
+	default:
+		return StaticDNSEntry{}, errors.New("unknown type '" + crcEntry.Type + "'")
+	}
+	return entry, nil
+}
+
+// getDSDomain returns the domain of the Delivery Service, ds-name.cdn-domain, from its first HOST match of the form `.*\.ds-name\..*`.
+// Returns false if the DS has no such match.
+func getDSDomain(ds tc.CRConfigDeliveryService, cdnDomain string) (string, bool) {
+	for _, matchSet := range ds.MatchSets {
+		if matchSet == nil {
+			continue
+		}
+		for _, matchList := range matchSet.MatchList {
+			if matchList.MatchType != CRConfigMatchListTypeHost {
+				continue
+			}
+			if !strings.HasPrefix(matchList.Regex, `.*\.`) || !strings.HasSuffix(matchList.Regex, `\..*`) {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(matchList.Regex, `.*\.`), `\..*`)
+			return strings.ToLower(strings.Replace(name, `\.`, `.`, -1)) + "." + cdnDomain, true
+		}
+	}
+	return "", false
+}
+
+// GetStaticDNSEntries returns the static DNS entries of domain, which may have a trailing period, or nil if it has none.
+// The returned entries MUST NOT be modified.
+//
+// Static entries take precedence over routing, so if this returns entries, the handler must answer with them.
+//
+// Safe for use by handlers.
+//
+func (sh *Shared) GetStaticDNSEntries(domain string) []StaticDNSEntry {
+	return sh.staticDNSEntries[strings.TrimSuffix(domain, ".")]
+}
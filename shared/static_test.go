@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+)
+
+func TestBuildStaticDNSEntriesFromCRConfig(t *testing.T) {
+	crc := loadTestCRConfig(t)
+	entries, err := BuildStaticDNSEntriesFromCRConfig(crc, "cdn.example.net")
+	if err != nil {
+		t.Fatalf("BuildStaticDNSEntriesFromCRConfig: %v", err)
+	}
+	expected := map[string][]StaticDNSEntry{
+		"www.dnsds.cdn.example.net": {
+			{Type: StaticDNSEntryTypeA, IP: net.ParseIP("192.0.2.10").To4(), TTL: 300},
+			{Type: StaticDNSEntryTypeAAAA, IP: net.ParseIP("2001:db8::10"), TTL: 300},
+		},
+		"txt.dnsds.cdn.example.net":   {{Type: StaticDNSEntryTypeTXT, Value: `v=spf1 "quoted" -all`, TTL: 60}},
+		"alias.dnsds.cdn.example.net": {{Type: StaticDNSEntryTypeCNAME, Value: "origin.example.org", TTL: 120}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("entries %+v, expected %+v", entries, expected)
+	}
+}
+
+func TestBuildStaticDNSEntriesFromCRConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []tc.CRConfigStaticDNSEntry
+		expected map[string][]StaticDNSEntry
+	}{
+		{"malformed entries skipped", []tc.CRConfigStaticDNSEntry{
+			{Name: "a", Type: "A", Value: "2001:db8::1", TTL: 60},
+			{Name: "b", Type: "AAAA", Value: "192.0.2.1", TTL: 60},
+			{Name: "c", Type: "CNAME", Value: "bad..example.org", TTL: 60},
+			{Name: "d", Type: "MX", Value: "mail.example.org", TTL: 60},
+			{Name: "e", Type: "A", Value: "192.0.2.1", TTL: -1},
+			{Name: "ok", Type: "a", Value: "192.0.2.1", TTL: 60},
+		}, map[string][]StaticDNSEntry{"ok.dnsds.cdn.example.net": {{Type: StaticDNSEntryTypeA, IP: net.ParseIP("192.0.2.1").To4(), TTL: 60}}}},
+		{"cname with other data", []tc.CRConfigStaticDNSEntry{
+			{Name: "x", Type: "A", Value: "192.0.2.1", TTL: 60},
+			{Name: "x", Type: "CNAME", Value: "origin.example.org.", TTL: 60},
+		}, map[string][]StaticDNSEntry{"x.dnsds.cdn.example.net": {{Type: StaticDNSEntryTypeCNAME, Value: "origin.example.org", TTL: 60}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			crc := loadTestCRConfig(t)
+			ds := crc.DeliveryServices["dnsds"]
+			ds.StaticDNSEntries = test.entries
+			crc.DeliveryServices["dnsds"] = ds
+			entries, err := BuildStaticDNSEntriesFromCRConfig(crc, "cdn.example.net")
+			if err == nil {
+				t.Error("BuildStaticDNSEntriesFromCRConfig succeeded, expected an error for the bad entries")
+			}
+			if !reflect.DeepEqual(entries, test.expected) {
+				t.Errorf("entries %+v, expected %+v", entries, test.expected)
+			}
+		})
+	}
+
+	// entry names are relative to the DS domain, which comes from its HOST match
+	crc := loadTestCRConfig(t)
+	ds := crc.DeliveryServices["dnsds"]
+	ds.MatchSets = nil
+	crc.DeliveryServices["dnsds"] = ds
+	if entries, err := BuildStaticDNSEntriesFromCRConfig(crc, "cdn.example.net"); err == nil || len(entries) != 0 {
+		t.Errorf("BuildStaticDNSEntriesFromCRConfig for a DS without a HOST match = %v, %v, expected no entries and an error", entries, err)
+	}
+}
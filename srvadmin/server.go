@@ -0,0 +1,65 @@
+// package srvadmin contains the admin HTTP server, for operators to see metrics and control the router.
+// It should only be served on an internal or loopback address, never to clients.
+package srvadmin
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+
+	"github.com/rob05c/traffic_router/crconfigdiff"
+	"github.com/rob05c/traffic_router/guard"
+)
+
+// Server serves the admin endpoints:
+//
+//   GET  /metrics                  - metrics, as expvar JSON
+//   GET  /crconfig/diffs           - the diffs of the most recent CRConfig changes, newest first, as JSON
+//   POST /crconfig/guard/override  - apply the next CRConfig poll or config reload the CRConfig guard would refuse
+//
+type Server struct {
+	Guard *guard.Guard
+	Diffs *crconfigdiff.History
+	mux   *http.ServeMux
+}
+
+// New creates a new admin Server.
+func New(crcGuard *guard.Guard, crcDiffs *crconfigdiff.History) *Server {
+	sv := &Server{Guard: crcGuard, Diffs: crcDiffs, mux: http.NewServeMux()}
+	sv.mux.Handle("/metrics", expvar.Handler())
+	sv.mux.HandleFunc("/crconfig/diffs", sv.serveDiffs)
+	sv.mux.HandleFunc("/crconfig/guard/override", sv.serveGuardOverride)
+	return sv
+}
+
+func (sv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sv.mux.ServeHTTP(w, r)
+}
+
+func (sv *Server) serveGuardOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	sv.Guard.Override()
+	fmt.Println("EVENT: admin: " + r.RemoteAddr + " overrode the CRConfig guard, the next CRConfig it would refuse will be applied")
+	w.Write([]byte("CRConfig guard overridden, the next CRConfig poll or config reload it would refuse will be applied\n"))
+}
+
+func (sv *Server) serveDiffs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	bts, err := json.Marshal(sv.Diffs.Get())
+	if err != nil {
+		fmt.Println("ERROR: admin: encoding CRConfig diffs: " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
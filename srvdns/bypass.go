@@ -0,0 +1,53 @@
+package srvdns
+
+import (
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// MakeBypassRRs returns the records sending a client to the DNS bypass destination, for when no cache is available.
+//
+// This is the bypass address of the requested type if it has one, otherwise its CNAME.
+// Returns no records if the bypass has neither, which is a NoData.
+//
+func MakeBypassRRs(domain string, bypass *shared.DNSBypass, v4 bool, ttl uint32) []dns.RR {
+	if v4 && bypass.IP != nil {
+		return []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   bypass.IP,
+		}}
+	}
+	if !v4 && bypass.IP6 != nil {
+		return []dns.RR{&dns.AAAA{
+			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: bypass.IP6,
+		}}
+	}
+	if bypass.CNAME != "" {
+		return []dns.RR{&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: domain, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: dns.Fqdn(bypass.CNAME),
+		}}
+	}
+	return nil
+}
+
+// combineANYAddrs returns the A and AAAA answers for an ANY request, which may be bypass CNAMEs.
+// A CNAME can't coexist with other data (RFC 1034§3.6.2), so if there are addresses, CNAMEs are omitted,
+// and if both answers are the same CNAME, it's only included once.
+func combineANYAddrs(rrsV4 []dns.RR, rrsV6 []dns.RR) []dns.RR {
+	addrs := []dns.RR{}
+	cnames := []dns.RR{}
+	for _, rr := range append(append([]dns.RR{}, rrsV4...), rrsV6...) {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			cnames = append(cnames, rr)
+			continue
+		}
+		addrs = append(addrs, rr)
+	}
+	if len(addrs) > 0 {
+		return addrs
+	}
+	return dns.Dedup(cnames, nil)
+}
@@ -0,0 +1,66 @@
+package srvdns
+
+import (
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// allCachesDown is a newTestServerWith modify func making every cache unavailable, so DNS DS requests are answered with the bypass.
+func allCachesDown(crc *tc.CRConfig, crs *tc.CRStates) {
+	for name := range crs.Caches {
+		crs.Caches[name] = tc.IsAvailable{IsAvailable: false}
+	}
+}
+
+func TestServeDNSBypass(t *testing.T) {
+	// the dnsds bypass is ip 198.51.100.1, cname origin.example.org, ttl 15, and the dnsds A and AAAA ttls are 30.
+	noTTL := func(crc *tc.CRConfig, crs *tc.CRStates) {
+		allCachesDown(crc, crs)
+		crc.DeliveryServices["dnsds"].BypassDestination[shared.BypassDestinationDNS].TTL = nil
+	}
+	tests := []struct {
+		name     string
+		modify   func(crc *tc.CRConfig, crs *tc.CRStates)
+		qtype    uint16
+		expected string
+	}{
+		{"ip", allCachesDown, dns.TypeA, "edge.dnsds.cdn.example.net.\t15\tIN\tA\t198.51.100.1"},
+		{"cname for the type without an ip", allCachesDown, dns.TypeAAAA, "edge.dnsds.cdn.example.net.\t15\tIN\tCNAME\torigin.example.org."},
+		{"no bypass ttl uses the ds ttl", noTTL, dns.TypeA, "edge.dnsds.cdn.example.net.\t30\tIN\tA\t198.51.100.1"},
+		{"any prefers addresses to the cname", allCachesDown, dns.TypeANY, "edge.dnsds.cdn.example.net.\t15\tIN\tA\t198.51.100.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := query(t, newTestServerWith(t, false, test.modify), "edge.dnsds.cdn.example.net.", test.qtype, false)
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("rcode %s, expected NOERROR", dns.RcodeToString[resp.Rcode])
+			}
+			if len(resp.Answer) != 1 || resp.Answer[0].String() != test.expected {
+				t.Errorf("answer %v, expected [%s]", resp.Answer, test.expected)
+			}
+		})
+	}
+
+	// with caches available, the bypass isn't used
+	resp := query(t, newTestServer(t, false), "edge.dnsds.cdn.example.net.", dns.TypeA, false)
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok && a.A.String() == "198.51.100.1" {
+			t.Error("answer has the bypass ip with caches available, expected only caches")
+		}
+	}
+
+	// without a bypass, there's no answer
+	noBypass := func(crc *tc.CRConfig, crs *tc.CRStates) {
+		allCachesDown(crc, crs)
+		ds := crc.DeliveryServices["dnsds"]
+		ds.BypassDestination = nil
+		crc.DeliveryServices["dnsds"] = ds
+	}
+	if resp := query(t, newTestServerWith(t, false, noBypass), "edge.dnsds.cdn.example.net.", dns.TypeA, false); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("no bypass with every cache down: rcode %s, expected SERVFAIL", dns.RcodeToString[resp.Rcode])
+	}
+}
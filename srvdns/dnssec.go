@@ -0,0 +1,196 @@
+package srvdns
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rob05c/traffic_router/dnssec"
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultEDNSUDPSize is the UDP payload size we advertise in EDNS responses.
+const DefaultEDNSUDPSize = 1232
+
+// dnssecOK returns whether the request has the EDNS DO bit, asking for DNSSEC records.
+func dnssecOK(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// signMsg adds RRSIGs for every RRset in the answer, authority, and additional sections of msg.
+// Returns false if signing failed, in which case the message is not modified, and the caller should return a SERVFAIL.
+func signMsg(signer *dnssec.Signer, msg *dns.Msg) bool {
+	sections := []*[]dns.RR{&msg.Answer, &msg.Ns, &msg.Extra}
+	sigs := make([][]dns.RR, len(sections))
+	for i, section := range sections {
+		rrsigs, err := signer.Sign(*section)
+		if err != nil {
+			fmt.Println("ERROR: DNSSEC signing response: " + err.Error())
+			return false
+		}
+		sigs[i] = rrsigs
+	}
+	for i, section := range sections {
+		*section = append(*section, sigs[i]...)
+	}
+	return true
+}
+
+// MakeNSECTypes returns the NSEC type bitmap of the existing name domain.
+func MakeNSECTypes(sh *shared.Shared, domain string) []uint16 {
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if sh.IsCDNDomain(domain) {
+		types = append(types, dns.TypeSOA, dns.TypeNS)
+		if sh.GetSigner() != nil {
+			types = append(types, dns.TypeDNSKEY)
+		}
+	} else if entries := sh.GetStaticDNSEntries(domain); len(entries) > 0 {
+		types = append(types, MakeStaticTypes(entries)...)
+	} else {
+		hasV4, hasV6 := sh.GetNameAddrTypes(domain)
+		if hasV4 {
+			types = append(types, dns.TypeA)
+		}
+		if hasV6 {
+			types = append(types, dns.TypeAAAA)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// MakeDenial returns the NSEC records proving the denial of existence for a NoData or NXDomain response.
+//
+// Answers are computed per-request, so there's no zone to build an NSEC chain from.
+// Instead, we use "white lies" (RFC 4470): minimally covering NSEC records, made up for each request,
+// which deny only the requested name and reveal nothing else about the zone.
+//
+// For NoData, this is an NSEC at the name itself, with its real types, less qtype.
+// The name's types don't depend on availability, so they may include qtype when, e.g., no cache is available and the bypass has no address of that type.
+// The NSEC proving a NoData must never list the type it denies, or validating resolvers will treat the answer as bogus.
+//
+// For NXDomain, this is an NSEC covering the next closer name (and hence the requested name beneath it),
+// and an NSEC at the closest encloser covering the wildcard beneath it. We have no wildcards, and hostnames
+// can't contain characters sorting before '*', so the latter covers no real names.
+//
+func MakeDenial(sh *shared.Shared, qname string, qtype uint16, result shared.Result, ttl uint32) []dns.RR {
+	qname = dns.CanonicalName(qname)
+	if result == shared.ResultNoData {
+		return []dns.RR{makeNSEC(qname, `\000.`+qname, withoutNoDataTypes(MakeNSECTypes(sh, qname), qtype), ttl)}
+	}
+
+	closestEncloser, nextCloser := findClosestEncloser(sh, qname)
+	return []dns.RR{
+		makeNSEC(predecessor(nextCloser), `\000.`+nextCloser, []uint16{dns.TypeRRSIG, dns.TypeNSEC}, ttl),
+		makeNSEC(closestEncloser, `\000.*.`+closestEncloser, MakeNSECTypes(sh, closestEncloser), ttl),
+	}
+}
+
+// withoutNoDataTypes returns types without those a NoData for qtype denied: qtype, or for ANY, the addresses ANY answers with.
+func withoutNoDataTypes(types []uint16, qtype uint16) []uint16 {
+	denied := []uint16{qtype}
+	if qtype == dns.TypeANY {
+		denied = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+	kept := make([]uint16, 0, len(types))
+	for _, rrType := range types {
+		isDenied := false
+		for _, deniedType := range denied {
+			isDenied = isDenied || rrType == deniedType
+		}
+		if !isDenied {
+			kept = append(kept, rrType)
+		}
+	}
+	return kept
+}
+
+func makeNSEC(name string, next string, types []uint16, ttl uint32) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// findClosestEncloser returns the closest existing ancestor of the nonexistent qname, and the next closer name: its child on the path to qname.
+// The CDN domain always exists, so qname must be inside it.
+func findClosestEncloser(sh *shared.Shared, qname string) (string, string) {
+	nextCloser := qname
+	for {
+		dotI := strings.Index(nextCloser, ".")
+		if dotI < 0 || dotI == len(nextCloser)-1 {
+			return nextCloser, nextCloser // should never happen, qname must be in the CDN domain
+		}
+		parent := nextCloser[dotI+1:]
+		if sh.IsCDNDomain(parent) || sh.NameExists(parent) {
+			return parent, nextCloser
+		}
+		nextCloser = parent
+	}
+}
+
+// maxNameLen is the maximum length of a domain name in wire format.
+const maxNameLen = 255
+
+// maxLabelLen is the maximum length of a domain name label.
+const maxLabelLen = 63
+
+// predecessor returns a name sorting immediately before name in canonical DNS order (RFC 4034§6.1), with no existing names between them.
+//
+// This decrements the last octet of the first label, and fills the label with \255 octets, so the result sorts after every name
+// before the original that could actually exist.
+//
+func predecessor(name string) string {
+	wire := make([]byte, maxNameLen+1)
+	wireLen, err := dns.PackDomainName(name, wire, 0, nil, false)
+	if err != nil || wireLen < 2 || wire[0] == 0 {
+		return name // should never happen, the name came from a valid request
+	}
+	labelLen := int(wire[0])
+	label := append([]byte(nil), wire[1:1+labelLen]...)
+	parent := name[strings.Index(name, ".")+1:]
+
+	last := label[len(label)-1]
+	label = label[:len(label)-1]
+	if last == 0 {
+		if len(label) == 0 {
+			return parent
+		}
+		return escapeLabel(label) + "." + parent
+	}
+	last--
+	if last >= 'A' && last <= 'Z' {
+		last = 'A' - 1 // canonical order compares lowercase, so uppercase would sort after the original
+	}
+	label = append(label, last)
+
+	room := maxNameLen - (wireLen - 1 - labelLen) - 1 // wire length remaining for the label, minus its length octet
+	if room > maxLabelLen {
+		room = maxLabelLen
+	}
+	for len(label) < room {
+		label = append(label, 255)
+	}
+	return escapeLabel(label) + "." + parent
+}
+
+// escapeLabel returns the presentation format of the given wire label.
+func escapeLabel(label []byte) string {
+	sb := strings.Builder{}
+	for _, ch := range label {
+		if (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '-' {
+			sb.WriteByte(ch)
+			continue
+		}
+		sb.WriteString(`\`)
+		str := strconv.Itoa(int(ch))
+		sb.WriteString(strings.Repeat("0", 3-len(str)))
+		sb.WriteString(str)
+	}
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package srvdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ECS address families, per RFC 7871§6 and the IANA Address Family Numbers.
+const ecsFamilyIPv4 = 1
+const ecsFamilyIPv6 = 2
+
+// getECS returns the EDNS Client Subnet option (RFC 7871) of the request, or nil if it has none.
+func getECS(r *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+// ecsSourcePrefixValid returns whether the source prefix length of ecs is no longer than its address family allows.
+// Per RFC 7871§7.1.2, a longer one is malformed, and the request is answered with FORMERR.
+// Unknown families aren't checked, because their address is never used.
+func ecsSourcePrefixValid(ecs *dns.EDNS0_SUBNET) bool {
+	switch ecs.Family {
+	case ecsFamilyIPv4:
+		return ecs.SourceNetmask <= net.IPv4len*8
+	case ecsFamilyIPv6:
+		return ecs.SourceNetmask <= net.IPv6len*8
+	}
+	return true
+}
+
+// getECSClientIP returns the client IP from the ECS option, masked to the source prefix length.
+// Returns nil if the option has no usable address, which is the case when the source prefix length is 0,
+// meaning the client or resolver asked us not to use its address (RFC 7871§7.1.2).
+func getECSClientIP(ecs *dns.EDNS0_SUBNET) net.IP {
+	if ecs.SourceNetmask == 0 || ecs.Address == nil {
+		return nil
+	}
+	switch ecs.Family {
+	case ecsFamilyIPv4:
+		ip := ecs.Address.To4()
+		if ip == nil {
+			return nil
+		}
+		return ip.Mask(net.CIDRMask(int(ecs.SourceNetmask), net.IPv4len*8))
+	case ecsFamilyIPv6:
+		ip := ecs.Address.To16()
+		if ip == nil {
+			return nil
+		}
+		return ip.Mask(net.CIDRMask(int(ecs.SourceNetmask), net.IPv6len*8))
+	}
+	return nil
+}
+
+// makeECSResponse returns the ECS option to echo in the response to the request's ecs, with the given scope prefix length.
+//
+// Per RFC 7871§7.2.1, the family, source prefix length, and address are copied from the request.
+// The scope is the prefix length the answer is valid for, which resolvers use to cache it. A scope of 0 means the answer didn't depend on the client subnet.
+//
+func makeECSResponse(ecs *dns.EDNS0_SUBNET, scope uint8) *dns.EDNS0_SUBNET {
+	resp := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecs.Family,
+		SourceNetmask: ecs.SourceNetmask,
+		SourceScope:   scope,
+		Address:       ecs.Address,
+	}
+	if ip := getECSClientIP(ecs); ip != nil {
+		resp.Address = ip
+	}
+	return resp
+}
+
+// setECSResponse adds the ECS option ecs to the response msg, adding an OPT record if it doesn't have one.
+func setECSResponse(msg *dns.Msg, ecs *dns.EDNS0_SUBNET) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(DefaultEDNSUDPSize, false)
+		opt = msg.IsEdns0()
+	}
+	opt.Option = append(opt.Option, ecs)
+}
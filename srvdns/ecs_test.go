@@ -0,0 +1,118 @@
+package srvdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newECS(family uint16, sourceNetmask uint8, addr string) *dns.EDNS0_SUBNET {
+	return &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: family, SourceNetmask: sourceNetmask, Address: net.ParseIP(addr)}
+}
+
+func TestGetECSClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		ecs      *dns.EDNS0_SUBNET
+		expected net.IP
+	}{
+		{"ipv4 masked", newECS(ecsFamilyIPv4, 24, "10.9.1.77"), net.ParseIP("10.9.1.0").To4()},
+		{"ipv4 full", newECS(ecsFamilyIPv4, 32, "10.9.1.77"), net.ParseIP("10.9.1.77").To4()},
+		{"ipv6 masked", newECS(ecsFamilyIPv6, 56, "2001:db8:1:2ff::1"), net.ParseIP("2001:db8:1:200::")},
+		{"source prefix 0", newECS(ecsFamilyIPv4, 0, "10.9.1.77"), nil},
+		{"no address", &dns.EDNS0_SUBNET{Family: ecsFamilyIPv4, SourceNetmask: 24}, nil},
+		{"ipv4 family with ipv6 address", newECS(ecsFamilyIPv4, 24, "2001:db8::1"), nil},
+		{"unknown family", newECS(3, 24, "10.9.1.77"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getECSClientIP(test.ecs); !got.Equal(test.expected) {
+				t.Errorf("getECSClientIP = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+// queryECS serves an A query for name with the ECS option ecs, and returns the response.
+func queryECS(t *testing.T, sv *Server, name string, ecs *dns.EDNS0_SUBNET) *dns.Msg {
+	t.Helper()
+	req := &dns.Msg{}
+	req.SetQuestion(name, dns.TypeA)
+	req.SetEdns0(4096, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, ecs)
+	tw := &testWriter{}
+	sv.ServeDNS(tw, req)
+	if tw.msg == nil {
+		t.Fatalf("%s: no response written", name)
+	}
+	return tw.msg
+}
+
+func TestServeDNSECS(t *testing.T) {
+	sv := newTestServer(t, false)
+	// the test client, 10.0.0.5, is in cg-east. ECS addresses in 10.9.0.0/16 are in cg-west, whose only cache is edge4, 10.2.0.1.
+	tests := []struct {
+		name          string
+		domain        string
+		ecs           *dns.EDNS0_SUBNET
+		allowedAddrs  []string // the addresses of the cachegroup the client should be routed to, or nil for any
+		expectedScope uint8
+	}{
+		{"localized by ecs", "edge.dnsds.cdn.example.net.", newECS(ecsFamilyIPv4, 24, "10.9.1.77"), []string{"10.2.0.1"}, 16},
+		{"source prefix 0 uses the request address", "edge.dnsds.cdn.example.net.", newECS(ecsFamilyIPv4, 0, "0.0.0.0"), []string{"10.1.0.1", "10.1.0.2", "10.1.0.3"}, 0},
+		{"http ds routers aren't localized", "ccr.httpds.cdn.example.net.", newECS(ecsFamilyIPv4, 24, "10.9.1.77"), nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := queryECS(t, sv, test.domain, test.ecs)
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+				t.Fatalf("rcode %s with %d answers, expected NOERROR with answers", dns.RcodeToString[resp.Rcode], len(resp.Answer))
+			}
+			if test.allowedAddrs != nil {
+				allowed := map[string]bool{}
+				for _, addr := range test.allowedAddrs {
+					allowed[addr] = true
+				}
+				for _, rr := range resp.Answer {
+					if addr := rr.(*dns.A).A.String(); !allowed[addr] {
+						t.Errorf("answer %s, expected one of %v", addr, test.allowedAddrs)
+					}
+				}
+			}
+			respECS := getECS(resp)
+			if respECS == nil {
+				t.Fatal("response has no ECS option, expected it echoed")
+			}
+			if respECS.Family != test.ecs.Family || respECS.SourceNetmask != test.ecs.SourceNetmask || respECS.SourceScope != test.expectedScope {
+				t.Errorf("response ECS family %d source %d scope %d, expected %d %d %d", respECS.Family, respECS.SourceNetmask, respECS.SourceScope, test.ecs.Family, test.ecs.SourceNetmask, test.expectedScope)
+			}
+		})
+	}
+}
+
+func TestServeDNSECSSourcePrefixTooLong(t *testing.T) {
+	sv := newTestServer(t, false)
+	tests := []struct {
+		name       string
+		ecs        *dns.EDNS0_SUBNET
+		expectedRc int
+	}{
+		{"ipv4 longest", newECS(ecsFamilyIPv4, 32, "10.9.1.77"), dns.RcodeSuccess},
+		{"ipv4 too long", newECS(ecsFamilyIPv4, 33, "10.9.1.77"), dns.RcodeFormatError},
+		{"ipv6 longest", newECS(ecsFamilyIPv6, 128, "2001:db8::1"), dns.RcodeSuccess},
+		{"ipv6 too long", newECS(ecsFamilyIPv6, 129, "2001:db8::1"), dns.RcodeFormatError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := queryECS(t, sv, "edge.dnsds.cdn.example.net.", test.ecs)
+			if resp.Rcode != test.expectedRc {
+				t.Fatalf("rcode %s, expected %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[test.expectedRc])
+			}
+			if test.expectedRc == dns.RcodeFormatError && (len(resp.Answer) != 0 || getECS(resp) != nil) {
+				t.Errorf("FORMERR has %d answers and ECS option %v, expected neither", len(resp.Answer), getECS(resp))
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package srvdns
 import (
 	"fmt"
 	"net"
+	"strings"
 
+	"github.com/apache/trafficcontrol/lib/go-tc"
 	"github.com/rob05c/traffic_router/shared"
 
 	"github.com/miekg/dns"
@@ -13,10 +15,14 @@ type Server struct {
 	Shared *shared.Shared
 }
 
+// ServeDNS answers the DNS request r.
+//
+// Each question is routed as a pipeline: the name is validated, matched to a Delivery Service, and only then is the client localized and a server selected.
+// The client's coverage zone is never looked up for names which don't need it, so floods of random names cost no location lookups.
+//
 func (ha *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	clientAddr := w.RemoteAddr()
-
-	zone := "" // zone == cachegroup
+	client := &shared.Client{Addr: clientAddr}
 	if ipStr, _, err := net.SplitHostPort(clientAddr.String()); err != nil {
 		// TODO SERVFAIL here
 		fmt.Println("ERROR: failed to split client ip:port '" + clientAddr.String() + "', czf zone will be empty: " + err.Error())
@@ -24,98 +30,244 @@ func (ha *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		// TODO SERVFAIL here
 		fmt.Println("ERROR: failed to parse client ip '" + ipStr + "' addr '" + clientAddr.String() + "', czf zone will be empty")
 	} else {
-		zone = ha.Shared.GetCZF().GetZone(ip)
+		client.IP = ip
 	}
 
 	msg := dns.Msg{}
 	msg.SetReply(r)
+
+	// Per RFC 7871§7.2.1, an ECS-aware server echoes the option. The scope is 0 unless we used it, which isn't known until the questions are answered.
+	ecsResp := (*dns.EDNS0_SUBNET)(nil)
+	if ecs := getECS(r); ecs != nil && len(r.Question) > 0 {
+		if !ecsSourcePrefixValid(ecs) {
+			fmt.Printf("EVENT: Request: %v ECS source prefix length %v is too long for family %v, returning FormErr\n", clientAddr.String(), ecs.SourceNetmask, ecs.Family)
+			msg.Rcode = dns.RcodeFormatError
+			ha.writeMsg(w, r, &msg)
+			return
+		}
+		client.ECSIP = getECSClientIP(ecs)
+		client.ECSSourcePrefixLen = int(ecs.SourceNetmask)
+		ecsResp = makeECSResponse(ecs, 0)
+		setECSResponse(&msg, ecsResp)
+	}
+
+	result := ha.answer(r, &msg, client)
+	if ecsResp != nil {
+		ecsResp.SourceScope = uint8(client.ECSScope())
+	}
+	if result != shared.ResultOK {
+		ha.writeResult(w, r, &msg, result)
+		return
+	}
+	msg.Authoritative = true
+	ha.writeMsg(w, r, &msg)
+}
+
+// answer adds the answers to the questions of r to msg.
+// Returns ResultOK if all questions were answered, otherwise the Result to respond with, and msg should be written with writeResult.
+func (ha *Server) answer(r *dns.Msg, msg *dns.Msg, client *shared.Client) shared.Result {
+	clientAddr := client.Addr
 	for _, question := range r.Question {
-		domain := question.Name
+		// names are case-insensitive, and resolvers randomize the case of queries, so everything is looked up lowercase.
+		// The question section is echoed as it was asked.
+		domain := strings.ToLower(question.Name)
+		if entries := ha.Shared.GetStaticDNSEntries(domain); len(entries) > 0 {
+			// static entries take precedence over routing
+			rrs := MakeStaticRRs(domain, entries, question.Qtype)
+			if len(rrs) == 0 {
+				fmt.Println("EVENT: Request: " + clientAddr.String() + " requested " + dns.TypeToString[question.Qtype] + " '" + domain + "' static entry, but it has no records of that type, returning NoData")
+				return shared.ResultNoData
+			}
+			fmt.Println("EVENT: Request: " + clientAddr.String() + " requested " + dns.TypeToString[question.Qtype] + " '" + domain + "' matched static entry, returning")
+			msg.Answer = append(msg.Answer, rrs...)
+			addAuthorityNS(ha.Shared, msg, domain)
+			continue
+		}
 		switch question.Qtype {
-		case dns.TypeA:
-			v4 := true // A record => v4
-			serverAddr, _, _, refuse, servFail := ha.Shared.GetServerForDomain(clientAddr, zone, domain, v4)
-			if servFail {
-				msg.Rcode = dns.RcodeServerFailure
-				w.WriteMsg(&msg)
-				return
-			} else if refuse {
-				msg.Rcode = dns.RcodeRefused
-				w.WriteMsg(&msg)
-				return
+		case dns.TypeA, dns.TypeAAAA:
+			v4 := question.Qtype == dns.TypeA // A record => v4
+			rrs, result := ha.getAddrs(client, domain, v4)
+			if result != shared.ResultOK {
+				return result
 			}
-			msg.Answer = append(msg.Answer, &dns.A{
-				// TODO CRConfig ttl
-				Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
-				A:   net.ParseIP(serverAddr), // TODO change DNSDSServer to store IP
-			})
-		case dns.TypeAAAA:
-			v4 := false // A record => v4
-			serverAddr, _, _, refuse, servFail := ha.Shared.GetServerForDomain(clientAddr, zone, domain, v4)
-			if servFail {
-				msg.Rcode = dns.RcodeServerFailure
-				w.WriteMsg(&msg)
-				return
-			} else if refuse {
-				msg.Rcode = dns.RcodeRefused
-				w.WriteMsg(&msg)
-				return
+			msg.Answer = append(msg.Answer, rrs...)
+			addAuthorityNS(ha.Shared, msg, domain)
+		case dns.TypeSOA, dns.TypeNS:
+			if !ha.Shared.IsCDNDomain(domain) {
+				result := ha.Shared.GetDomainResult(domain)
+				fmt.Println("EVENT: Request: " + clientAddr.String() + " requested " + dns.TypeToString[question.Qtype] + " '" + domain + "' which is not the CDN domain, returning " + result.String())
+				return result
 			}
-			ip := net.ParseIP(serverAddr)
-			fmt.Println("aaaa ip '" + ip.String() + "'")
-			msg.Answer = append(msg.Answer, &dns.AAAA{
-				// TODO CRConfig ttl
-				Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
-				AAAA: net.ParseIP(serverAddr), // TODO change DNSDSServer to store IP
-			})
+			if question.Qtype == dns.TypeSOA {
+				msg.Answer = append(msg.Answer, MakeSOA(ha.Shared))
+				msg.Ns = append(msg.Ns, MakeNS(ha.Shared)...)
+			} else {
+				msg.Answer = append(msg.Answer, MakeNS(ha.Shared)...)
+			}
+			msg.Extra = append(msg.Extra, MakeGlue(ha.Shared)...)
+		case dns.TypeDNSKEY:
+			signer := ha.Shared.GetSigner()
+			if signer == nil || !ha.Shared.IsCDNDomain(domain) {
+				result := ha.Shared.GetDomainResult(domain)
+				fmt.Println("EVENT: Request: " + clientAddr.String() + " requested DNSKEY '" + domain + "', DNSSEC disabled or not the CDN domain, returning " + result.String())
+				return result
+			}
+			msg.Answer = append(msg.Answer, signer.DNSKEYs(ha.Shared.GetDNSKEYTTL())...)
 		case dns.TypeANY:
-			// TODO remove duplicate code
-			{
-				v4 := true // A record => v4
-				serverAddr, _, _, refuse, servFail := ha.Shared.GetServerForDomain(clientAddr, zone, domain, v4)
-				if servFail {
-					msg.Rcode = dns.RcodeServerFailure
-					w.WriteMsg(&msg)
-					return
-				} else if refuse {
-					msg.Rcode = dns.RcodeRefused
-					w.WriteMsg(&msg)
-					return
+			if ha.Shared.IsCDNDomain(domain) {
+				// the apex has no A or AAAA, only the SOA and NS, and the DNSKEYs if DNSSEC is enabled
+				msg.Answer = append(msg.Answer, MakeSOA(ha.Shared))
+				msg.Answer = append(msg.Answer, MakeNS(ha.Shared)...)
+				if signer := ha.Shared.GetSigner(); signer != nil {
+					msg.Answer = append(msg.Answer, signer.DNSKEYs(ha.Shared.GetDNSKEYTTL())...)
 				}
-				msg.Answer = append(msg.Answer, &dns.A{
-					// TODO CRConfig ttl
-					Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
-					A:   net.ParseIP(serverAddr), // TODO change DNSDSServer to store IP
-				})
+				msg.Extra = append(msg.Extra, MakeGlue(ha.Shared)...)
+				continue
 			}
-			{
-				v4 := false // A record => v4
-				serverAddr, _, _, refuse, servFail := ha.Shared.GetServerForDomain(clientAddr, zone, domain, v4)
-				if servFail {
-					msg.Rcode = dns.RcodeServerFailure
-					w.WriteMsg(&msg)
-					return
-				} else if refuse {
-					msg.Rcode = dns.RcodeRefused
-					w.WriteMsg(&msg)
-					return
-				}
-				ip := net.ParseIP(serverAddr)
-				fmt.Println("aaaa ip '" + ip.String() + "'")
-				msg.Answer = append(msg.Answer, &dns.AAAA{
-					// TODO CRConfig ttl
-					Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
-					AAAA: net.ParseIP(serverAddr), // TODO change DNSDSServer to store IP
-				})
+			rrsV4, resultV4 := ha.getAddrs(client, domain, true)
+			if resultV4 != shared.ResultOK && resultV4 != shared.ResultNoData {
+				return resultV4
+			}
+			rrsV6, resultV6 := ha.getAddrs(client, domain, false)
+			if resultV6 != shared.ResultOK && resultV6 != shared.ResultNoData {
+				return resultV6
 			}
+			if resultV4 == shared.ResultNoData && resultV6 == shared.ResultNoData {
+				return shared.ResultNoData
+			}
+			msg.Answer = append(msg.Answer, combineANYAddrs(rrsV4, rrsV6)...)
+			addAuthorityNS(ha.Shared, msg, domain)
 		default:
-			fmt.Println("EVENT: Request: " + clientAddr.String() + " requested: unhandled type") // TODO event log
-			msg.Rcode = dns.RcodeRefused
-			w.WriteMsg(&msg)
-			return
+			result := ha.Shared.GetDomainResult(domain)
+			fmt.Println("EVENT: Request: " + clientAddr.String() + " requested: unhandled type " + dns.TypeToString[question.Qtype] + " '" + domain + "', returning " + result.String()) // TODO event log
+			return result
 		}
 	}
+	return shared.ResultOK
+}
 
-	msg.Authoritative = true
-	w.WriteMsg(&msg)
+// addAuthorityNS adds the CDN domain NS RRset to the authority section of msg, for an answer for domain, as the Java Traffic Router does.
+// Its TTL is the NS TTL of the Delivery Service of domain. If msg already has an authority section, e.g. for an earlier question, nothing is added.
+func addAuthorityNS(sh *shared.Shared, msg *dns.Msg, domain string) {
+	if len(msg.Ns) > 0 {
+		return
+	}
+	msg.Ns = append(msg.Ns, MakeAuthorityNS(sh, domain)...)
+}
+
+// getAddrs returns the A or AAAA records for the given domain, and the Result of looking them up.
+// If no cache is available and the DS has a bypass, the records are the bypass destination, which may be a CNAME.
+// If the Result is not ResultOK, the returned records are nil.
+func (ha *Server) getAddrs(client *shared.Client, domain string, v4 bool) ([]dns.RR, shared.Result) {
+	servers, dsName, ttl, result := ha.Shared.GetServerForDomain(client, domain, v4)
+	if result == shared.ResultBypass {
+		rrs := MakeBypassRRs(domain, ha.Shared.GetDNSBypass(tc.DeliveryServiceName(dsName)), v4, ttl)
+		if len(rrs) == 0 {
+			return nil, shared.ResultNoData // the bypass has no address of this type, and no cname
+		}
+		return rrs, shared.ResultOK
+	}
+	if result != shared.ResultOK {
+		return nil, result
+	}
+	rrs := make([]dns.RR, 0, len(servers))
+	for _, server := range servers {
+		ip := net.ParseIP(server.Addr) // TODO change DNSDSServer to store IP
+		if ip == nil {
+			fmt.Println("ERROR: server '" + string(server.HostName) + "' address '" + server.Addr + "' for '" + domain + "' is not an IP, returning ServFail")
+			return nil, shared.ResultServFail
+		}
+		if v4 {
+			rrs = append(rrs, &dns.A{
+				Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   ip,
+			})
+			continue
+		}
+		rrs = append(rrs, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: ip,
+		})
+	}
+	return rrs, shared.ResultOK
+}
+
+// writeResult writes msg to w, with the Rcode and authority for the given unsuccessful Result.
+//
+// Negative answers in the CDN domain, NXDomain and NoData, are authoritative and include the SOA in the authority section,
+// so resolvers can cache them per RFC 2308. If the client asked for DNSSEC, they also include the NSEC denial of existence.
+//
+func (ha *Server) writeResult(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg, result shared.Result) {
+	opt := msg.IsEdns0() // keep the OPT, it may have an ECS option
+	msg.Answer = nil
+	msg.Extra = nil
+	msg.Ns = nil
+	if opt != nil {
+		msg.Extra = append(msg.Extra, opt)
+	}
+	switch result {
+	case shared.ResultNXDomain, shared.ResultNoData:
+		msg.Rcode = dns.RcodeNameError
+		if result == shared.ResultNoData {
+			msg.Rcode = dns.RcodeSuccess
+		}
+		msg.Authoritative = true
+		qname, qtype := "", uint16(0)
+		if len(r.Question) > 0 {
+			qname, qtype = strings.ToLower(r.Question[0].Name), r.Question[0].Qtype
+		}
+		soa := MakeNegativeSOA(ha.Shared, qname)
+		msg.Ns = append(msg.Ns, soa)
+		if ha.Shared.GetSigner() != nil && dnssecOK(r) && len(r.Question) > 0 {
+			// NSEC TTLs are the SOA minimum, like the negative SOA (RFC 4034§4)
+			msg.Ns = append(msg.Ns, MakeDenial(ha.Shared, qname, qtype, result, soa.Hdr.Ttl)...)
+		}
+	case shared.ResultRefused:
+		msg.Rcode = dns.RcodeRefused
+	default:
+		msg.Rcode = dns.RcodeServerFailure
+	}
+	ha.writeMsg(w, r, msg)
+}
+
+// writeMsg writes the response msg to the request r.
+//
+// If r has EDNS, the response does too. If r has the DO bit and DNSSEC is enabled, the response is signed.
+// UDP responses are truncated to the requested payload size.
+//
+func (ha *Server) writeMsg(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) {
+	maxSize := dns.MinMsgSize
+	if reqOpt := r.IsEdns0(); reqOpt != nil {
+		if reqOpt.UDPSize() > uint16(maxSize) {
+			maxSize = int(reqOpt.UDPSize())
+		}
+		if maxSize > DefaultEDNSUDPSize {
+			maxSize = DefaultEDNSUDPSize
+		}
+		if signer := ha.Shared.GetSigner(); signer != nil && reqOpt.Do() && msg.Rcode != dns.RcodeRefused && msg.Rcode != dns.RcodeServerFailure && msg.Rcode != dns.RcodeFormatError {
+			if !signMsg(signer, msg) {
+				opt := msg.IsEdns0()
+				msg.Answer = nil
+				msg.Ns = nil
+				msg.Extra = nil
+				if opt != nil {
+					msg.Extra = append(msg.Extra, opt)
+				}
+				msg.Authoritative = false
+				msg.Rcode = dns.RcodeServerFailure
+			}
+		}
+		// the handler may have already added an OPT, e.g. for ECS
+		if opt := msg.IsEdns0(); opt != nil {
+			opt.SetUDPSize(DefaultEDNSUDPSize)
+			opt.SetDo(reqOpt.Do())
+		} else {
+			msg.SetEdns0(DefaultEDNSUDPSize, reqOpt.Do())
+		}
+	}
+	if _, isTCP := w.RemoteAddr().(*net.TCPAddr); isTCP {
+		maxSize = dns.MaxMsgSize
+	}
+	msg.Truncate(maxSize)
+	w.WriteMsg(msg)
 }
@@ -0,0 +1,301 @@
+package srvdns
+
+import (
+	"crypto"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+	"github.com/rob05c/traffic_router/dnssec"
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// testWriter is a dns.ResponseWriter which keeps the written message.
+type testWriter struct {
+	msg *dns.Msg
+}
+
+func (tw *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+}
+func (tw *testWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 5353}
+}
+func (tw *testWriter) WriteMsg(msg *dns.Msg) error { tw.msg = msg; return nil }
+func (tw *testWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (tw *testWriter) Close() error                { return nil }
+func (tw *testWriter) TsigStatus() error           { return nil }
+func (tw *testWriter) TsigTimersOnly(bool)         {}
+func (tw *testWriter) Hijack()                     {}
+
+// newTestServer returns a Server of the shared package test CRConfig, for the CDN domain cdn.example.net, with DNSSEC if dnssecEnabled.
+// Every cache is available.
+func newTestServer(t *testing.T, dnssecEnabled bool) *Server {
+	t.Helper()
+	return newTestServerWith(t, dnssecEnabled, nil)
+}
+
+// newTestServerWith returns a Server as newTestServer, after calling modify, if it isn't nil, on the CRConfig and CRStates.
+func newTestServerWith(t *testing.T, dnssecEnabled bool, modify func(crc *tc.CRConfig, crs *tc.CRStates)) *Server {
+	t.Helper()
+	bts, err := ioutil.ReadFile("../shared/testdata/crconfig.json")
+	if err != nil {
+		t.Fatalf("reading test CRConfig: %v", err)
+	}
+	crc := &tc.CRConfig{}
+	if err := json.Unmarshal(bts, crc); err != nil {
+		t.Fatalf("decoding test CRConfig: %v", err)
+	}
+	cz, err := czf.LoadCZF("../shared/testdata/czf.json")
+	if err != nil {
+		t.Fatalf("loading test CZF: %v", err)
+	}
+	parsedCZF, err := czf.Parse(cz)
+	if err != nil {
+		t.Fatalf("parsing test CZF: %v", err)
+	}
+	crs := &tc.CRStates{Caches: map[tc.CacheName]tc.IsAvailable{}}
+	for name := range crc.ContentServers {
+		crs.Caches[tc.CacheName(name)] = tc.IsAvailable{IsAvailable: true}
+	}
+	if modify != nil {
+		modify(crc, crs)
+	}
+
+	keys := (*dnssec.Keys)(nil)
+	if dnssecEnabled {
+		keys = &dnssec.Keys{Zone: "cdn.example.net.", KSKs: []dnssec.Key{newTestKey(t, 257)}, ZSKs: []dnssec.Key{newTestKey(t, 256)}}
+	}
+	sh := shared.NewShared(parsedCZF, nil, nil, crc, crs, nil, keys)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	return &Server{Shared: sh}
+}
+
+func newTestKey(t *testing.T, flags uint16) dnssec.Key {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "cdn.example.net.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return dnssec.Key{DNSKEY: key, Private: priv.(crypto.Signer)}
+}
+
+// query serves a query for name and qtype, with the DO bit if do, and returns the response.
+func query(t *testing.T, sv *Server, name string, qtype uint16, do bool) *dns.Msg {
+	t.Helper()
+	req := &dns.Msg{}
+	req.SetQuestion(name, qtype)
+	if do {
+		req.SetEdns0(4096, true)
+	}
+	tw := &testWriter{}
+	sv.ServeDNS(tw, req)
+	if tw.msg == nil {
+		t.Fatalf("%s %s: no response written", dns.TypeToString[qtype], name)
+	}
+	return tw.msg
+}
+
+// answerTypes returns the set of record types in the answer section.
+func answerTypes(msg *dns.Msg) map[uint16]bool {
+	types := map[uint16]bool{}
+	for _, rr := range msg.Answer {
+		types[rr.Header().Rrtype] = true
+	}
+	return types
+}
+
+func TestServeDNSApexANY(t *testing.T) {
+	tests := []struct {
+		name          string
+		dnssecEnabled bool
+		do            bool
+		expected      []uint16
+		unexpected    []uint16
+	}{
+		{"dnssec disabled", false, false, []uint16{dns.TypeSOA, dns.TypeNS}, []uint16{dns.TypeDNSKEY, dns.TypeRRSIG}},
+		{"dnssec enabled", true, false, []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY}, []uint16{dns.TypeRRSIG}},
+		{"dnssec enabled, DO", true, true, []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY, dns.TypeRRSIG}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := query(t, newTestServer(t, test.dnssecEnabled), "cdn.example.net.", dns.TypeANY, test.do)
+			if resp.Rcode != dns.RcodeSuccess {
+				t.Fatalf("rcode %s, expected NOERROR", dns.RcodeToString[resp.Rcode])
+			}
+			types := answerTypes(resp)
+			for _, rrType := range test.expected {
+				if !types[rrType] {
+					t.Errorf("answer has no %s, expected the apex %s set", dns.TypeToString[rrType], dns.TypeToString[rrType])
+				}
+			}
+			for _, rrType := range test.unexpected {
+				if types[rrType] {
+					t.Errorf("answer has %s, expected none", dns.TypeToString[rrType])
+				}
+			}
+		})
+	}
+}
+
+func TestServeDNSCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		name          string
+		qtype         uint16
+		expectedRcode int
+		expectAnswer  bool
+	}{
+		{"EdGe.DnSdS.CdN.ExAmPlE.NeT.", dns.TypeA, dns.RcodeSuccess, true},
+		{"CCR.HTTPDS.CDN.EXAMPLE.NET.", dns.TypeA, dns.RcodeSuccess, true},
+		{"Tr01.Cdn.Example.Net.", dns.TypeAAAA, dns.RcodeSuccess, true},
+		{"WWW.dnsds.cdn.example.net.", dns.TypeA, dns.RcodeSuccess, true},
+		{"Cdn.Example.Net.", dns.TypeSOA, dns.RcodeSuccess, true},
+		{"Cdn.Example.Net.", dns.TypeDNSKEY, dns.RcodeSuccess, true},
+		{"HttpDS.Cdn.Example.Net.", dns.TypeA, dns.RcodeSuccess, false}, // empty non-terminal, NoData
+		{"Nope.Cdn.Example.Net.", dns.TypeA, dns.RcodeNameError, false},
+		{"Other.Example.Org.", dns.TypeA, dns.RcodeRefused, false},
+	}
+	sv := newTestServer(t, true)
+	for _, test := range tests {
+		resp := query(t, sv, test.name, test.qtype, true)
+		if resp.Rcode != test.expectedRcode {
+			t.Errorf("%s %s: rcode %s, expected %s", dns.TypeToString[test.qtype], test.name, dns.RcodeToString[resp.Rcode], dns.RcodeToString[test.expectedRcode])
+		}
+		if hasAnswer := answerTypes(resp)[test.qtype]; hasAnswer != test.expectAnswer {
+			t.Errorf("%s %s: has answer %t, expected %t", dns.TypeToString[test.qtype], test.name, hasAnswer, test.expectAnswer)
+		}
+		if len(resp.Question) != 1 || resp.Question[0].Name != test.name {
+			t.Errorf("%s %s: question %v, expected the name echoed as asked", dns.TypeToString[test.qtype], test.name, resp.Question)
+		}
+	}
+}
+
+func TestServeDNSNoDataNSEC(t *testing.T) {
+	// the bypass only has an IPv4 address, so AAAA requests which fall back to it are NoData
+	aOnlyBypass := func(crc *tc.CRConfig) {
+		crc.DeliveryServices["dnsds"].BypassDestination[shared.BypassDestinationDNS].CName = nil
+	}
+	tests := []struct {
+		name   string
+		modify func(crc *tc.CRConfig, crs *tc.CRStates)
+	}{
+		{
+			name: "caches unavailable",
+			modify: func(crc *tc.CRConfig, crs *tc.CRStates) {
+				aOnlyBypass(crc)
+				for name := range crs.Caches {
+					crs.Caches[name] = tc.IsAvailable{IsAvailable: false}
+				}
+			},
+		},
+		{
+			name: "no IPv6 caches",
+			modify: func(crc *tc.CRConfig, crs *tc.CRStates) {
+				aOnlyBypass(crc)
+				edge1 := crc.ContentServers["edge1"]
+				edge1.Ip6 = nil
+				crc.ContentServers["edge1"] = edge1
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sv := newTestServerWith(t, true, test.modify)
+			resp := query(t, sv, "edge.dnsds.cdn.example.net.", dns.TypeAAAA, true)
+			if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+				t.Fatalf("rcode %s answers %v, expected NoData", dns.RcodeToString[resp.Rcode], resp.Answer)
+			}
+			nsecs := []dns.RR{}
+			rrsig := (*dns.RRSIG)(nil)
+			for _, rr := range resp.Ns {
+				switch rr := rr.(type) {
+				case *dns.NSEC:
+					nsecs = append(nsecs, rr)
+				case *dns.RRSIG:
+					if rr.TypeCovered == dns.TypeNSEC {
+						rrsig = rr
+					}
+				}
+			}
+			if len(nsecs) != 1 || rrsig == nil {
+				t.Fatalf("authority %v, expected one signed NSEC", resp.Ns)
+			}
+			nsec := nsecs[0].(*dns.NSEC)
+			if nsec.Hdr.Name != "edge.dnsds.cdn.example.net." {
+				t.Errorf("NSEC name '%s', expected the requested name", nsec.Hdr.Name)
+			}
+			hasA := false
+			for _, rrType := range nsec.TypeBitMap {
+				if rrType == dns.TypeAAAA {
+					t.Errorf("NSEC types %v include the denied AAAA", nsec.TypeBitMap)
+				}
+				hasA = hasA || rrType == dns.TypeA
+			}
+			if !hasA {
+				t.Errorf("NSEC types %v, expected A, which the bypass has", nsec.TypeBitMap)
+			}
+			verified := false
+			for _, rr := range sv.Shared.GetSigner().DNSKEYs(3600) {
+				if key := rr.(*dns.DNSKEY); key.KeyTag() == rrsig.KeyTag {
+					if err := rrsig.Verify(key, nsecs); err != nil {
+						t.Errorf("verifying NSEC RRSIG: %v", err)
+					}
+					verified = true
+				}
+			}
+			if !verified {
+				t.Errorf("no DNSKEY with the NSEC RRSIG key tag %d", rrsig.KeyTag)
+			}
+		})
+	}
+}
+
+func TestServeDNSAuthorityNSTTL(t *testing.T) {
+	sv := newTestServerWith(t, false, func(crc *tc.CRConfig, crs *tc.CRStates) {
+		ns := "120"
+		crc.DeliveryServices["dnsds"].TTLs.NSSeconds = &ns
+	})
+	tests := []struct {
+		name        string
+		qtype       uint16
+		expectedTTL uint32
+	}{
+		{"edge.dnsds.cdn.example.net.", dns.TypeA, 120},  // the DS NS ttl
+		{"www.dnsds.cdn.example.net.", dns.TypeA, 120},   // static entries are in the DS too
+		{"ccr.httpds.cdn.example.net.", dns.TypeA, 3600}, // httpds has no NS ttl, so the CRConfig config/ttls NS
+		{"tr01.cdn.example.net.", dns.TypeAAAA, 3600},
+	}
+	for _, test := range tests {
+		resp := query(t, sv, test.name, test.qtype, false)
+		if len(resp.Answer) == 0 || len(resp.Ns) == 0 {
+			t.Errorf("%s %s: answer %v authority %v, expected both", dns.TypeToString[test.qtype], test.name, resp.Answer, resp.Ns)
+			continue
+		}
+		for _, rr := range resp.Ns {
+			if rr.Header().Rrtype != dns.TypeNS || rr.Header().Name != "cdn.example.net." || rr.Header().Ttl != test.expectedTTL {
+				t.Errorf("%s %s: authority %v, expected the CDN domain NS with TTL %d", dns.TypeToString[test.qtype], test.name, rr, test.expectedTTL)
+			}
+		}
+	}
+
+	// the apex NS answer isn't in a DS, so it always has the config NS TTL
+	resp := query(t, sv, "cdn.example.net.", dns.TypeNS, false)
+	for _, rr := range resp.Answer {
+		if rr.Header().Ttl != 3600 {
+			t.Errorf("apex NS TTL %d, expected the config ttl 3600", rr.Header().Ttl)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package srvdns
+
+import (
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// MakeSOA returns the SOA record of the CDN domain.
+func MakeSOA(sh *shared.Shared) *dns.SOA {
+	soa := sh.GetSOA()
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(sh.GetCDNDomain()), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soa.TTL},
+		Ns:      dns.Fqdn(soa.PrimaryNS),
+		Mbox:    dns.Fqdn(soa.Admin),
+		Serial:  soa.Serial,
+		Refresh: soa.Refresh,
+		Retry:   soa.Retry,
+		Expire:  soa.Expire,
+		Minttl:  soa.Minimum,
+	}
+}
+
+// MakeNS returns the NS records of the CDN domain.
+func MakeNS(sh *shared.Shared) []dns.RR {
+	return makeNS(sh, sh.GetNameServers().TTL)
+}
+
+// MakeAuthorityNS returns the NS records of the CDN domain, for the authority section of answers for qname.
+// If qname is in a Delivery Service, their TTL is the Delivery Service's NS TTL.
+func MakeAuthorityNS(sh *shared.Shared, qname string) []dns.RR {
+	return makeNS(sh, sh.GetNameTTLs(qname).NS)
+}
+
+func makeNS(sh *shared.Shared, ttl uint32) []dns.RR {
+	zone := dns.Fqdn(sh.GetCDNDomain())
+	rrs := []dns.RR{}
+	for _, ns := range sh.GetNameServers().Servers {
+		rrs = append(rrs, &dns.NS{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  dns.Fqdn(ns.FQDN),
+		})
+	}
+	return rrs
+}
+
+// MakeGlue returns the A and AAAA records of the CDN domain's name servers, for the additional section.
+func MakeGlue(sh *shared.Shared) []dns.RR {
+	nameServers := sh.GetNameServers()
+	rrs := []dns.RR{}
+	for _, ns := range nameServers.Servers {
+		if ns.IP != nil {
+			rrs = append(rrs, &dns.A{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(ns.FQDN), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: nameServers.TTL},
+				A:   ns.IP,
+			})
+		}
+		if ns.IP6 != nil {
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: dns.Fqdn(ns.FQDN), Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: nameServers.TTL},
+				AAAA: ns.IP6,
+			})
+		}
+	}
+	return rrs
+}
+
+// MakeNegativeSOA returns the SOA record of the CDN domain, for the authority section of NXDomain and NoData responses for qname.
+// Per RFC 2308§3, its TTL is the lesser of the SOA TTL and the SOA minimum, which resolvers use as the negative cache TTL.
+// If qname is in a Delivery Service, the SOA TTL is the Delivery Service's.
+func MakeNegativeSOA(sh *shared.Shared, qname string) *dns.SOA {
+	soa := MakeSOA(sh)
+	soa.Hdr.Ttl = sh.GetNameTTLs(qname).SOA
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
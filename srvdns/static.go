@@ -0,0 +1,67 @@
+package srvdns
+
+import (
+	"strings"
+
+	"github.com/rob05c/traffic_router/shared"
+
+	"github.com/miekg/dns"
+)
+
+// maxTXTStringLen is the maximum length of a TXT character-string. Longer TXT values are split into multiple strings.
+const maxTXTStringLen = 255
+
+// MakeStaticRRs returns the records of the static entries at domain for the requested type.
+//
+// If the entries are a CNAME, it's returned for all types, per RFC 1034§3.6.2. ANY returns all entries.
+// Returns no records if the name has no entries of the requested type, which is a NoData.
+//
+func MakeStaticRRs(domain string, entries []shared.StaticDNSEntry, qtype uint16) []dns.RR {
+	rrs := []dns.RR{}
+	for _, entry := range entries {
+		rrType := dns.StringToType[entry.Type]
+		if qtype != rrType && qtype != dns.TypeANY && rrType != dns.TypeCNAME {
+			continue
+		}
+		hdr := dns.RR_Header{Name: domain, Rrtype: rrType, Class: dns.ClassINET, Ttl: entry.TTL}
+		switch entry.Type {
+		case shared.StaticDNSEntryTypeA:
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: entry.IP})
+		case shared.StaticDNSEntryTypeAAAA:
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: entry.IP})
+		case shared.StaticDNSEntryTypeCNAME:
+			rrs = append(rrs, &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(entry.Value)})
+		case shared.StaticDNSEntryTypeTXT:
+			rrs = append(rrs, &dns.TXT{Hdr: hdr, Txt: splitTXT(entry.Value)})
+		}
+	}
+	return rrs
+}
+
+// MakeStaticTypes returns the record types of the given static entries, for the NSEC type bitmap.
+func MakeStaticTypes(entries []shared.StaticDNSEntry) []uint16 {
+	types := []uint16{}
+	seen := map[uint16]struct{}{}
+	for _, entry := range entries {
+		rrType := dns.StringToType[entry.Type]
+		if _, ok := seen[rrType]; ok {
+			continue
+		}
+		seen[rrType] = struct{}{}
+		types = append(types, rrType)
+	}
+	return types
+}
+
+// splitTXT splits the TXT value into character-strings of at most maxTXTStringLen octets.
+// The value is raw text from the CRConfig, but the dns library expects presentation format, so each string is escaped.
+func splitTXT(val string) []string {
+	strs := []string{}
+	for len(val) > maxTXTStringLen {
+		strs = append(strs, txtEscaper.Replace(val[:maxTXTStringLen]))
+		val = val[maxTXTStringLen:]
+	}
+	return append(strs, txtEscaper.Replace(val))
+}
+
+var txtEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
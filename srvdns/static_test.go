@@ -0,0 +1,65 @@
+package srvdns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSStatic(t *testing.T) {
+	// the dnsds static entries are www A and AAAA, txt TXT, and alias CNAME origin.example.org.
+	tests := []struct {
+		name          string
+		qtype         uint16
+		expectedRcode int
+		expected      []string
+	}{
+		{"www.dnsds.cdn.example.net.", dns.TypeA, dns.RcodeSuccess, []string{"www.dnsds.cdn.example.net.\t300\tIN\tA\t192.0.2.10"}},
+		{"WWW.dnsds.cdn.example.net.", dns.TypeAAAA, dns.RcodeSuccess, []string{"www.dnsds.cdn.example.net.\t300\tIN\tAAAA\t2001:db8::10"}},
+		{"www.dnsds.cdn.example.net.", dns.TypeANY, dns.RcodeSuccess, []string{
+			"www.dnsds.cdn.example.net.\t300\tIN\tA\t192.0.2.10",
+			"www.dnsds.cdn.example.net.\t300\tIN\tAAAA\t2001:db8::10",
+		}},
+		{"www.dnsds.cdn.example.net.", dns.TypeTXT, dns.RcodeSuccess, nil}, // NoData
+		{"txt.dnsds.cdn.example.net.", dns.TypeTXT, dns.RcodeSuccess, []string{"txt.dnsds.cdn.example.net.\t60\tIN\tTXT\t\"v=spf1 \\\"quoted\\\" -all\""}},
+		{"alias.dnsds.cdn.example.net.", dns.TypeA, dns.RcodeSuccess, []string{"alias.dnsds.cdn.example.net.\t120\tIN\tCNAME\torigin.example.org."}},
+		{"alias.dnsds.cdn.example.net.", dns.TypeAAAA, dns.RcodeSuccess, []string{"alias.dnsds.cdn.example.net.\t120\tIN\tCNAME\torigin.example.org."}},
+	}
+	sv := newTestServer(t, false)
+	for _, test := range tests {
+		t.Run(test.name+" "+dns.TypeToString[test.qtype], func(t *testing.T) {
+			resp := query(t, sv, test.name, test.qtype, false)
+			if resp.Rcode != test.expectedRcode {
+				t.Fatalf("rcode %s, expected %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[test.expectedRcode])
+			}
+			answers := []string{}
+			for _, rr := range resp.Answer {
+				answers = append(answers, rr.String())
+			}
+			if strings.Join(answers, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("answers %v, expected %v", answers, test.expected)
+			}
+			if len(test.expected) == 0 && (len(resp.Ns) == 0 || resp.Ns[0].Header().Rrtype != dns.TypeSOA) {
+				t.Errorf("NoData authority %v, expected the SOA", resp.Ns)
+			}
+		})
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	long := strings.Repeat("a", maxTXTStringLen) + strings.Repeat("b", 10)
+	tests := []struct {
+		val      string
+		expected []string
+	}{
+		{"", []string{""}},
+		{`say "hi" \o/`, []string{`say \"hi\" \\o/`}},
+		{long, []string{strings.Repeat("a", maxTXTStringLen), strings.Repeat("b", 10)}},
+	}
+	for _, test := range tests {
+		if got := splitTXT(test.val); strings.Join(got, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("splitTXT('%s') = %v, expected %v", test.val, got, test.expected)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/rob05c/traffic_router/rfc"
 	"github.com/rob05c/traffic_router/shared"
@@ -22,7 +23,6 @@ func (sv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	clientAddrStr := r.RemoteAddr
 	// func ResolveIPAddr(network, address string) (*IPAddr, error)
 
-	zone := "" // zone == cachegroup
 	ipStr, _, err := net.SplitHostPort(clientAddrStr)
 	if err != nil {
 		// TODO SERVFAIL here
@@ -36,7 +36,6 @@ func (sv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	zone = sv.Shared.GetCZF().GetZone(ip)
 
 	clientAddr, err := net.ResolveIPAddr("ip", ipStr)
 	if err != nil {
@@ -49,28 +48,27 @@ func (sv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// TODO determine how to handle requests with ports (as-is, they'll be rejected as not matching any DS)
 	// requestedDomain, _, err := net.SplitHostPort(r.Host)
-	requestedDomain := r.Host
+	requestedDomain := strings.ToLower(r.Host) // names are case-insensitive, and routing looks them up lowercase
 	if err != nil {
 		fmt.Println("ERROR: failed to parse client requested url '" + r.Host + "' addr '" + clientAddrStr + "', returning Internal Server Error")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	requestedDomain += "." //TODO change Shared.GetServerForDomain to not expect the . and remove it with DNS
-
-	_, cacheHostName, dsName, refuse, servFail := sv.Shared.GetServerForDomain(clientAddr, zone, requestedDomain, isV4)
-	if servFail {
-		// GetServerForDomain already logged. // TODO change to return err instead of logging itself
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if refuse {
+	server, dsName, result := sv.Shared.GetServerForHTTP(&shared.Client{Addr: clientAddr, IP: ip}, requestedDomain, r.URL, isV4)
+	switch result {
+	case shared.ResultOK:
+	case shared.ResultRefused, shared.ResultNXDomain:
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, "This server does not handle requested domain.")
 		return
+	default:
+		// GetServerForHTTP already logged. // TODO change to return err instead of logging itself
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	redirectFQDN := cacheHostName + "." + dsName + "." + sv.Shared.GetCDNDomain()
+	redirectFQDN := string(server.HostName) + "." + dsName + "." + sv.Shared.GetCDNDomain()
 	redirectURL := "http://" + redirectFQDN + r.URL.Path // TODO add HTTPS support, for HTTPS DSes
 	if r.URL.RawQuery != "" {
 		redirectURL += "?" + r.URL.RawQuery
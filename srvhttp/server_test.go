@@ -0,0 +1,111 @@
+package srvhttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/czf"
+	"github.com/rob05c/traffic_router/shared"
+)
+
+// newTestServer returns a Server of the shared package test CRConfig, for the CDN domain cdn.example.net, with the caches available in crs.
+// If crs is nil, every cache is available.
+func newTestServer(t *testing.T, crs *tc.CRStates) *Server {
+	t.Helper()
+	bts, err := ioutil.ReadFile("../shared/testdata/crconfig.json")
+	if err != nil {
+		t.Fatalf("reading test CRConfig: %v", err)
+	}
+	crc := &tc.CRConfig{}
+	if err := json.Unmarshal(bts, crc); err != nil {
+		t.Fatalf("decoding test CRConfig: %v", err)
+	}
+	cz, err := czf.LoadCZF("../shared/testdata/czf.json")
+	if err != nil {
+		t.Fatalf("loading test CZF: %v", err)
+	}
+	parsedCZF, err := czf.Parse(cz)
+	if err != nil {
+		t.Fatalf("parsing test CZF: %v", err)
+	}
+	if crs == nil {
+		crs = &tc.CRStates{Caches: map[tc.CacheName]tc.IsAvailable{}}
+		for name := range crc.ContentServers {
+			crs.Caches[tc.CacheName(name)] = tc.IsAvailable{IsAvailable: true}
+		}
+	}
+	sh := shared.NewShared(parsedCZF, nil, nil, crc, crs, nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	return New(sh)
+}
+
+func serve(sv *Server, remoteAddr string, host string, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.RemoteAddr = remoteAddr
+	r.Host = host
+	w := httptest.NewRecorder()
+	sv.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeHTTP(t *testing.T) {
+	// httpds has edge1 and edge2, both in cg-east, and only edge1 has an IPv6 address.
+	tests := []struct {
+		name             string
+		remoteAddr       string
+		host             string
+		target           string
+		expectedCode     int
+		expectedLocation []string
+	}{
+		{"redirect", "10.0.0.5:1234", "ccr.httpds.cdn.example.net", "/a/b.mp4?id=1", http.StatusFound, []string{
+			"http://edge1.httpds.cdn.example.net/a/b.mp4?id=1",
+			"http://edge2.httpds.cdn.example.net/a/b.mp4?id=1",
+		}},
+		{"mixed case host", "10.0.0.5:1234", "CCR.HttpDS.cdn.example.net", "/a/b.mp4", http.StatusFound, []string{
+			"http://edge1.httpds.cdn.example.net/a/b.mp4",
+			"http://edge2.httpds.cdn.example.net/a/b.mp4",
+		}},
+		{"ipv6 client gets an ipv6 cache", "[::1]:1234", "ccr.httpds.cdn.example.net", "/a/b.mp4", http.StatusFound, []string{
+			"http://edge1.httpds.cdn.example.net/a/b.mp4",
+		}},
+		{"not an http ds", "10.0.0.5:1234", "edge.dnsds.cdn.example.net", "/", http.StatusBadRequest, nil},
+		{"outside the cdn", "10.0.0.5:1234", "www.example.org", "/", http.StatusBadRequest, nil},
+		{"malformed client address", "10.0.0.5", "ccr.httpds.cdn.example.net", "/", http.StatusInternalServerError, nil},
+	}
+	sv := newTestServer(t, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(sv, test.remoteAddr, test.host, test.target)
+			if w.Code != test.expectedCode {
+				t.Fatalf("status %d, expected %d", w.Code, test.expectedCode)
+			}
+			if test.expectedLocation == nil {
+				if location := w.Header().Get("Location"); location != "" {
+					t.Errorf("Location '%s', expected none", location)
+				}
+				return
+			}
+			location := w.Header().Get("Location")
+			for _, expected := range test.expectedLocation {
+				if location == expected {
+					return
+				}
+			}
+			t.Errorf("Location '%s', expected one of %s", location, strings.Join(test.expectedLocation, ", "))
+		})
+	}
+
+	// with no available cache, there's nowhere to redirect
+	down := newTestServer(t, &tc.CRStates{})
+	if w := serve(down, "10.0.0.5:1234", "ccr.httpds.cdn.example.net", "/a/b.mp4"); w.Code != http.StatusInternalServerError {
+		t.Errorf("no available caches: status %d, expected %d", w.Code, http.StatusInternalServerError)
+	}
+}
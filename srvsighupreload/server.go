@@ -6,10 +6,14 @@ import (
 	"golang.org/x/sys/unix"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	"github.com/rob05c/traffic_router/config"
+	"github.com/rob05c/traffic_router/crconfigdiff"
+	"github.com/rob05c/traffic_router/guard"
 	"github.com/rob05c/traffic_router/loadconfig"
+	"github.com/rob05c/traffic_router/monitorclient"
 	"github.com/rob05c/traffic_router/poller"
 	"github.com/rob05c/traffic_router/pollercrconfig"
 	"github.com/rob05c/traffic_router/pollercrstates"
@@ -24,6 +28,7 @@ import (
 // If there is an error loading the config file, the error is logged, and the existing server is left unchanged.
 func Listen(
 	filename string,
+	sharedPtr *shared.Ptr,
 	dnsServer *srvdns.ServerPtr,
 	httpServer *srvhttp.ServerPtr,
 	certGetter *srvhttp.CertGetter,
@@ -31,6 +36,8 @@ func Listen(
 	crStatesIPoller *pollercrstates.IPoller,
 	crConfigPoller *poller.Poller,
 	crConfigIPoller *pollercrconfig.IPoller,
+	crcGuard *guard.Guard,
+	crcDiffs *crconfigdiff.History,
 ) {
 	// TODO add the abiliity to change ports.
 	//      (will require stopping the old servers and creating new ones, presumably passing in pointers to them)
@@ -40,6 +47,7 @@ func Listen(
 	for range c {
 		TryReloadConfig(
 			filename,
+			sharedPtr,
 			dnsServer,
 			httpServer,
 			certGetter,
@@ -47,6 +55,8 @@ func Listen(
 			crStatesIPoller,
 			crConfigPoller,
 			crConfigIPoller,
+			crcGuard,
+			crcDiffs,
 		)
 	}
 }
@@ -55,6 +65,7 @@ func Listen(
 // On error, logs but leaves the servers serving what they were before, does not crash or stop.
 func TryReloadConfig(
 	fileName string,
+	sharedPtr *shared.Ptr,
 	dnsServer *srvdns.ServerPtr,
 	httpServer *srvhttp.ServerPtr,
 	certGetter *srvhttp.CertGetter,
@@ -62,22 +73,70 @@ func TryReloadConfig(
 	crStatesIPoller *pollercrstates.IPoller,
 	crConfigPoller *poller.Poller,
 	crConfigIPoller *pollercrconfig.IPoller,
-
+	crcGuard *guard.Guard,
+	crcDiffs *crconfigdiff.History,
 ) {
-	shared, cfg, err := loadconfig.LoadConfig(fileName)
+	sh, cfg, err := loadconfig.LoadConfig(fileName)
 	if err != nil {
 		fmt.Println("ERROR: reloading config file '" + fileName + "' new config not updated! : " + err.Error())
 		return
 	}
 
-	UpdateCerts(shared.GetCerts(), certGetter)
-	UpdateCRStatesPoller(crStatesPoller, crStatesIPoller, cfg, shared)
-	UpdateCRConfigPoller(crConfigPoller, crConfigIPoller, cfg, shared)
-	dnsServer.Set(&srvdns.Server{Shared: shared})
-	httpServer.Set(&srvhttp.Server{Shared: shared})
+	// The pollers are stopped before anything is swapped. A CRConfig poll in progress built its snapshot from the old Shared's
+	// CZF, Deep CZF, geo, certs, and DNSSEC keys, and would otherwise swap it in after the reload, silently undoing it.
+	// Stop waits for a poll in progress to finish, so nothing polls until the pollers are restarted below.
+	stopPoller(crStatesPoller, "CRStates")
+	stopPoller(crConfigPoller, "CRConfig")
+
+	crcGuard.SetMaxDropPercent(cfg.CRConfigGuardMaxDropPercent)
+	overridden, err := crcGuard.Check(sharedPtr.Get().GetCRConfig(), sh.GetCRConfig())
+	if err != nil {
+		fmt.Println("ERROR: reloading config file '" + fileName + "' new config not updated! : " + err.Error())
+		startPoller(crStatesPoller, "CRStates")
+		startPoller(crConfigPoller, "CRConfig")
+		return
+	}
+
+	// A new monitor client drops the validators of the last polled CRConfig and CRStates,
+	// so the next poll re-applies them on top of the reloaded files, rather than getting a 304 and serving the files.
+	monitorClient := monitorclient.New(time.Duration(cfg.MonitorConnectTimeoutMS)*time.Millisecond, time.Duration(cfg.MonitorTimeoutMS)*time.Millisecond)
+
+	UpdateCerts(sh.GetCerts(), certGetter)
+	Swap(sh, sharedPtr, dnsServer, httpServer, crcDiffs, "reload")
+	if overridden {
+		crcGuard.UseOverride()
+	}
+	// the pollers share the monitors, so setting them on one sets both
+	crConfigIPoller.Monitors.SetSeed(cfg.Monitors)
+	crConfigIPoller.Monitors.SetFromCRConfig(sh.GetCRConfig())
+	UpdateCRStatesPoller(crStatesPoller, crStatesIPoller, cfg, monitorClient)
+	UpdateCRConfigPoller(crConfigPoller, crConfigIPoller, cfg, monitorClient)
 	fmt.Println("INFO reloaded config file")
 }
 
+// swapMutex serializes Swap, so concurrent swaps can't interleave.
+var swapMutex sync.Mutex
+
+// Swap atomically starts serving the given Shared, on both the DNS and HTTP servers, and for the pollers to update.
+// This is used both by config reloads, and by the CRConfig poller when it builds a new routing snapshot.
+// The pointers are set individually, so a request may briefly be served by the old Shared after the pollers have the new one, which is harmless.
+//
+// The diff of the old and new CRConfigs is logged and added to crcDiffs. The source is where the new Shared came from, for the diff.
+//
+// Swaps are serialized, so each diff is against the Shared it actually replaced, and the last Swap called is the Shared served on every pointer.
+// Serializing can't stop a Shared built from an older one from replacing a newer one, so callers must not build and swap concurrently:
+// config reloads stop the pollers before swapping.
+//
+func Swap(sh *shared.Shared, sharedPtr *shared.Ptr, dnsServer *srvdns.ServerPtr, httpServer *srvhttp.ServerPtr, crcDiffs *crconfigdiff.History, source string) {
+	swapMutex.Lock()
+	defer swapMutex.Unlock()
+	oldCRC := sharedPtr.Get().GetCRConfig()
+	sharedPtr.Set(sh)
+	dnsServer.Set(&srvdns.Server{Shared: sh})
+	httpServer.Set(&srvhttp.Server{Shared: sh})
+	crcDiffs.Log(crconfigdiff.New(oldCRC, sh.GetCRConfig(), source))
+}
+
 // UpdateCerts updates certGetter with certs, deleting certs in the getter and not in certs, and adding to the getter new certificates in certs but not in certGetter.
 func UpdateCerts(certs map[string]*tls.Certificate, certGetter *srvhttp.CertGetter) {
 	hosts := certGetter.Hosts()
@@ -94,32 +153,31 @@ func UpdateCerts(certs map[string]*tls.Certificate, certGetter *srvhttp.CertGett
 	}
 }
 
-func UpdateCRStatesPoller(crStatesPoller *poller.Poller, crStatesIPoller *pollercrstates.IPoller, cfg *config.Config, shared *shared.Shared) {
-	if err := crStatesPoller.Stop(); err != nil {
-		fmt.Println("ERROR: updating CRStates Poller: stopping: " + err.Error())
-	}
-
-	crStatesIPoller.Monitors = cfg.Monitors
-	crStatesIPoller.Shared = shared
+// UpdateCRStatesPoller sets the config and monitor client of the CRStates poller, and starts it. The poller must be stopped.
+func UpdateCRStatesPoller(crStatesPoller *poller.Poller, crStatesIPoller *pollercrstates.IPoller, cfg *config.Config, monitorClient *monitorclient.Client) {
+	crStatesIPoller.Client = monitorClient
+	crStatesIPoller.StateDir = cfg.StateDir
 	crStatesPoller.Interval = time.Duration(cfg.CRStatesPollIntervalMS) * time.Millisecond
+	startPoller(crStatesPoller, "CRStates")
+}
 
-	if err := crStatesPoller.Start(); err != nil {
-		fmt.Println("ERROR: updating CRStates Poller: starting: " + err.Error())
-		// TODO fatal?
-	}
+// UpdateCRConfigPoller sets the config and monitor client of the CRConfig poller, and starts it. The poller must be stopped.
+func UpdateCRConfigPoller(crConfigPoller *poller.Poller, crConfigIPoller *pollercrconfig.IPoller, cfg *config.Config, monitorClient *monitorclient.Client) {
+	crConfigIPoller.Client = monitorClient
+	crConfigIPoller.StateDir = cfg.StateDir
+	crConfigPoller.Interval = time.Duration(cfg.CRConfigPollIntervalMS) * time.Millisecond
+	startPoller(crConfigPoller, "CRConfig")
 }
 
-func UpdateCRConfigPoller(crConfigPoller *poller.Poller, crConfigIPoller *pollercrconfig.IPoller, cfg *config.Config, shared *shared.Shared) {
-	if err := crConfigPoller.Stop(); err != nil {
-		fmt.Println("ERROR: updating CRStates Poller: stopping: " + err.Error())
+func stopPoller(po *poller.Poller, name string) {
+	if err := po.Stop(); err != nil {
+		fmt.Println("ERROR: updating " + name + " Poller: stopping: " + err.Error())
 	}
+}
 
-	crConfigIPoller.Monitors = cfg.Monitors
-	crConfigIPoller.Shared = shared
-	crConfigPoller.Interval = time.Duration(cfg.CRConfigPollIntervalMS) * time.Millisecond
-
-	if err := crConfigPoller.Start(); err != nil {
-		fmt.Println("ERROR: updating CRConfig Poller: starting: " + err.Error())
+func startPoller(po *poller.Poller, name string) {
+	if err := po.Start(); err != nil {
+		fmt.Println("ERROR: updating " + name + " Poller: starting: " + err.Error())
 		// TODO fatal?
 	}
 }
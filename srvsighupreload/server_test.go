@@ -0,0 +1,68 @@
+package srvsighupreload
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfigdiff"
+	"github.com/rob05c/traffic_router/shared"
+	"github.com/rob05c/traffic_router/srvdns"
+	"github.com/rob05c/traffic_router/srvhttp"
+)
+
+// newTestShared returns a Shared whose CRConfig has the single Delivery Service and server named name.
+func newTestShared(t *testing.T, name string) *shared.Shared {
+	t.Helper()
+	crc := &tc.CRConfig{
+		Config:           map[string]interface{}{"domain_name": "cdn.example.net"},
+		DeliveryServices: map[string]tc.CRConfigDeliveryService{name: {}},
+		ContentServers:   map[string]tc.CRConfigTrafficOpsServer{name: {}},
+	}
+	sh := shared.NewShared(nil, nil, nil, crc, &tc.CRStates{}, nil, nil)
+	if sh == nil {
+		t.Fatal("NewShared returned nil")
+	}
+	return sh
+}
+
+func TestSwapConcurrent(t *testing.T) {
+	const numSwaps = 50
+	first := newTestShared(t, "first")
+	sharedPtr := shared.NewPtr(first)
+	dnsServer := srvdns.NewPtr(&srvdns.Server{Shared: first})
+	httpServer := srvhttp.NewPtr(&srvhttp.Server{Shared: first})
+	crcDiffs := crconfigdiff.NewHistory(numSwaps)
+
+	shareds := []*shared.Shared{}
+	for i := 0; i < numSwaps; i++ {
+		shareds = append(shareds, newTestShared(t, strconv.Itoa(i)))
+	}
+	wg := sync.WaitGroup{}
+	for _, sh := range shareds {
+		wg.Add(1)
+		go func(sh *shared.Shared) {
+			defer wg.Done()
+			Swap(sh, sharedPtr, dnsServer, httpServer, crcDiffs, "poll")
+		}(sh)
+	}
+	wg.Wait()
+
+	// each diff must be against the Shared the swap replaced, so the diffs chain from the first Shared to the one being served
+	diffs := crcDiffs.Get()
+	if len(diffs) != numSwaps {
+		t.Fatalf("%d diffs, expected %d", len(diffs), numSwaps)
+	}
+	prev := "first"
+	for i := len(diffs) - 1; i >= 0; i-- {
+		ds := diffs[i].DeliveryServices
+		if len(ds.Removed) != 1 || ds.Removed[0] != prev || len(ds.Added) != 1 {
+			t.Fatalf("diff %d removed %v added %v, expected it to replace '%s'", len(diffs)-i, ds.Removed, ds.Added, prev)
+		}
+		prev = ds.Added[0]
+	}
+	if _, ok := sharedPtr.Get().GetCRConfig().DeliveryServices[prev]; !ok {
+		t.Errorf("serving a Shared other than '%s', the last one swapped in", prev)
+	}
+}
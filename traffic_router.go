@@ -10,9 +10,15 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/rob05c/traffic_router/crconfigdiff"
+	"github.com/rob05c/traffic_router/guard"
 	"github.com/rob05c/traffic_router/loadconfig"
+	"github.com/rob05c/traffic_router/monitorclient"
+	"github.com/rob05c/traffic_router/poller"
 	"github.com/rob05c/traffic_router/pollercrconfig"
 	"github.com/rob05c/traffic_router/pollercrstates"
+	"github.com/rob05c/traffic_router/shared"
+	"github.com/rob05c/traffic_router/srvadmin"
 	"github.com/rob05c/traffic_router/srvdns"
 	"github.com/rob05c/traffic_router/srvhttp"
 	"github.com/rob05c/traffic_router/srvsighupreload"
@@ -22,23 +28,42 @@ import (
 
 func main() {
 	cfgFile := flag.String("cfg", "", "Config file path")
+	validateCZFFile := flag.String("validate-czf", "", "Coverage Zone File to validate. Writes the problems as JSON and exits, without serving")
+	validateCRConfigFile := flag.String("validate-crconfig", "", "CRConfig to validate the -validate-czf zones against the cachegroups of (optional)")
 	flag.Parse()
+	if *validateCZFFile != "" {
+		os.Exit(validateCZF(*validateCZFFile, *validateCRConfigFile))
+	}
 	if *cfgFile == "" {
 		fmt.Println("usage: ./dnstest -cfg config/file/path.json")
 		os.Exit(1)
 	}
 
-	shared, cfg, err := loadconfig.LoadConfig(*cfgFile)
+	sh, cfg, err := loadconfig.LoadConfig(*cfgFile)
 	if err != nil {
 		fmt.Println("Error loading config file '" + *cfgFile + "': " + err.Error())
 		os.Exit(1)
 	}
 
+	sharedPtr := shared.NewPtr(sh)
+	dnsSvr := srvdns.NewPtr(&srvdns.Server{Shared: sh})
+	httpSvr := srvhttp.NewPtr(&srvhttp.Server{Shared: sh})
+
+	crcGuard := guard.New(cfg.CRConfigGuardMaxDropPercent)
+	crcDiffs := crconfigdiff.NewHistory(cfg.CRConfigDiffHistorySize)
+	monitorClient := monitorclient.New(time.Duration(cfg.MonitorConnectTimeoutMS)*time.Millisecond, time.Duration(cfg.MonitorTimeoutMS)*time.Millisecond)
+
+	// the pollers share the monitors, so both poll the monitors discovered from each new CRConfig
+	monitors := monitorclient.NewMonitors(cfg.Monitors)
+	monitors.SetFromCRConfig(sh.GetCRConfig())
+
 	crStatesPollInterval := time.Duration(cfg.CRStatesPollIntervalMS) * time.Millisecond
-	crStatesPoller, crStatesIPoller := pollercrstates.MakePoller(crStatesPollInterval, cfg.Monitors, shared)
+	crStatesPoller, crStatesIPoller := pollercrstates.MakePoller(crStatesPollInterval, monitors, monitorClient, cfg.StateDir, sharedPtr, poller.MakeResultLogger("pollercrstates"))
 
 	crConfigPollInterval := time.Duration(cfg.CRConfigPollIntervalMS) * time.Millisecond
-	crConfigPoller, crConfigIPoller := pollercrconfig.MakePoller(crConfigPollInterval, cfg.Monitors, shared)
+	crConfigPoller, crConfigIPoller := pollercrconfig.MakePoller(crConfigPollInterval, monitors, monitorClient, cfg.StateDir, sharedPtr, crcGuard, func(newShared *shared.Shared) {
+		srvsighupreload.Swap(newShared, sharedPtr, dnsSvr, httpSvr, crcDiffs, "poll")
+	}, poller.MakeResultLogger("pollercrconfig"))
 
 	if err := crStatesPoller.Start(); err != nil {
 		fmt.Println("Error starting CRStates poller: " + err.Error())
@@ -50,9 +75,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	dnsSvr := srvdns.NewPtr(&srvdns.Server{Shared: shared})
-	httpSvr := srvhttp.NewPtr(&srvhttp.Server{Shared: shared})
-
 	// TODO add default cert, for when no match is found
 	certGetter := &srvhttp.CertGetter{}
 
@@ -121,8 +143,22 @@ func main() {
 		}
 	}()
 
+	if cfg.AdminAddr != "" {
+		go func() {
+			svr := &http.Server{
+				Handler: srvadmin.New(crcGuard, crcDiffs),
+				Addr:    cfg.AdminAddr,
+			}
+			fmt.Println("Serving admin HTTP on " + cfg.AdminAddr + "...")
+			if err := svr.ListenAndServe(); err != nil {
+				log.Fatalf("ERROR: admin HTTP listener %s\n", err.Error())
+			}
+		}()
+	}
+
 	srvsighupreload.Listen(
 		*cfgFile,
+		sharedPtr,
 		dnsSvr,
 		httpSvr,
 		certGetter,
@@ -130,5 +166,7 @@ func main() {
 		crStatesIPoller,
 		crConfigPoller,
 		crConfigIPoller,
+		crcGuard,
+		crcDiffs,
 	)
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/apache/trafficcontrol/lib/go-tc"
+	"github.com/rob05c/traffic_router/crconfig"
+	"github.com/rob05c/traffic_router/czf"
+)
+
+// validateCZF validates the CZF at czfPath, and writes the czf.Report as JSON to stdout, for CI.
+// If crcPath isn't empty, the CZF zones are checked against the CRConfig cachegroups.
+// Returns the process exit code: 0 if the CZF has no errors, 1 if it does, or 2 if the CRConfig can't be loaded.
+func validateCZF(czfPath string, crcPath string) int {
+	crc := (*tc.CRConfig)(nil)
+	if crcPath != "" {
+		err := error(nil)
+		if crc, err = crconfig.LoadCRConfig(crcPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading CRConfig '"+crcPath+"': "+err.Error())
+			return 2
+		}
+	}
+	report := czf.ValidateFile(czfPath, crc)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing report: "+err.Error())
+		return 2
+	}
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}